@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gofeather/internal/client"
+	"golang.org/x/term"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stdin is shared by the prompts, so input piped in for one prompt isn't lost to the buffer of another
+var stdin = bufio.NewReader(os.Stdin)
+
+// credentials is the login cached between runs, only readable by the user
+type credentials struct {
+	Server       string    `json:"server"`
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// credentialsPath is $FEATHERCTL_CREDENTIALS or featherctl/credentials.json in the user config directory
+func credentialsPath() (string, error) {
+	if path := os.Getenv("FEATHERCTL_CREDENTIALS"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "featherctl", "credentials.json"), nil
+}
+
+// loadCredentials returns the cached login, nil when not logged in
+func loadCredentials() (*credentials, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cached credentials
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, fmt.Errorf("%s is corrupt: %w", path, err)
+	}
+	return &cached, nil
+}
+
+func (c *credentials) save() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// token returns the cached access token, refreshing it first when it has expired
+func (c *credentials) token(ctx context.Context, featherClient *client.Client) string {
+	if time.Now().Before(c.ExpiresAt) || c.RefreshToken == "" {
+		return c.AccessToken
+	}
+
+	tokens, err := featherClient.Refresh(ctx, c.RefreshToken)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "featherctl: login expired, run featherctl login again:", err)
+		return ""
+	}
+	c.AccessToken = tokens.AccessToken
+	c.RefreshToken = tokens.RefreshToken
+	c.ExpiresAt = tokens.ExpiresAt()
+	if err := c.save(); err != nil {
+		fmt.Fprintln(os.Stderr, "featherctl: unable to cache the refreshed login:", err)
+	}
+	return c.AccessToken
+}
+
+func login(ctx context.Context, cli *cli, args []string) error {
+	var username, email, password string
+	cli.flags.StringVar(&username, "username", "", "username to log in with")
+	cli.flags.StringVar(&email, "email", "", "email address to log in with")
+	cli.flags.StringVar(&password, "password", "", "password, prompted for when not given")
+	if _, err := cli.parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if username == "" && email == "" {
+		if username, err = prompt("Username: ", false); err != nil {
+			return err
+		}
+	}
+	if password == "" {
+		if password, err = prompt("Password: ", true); err != nil {
+			return err
+		}
+	}
+
+	featherClient := client.New(cli.serverURL(nil))
+	tokens, err := featherClient.Login(ctx, username, email, password)
+	if err != nil {
+		return err
+	}
+
+	cached := &credentials{
+		Server:       featherClient.BaseURL,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.ExpiresAt(),
+	}
+	if err := cached.save(); err != nil {
+		return fmt.Errorf("logged in but unable to cache the login: %w", err)
+	}
+	fmt.Println("Logged in to", featherClient.BaseURL)
+	return nil
+}
+
+func logout(ctx context.Context, cli *cli, args []string) error {
+	if _, err := cli.parse(args); err != nil {
+		return err
+	}
+
+	cached, err := loadCredentials()
+	if err != nil || cached == nil {
+		return err
+	}
+	featherClient := client.New(cached.Server)
+	featherClient.Token = cached.AccessToken
+	if err := featherClient.Logout(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "featherctl: unable to end the session on the server:", err)
+	}
+
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prompt reads a line from the terminal, without echoing it when secret
+func prompt(label string, secret bool) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	if secret && term.IsTerminal(int(os.Stdin.Fd())) {
+		value, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(value), err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gofeather/internal/client"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// flagCommands are the subcommands of featherctl flags
+var flagCommands = map[string]func(ctx context.Context, cli *cli, args []string) error{
+	"list":   listFlags,
+	"create": createFlag,
+	"toggle": toggleFlag,
+	"eval":   evaluateFlag,
+}
+
+func manageFlags(ctx context.Context, cli *cli, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: flags expects one of list, create, toggle or eval", errUsage)
+	}
+	command, exists := flagCommands[args[0]]
+	if !exists {
+		return fmt.Errorf("%w: unknown flags command %q", errUsage, args[0])
+	}
+	return command(ctx, newCLI("flags "+args[0]), args[1:])
+}
+
+func listFlags(ctx context.Context, cli *cli, args []string) error {
+	if _, err := cli.parse(args); err != nil {
+		return err
+	}
+
+	flags, err := cli.client(ctx).ListFlags(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cli.output == outputJSON {
+		return printJSON(flags)
+	}
+	rows := make([][]string, 0, len(flags))
+	for _, flag := range flags {
+		rows = append(rows, []string{flag.Name, strconv.FormatBool(flag.Enabled), formatFilters(flag.Filters)})
+	}
+	return printTable([]string{"NAME", "ENABLED", "FILTERS"}, rows)
+}
+
+func createFlag(ctx context.Context, cli *cli, args []string) error {
+	var roles stringList
+	var from, until string
+	enabled := cli.flags.Bool("enabled", false, "create the flag enabled")
+	cli.flags.Var(&roles, "role", "role a user needs for the flag, may be repeated")
+	cli.flags.StringVar(&from, "from", "", "start of the window the flag is on in, RFC 3339")
+	cli.flags.StringVar(&until, "until", "", "end of the window the flag is on in, RFC 3339")
+	positional, err := cli.parse(args, "name")
+	if err != nil {
+		return err
+	}
+
+	flag := client.FeatureFlag{Name: positional[0], Enabled: *enabled, Filters: make([]client.FlagFilter, 0)}
+	if len(roles) > 0 {
+		flag.Filters = append(flag.Filters, client.FlagFilter{Type: "RoleFilter", Roles: roles})
+	}
+	if from != "" || until != "" {
+		if from == "" || until == "" {
+			return fmt.Errorf("%w: --from and --until must be given together", errUsage)
+		}
+		start, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return fmt.Errorf("%w: --from: %v", errUsage, err)
+		}
+		stop, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return fmt.Errorf("%w: --until: %v", errUsage, err)
+		}
+		flag.Filters = append(flag.Filters, client.FlagFilter{Type: "TimeFilter", TimeStart: &start, TimeStop: &stop})
+	}
+
+	if err := cli.client(ctx).CreateFlag(ctx, flag); err != nil {
+		return err
+	}
+	if cli.output == outputJSON {
+		return printJSON(flag)
+	}
+	fmt.Printf("Created flag %s, enabled: %v\n", flag.Name, flag.Enabled)
+	return nil
+}
+
+func toggleFlag(ctx context.Context, cli *cli, args []string) error {
+	positional, err := cli.parse(args, "name")
+	if err != nil {
+		return err
+	}
+
+	flag, err := cli.client(ctx).ToggleFlag(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	if cli.output == outputJSON {
+		return printJSON(flag)
+	}
+	fmt.Printf("Flag %s enabled: %v\n", flag.Name, flag.Enabled)
+	return nil
+}
+
+func evaluateFlag(ctx context.Context, cli *cli, args []string) error {
+	var roles stringList
+	cli.flags.Var(&roles, "role", "role of the user to evaluate the flag for, may be repeated")
+	positional, err := cli.parse(args, "name")
+	if err != nil {
+		return err
+	}
+
+	enabled, err := cli.client(ctx).EvaluateFlag(ctx, positional[0], roles)
+	if err != nil {
+		return err
+	}
+	if cli.output == outputJSON {
+		return printJSON(map[string]interface{}{"name": positional[0], "enabled": enabled})
+	}
+	fmt.Printf("Flag %s is %s\n", positional[0], map[bool]string{true: "on", false: "off"}[enabled])
+	return nil
+}
+
+func formatFilters(filters []client.FlagFilter) string {
+	described := make([]string, 0, len(filters))
+	for _, filter := range filters {
+		switch filter.Type {
+		case "RoleFilter":
+			described = append(described, "roles: "+strings.Join(filter.Roles, ","))
+		case "TimeFilter":
+			if filter.TimeStart != nil && filter.TimeStop != nil {
+				described = append(described, fmt.Sprintf("from %s until %s",
+					filter.TimeStart.Format(time.RFC3339), filter.TimeStop.Format(time.RFC3339)))
+			}
+		default:
+			described = append(described, filter.Type)
+		}
+	}
+	return orDash(strings.Join(described, "; "))
+}
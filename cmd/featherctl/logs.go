@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gofeather/internal/client"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func listDomains(ctx context.Context, cli *cli, args []string) error {
+	if _, err := cli.parse(args); err != nil {
+		return err
+	}
+
+	domains, err := cli.client(ctx).ListDomains(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cli.output == outputJSON {
+		return printJSON(domains)
+	}
+	rows := make([][]string, 0, len(domains))
+	for _, domain := range domains {
+		rows = append(rows, []string{domain})
+	}
+	return printTable([]string{"DOMAIN"}, rows)
+}
+
+// addQueryFlags adds the log filter flags shared by query and tail
+func addQueryFlags(cli *cli, query *client.LogQuery) {
+	cli.flags.StringVar(&query.Group, "group", "", "only logs of this group")
+	cli.flags.StringVar(&query.Tag, "tag", "", "only logs with this tag")
+	cli.flags.StringVar(&query.Level, "level", "", "only logs of this level")
+	cli.flags.StringVar(&query.ExceptionType, "exception-type", "", "only logs with an exception of this type")
+}
+
+func queryLogs(ctx context.Context, cli *cli, args []string) error {
+	query := client.LogQuery{}
+	addQueryFlags(cli, &query)
+	cli.flags.Int64Var(&query.Limit, "limit", 50, "maximum amount of logs, 0 for all")
+	cli.flags.StringVar(&query.TimeField, "time-field", "", "time to filter and sort on, received_at or event_time")
+	cli.flags.StringVar(&query.From, "from", "", "only logs at or after this time, unix milliseconds or RFC 3339")
+	cli.flags.StringVar(&query.To, "to", "", "only logs at or before this time, unix milliseconds or RFC 3339")
+	positional, err := cli.parse(args, "domain")
+	if err != nil {
+		return err
+	}
+
+	entries, err := cli.client(ctx).GetLogs(ctx, positional[0], query)
+	if err != nil {
+		return err
+	}
+
+	if cli.output == outputJSON {
+		return printJSON(entries)
+	}
+	rows := make([][]string, 0, len(entries))
+	for _, entry := range entries {
+		rows = append(rows, []string{
+			formatTimestamp(entryTime(entry, query.TimeField)),
+			orDash(entry.Level),
+			orDash(entry.Group),
+			orDash(entry.Tag),
+			singleLine(entry.Log),
+		})
+	}
+	return printTable([]string{"TIME", "LEVEL", "GROUP", "TAG", "LOG"}, rows)
+}
+
+// tailLogs prints entries as they are ingested until interrupted, one JSON document per line in json output
+func tailLogs(ctx context.Context, cli *cli, args []string) error {
+	query := client.LogQuery{}
+	addQueryFlags(cli, &query)
+	noColor := cli.flags.Bool("no-color", false, "don't color the levels")
+	positional, err := cli.parse(args, "domain")
+	if err != nil {
+		return err
+	}
+
+	color := !*noColor && colorEnabled()
+	encoder := json.NewEncoder(os.Stdout)
+	return cli.client(ctx).Tail(ctx, positional[0], query, func(entry client.LogEntry) error {
+		if cli.output == outputJSON {
+			return encoder.Encode(entry)
+		}
+		_, err := fmt.Println(formatTailLine(entry, color))
+		return err
+	})
+}
+
+// diffLogs prints the message templates that are new, gone or changed in frequency between two windows,
+// the windows default to an hour before and after --deploy
+func diffLogs(ctx context.Context, cli *cli, args []string) error {
+	query := client.DiffQuery{}
+	addQueryFlags(cli, &query.LogQuery)
+	cli.flags.StringVar(&query.Deploy, "deploy", "", "time of the deployment to compare the logs around")
+	cli.flags.StringVar(&query.Window, "window", "", "length of the windows around the deployment, like 30m")
+	cli.flags.StringVar(&query.BaselineFrom, "baseline-from", "", "start of the baseline window instead of --deploy")
+	cli.flags.StringVar(&query.BaselineTo, "baseline-to", "", "end of the baseline window")
+	cli.flags.StringVar(&query.CompareFrom, "compare-from", "", "start of the compare window")
+	cli.flags.StringVar(&query.CompareTo, "compare-to", "", "end of the compare window")
+	cli.flags.StringVar(&query.TimeField, "time-field", "", "time the windows use, received_at or event_time")
+	cli.flags.Float64Var(&query.Ratio, "ratio", 0, "minimum change in frequency of a changed template, 2 when 0")
+	cli.flags.Int64Var(&query.Limit, "limit", 20, "maximum amount of templates per list")
+	positional, err := cli.parse(args, "domain")
+	if err != nil {
+		return err
+	}
+
+	diff, err := cli.client(ctx).GetLogDiff(ctx, positional[0], query)
+	if err != nil {
+		return err
+	}
+
+	if cli.output == outputJSON {
+		return printJSON(diff)
+	}
+	rows := make([][]string, 0, len(diff.New)+len(diff.Gone)+len(diff.Changed))
+	for _, list := range []struct {
+		change    string
+		templates []client.TemplateChange
+	}{{"new", diff.New}, {"gone", diff.Gone}, {"changed", diff.Changed}} {
+		for _, template := range list.templates {
+			rows = append(rows, []string{
+				list.change,
+				strconv.Itoa(template.BaselineCount),
+				strconv.Itoa(template.CompareCount),
+				strconv.FormatFloat(template.Score, 'f', 1, 64),
+				singleLine(template.Template),
+			})
+		}
+	}
+	return printTable([]string{"CHANGE", "BEFORE", "AFTER", "SCORE", "TEMPLATE"}, rows)
+}
+
+// importLogs streams an export file, or stdin for -, to the server. Files ending in .gz are sent compressed
+// and the format defaults to csv for .csv files and json otherwise.
+func importLogs(ctx context.Context, cli *cli, args []string) error {
+	options := client.ImportOptions{}
+	cli.flags.StringVar(&options.Domain, "domain", "", "replay the entries into this domain instead of their own")
+	cli.flags.StringVar(&options.Format, "format", "", "json or csv, guessed from the file name when empty")
+	cli.flags.BoolVar(&options.KeepDuplicates, "keep-duplicates", false, "store entries again that were imported before")
+	apiKey := cli.flags.String("api-key", os.Getenv("FEATHERCTL_API_KEY"), "trusted ingest key, defaults to $FEATHERCTL_API_KEY")
+	positional, err := cli.parse(args, "file")
+	if err != nil {
+		return err
+	}
+	if *apiKey == "" {
+		return fmt.Errorf("%w: import needs a trusted ingest key, pass --api-key or set FEATHERCTL_API_KEY", errUsage)
+	}
+
+	name := positional[0]
+	var file io.Reader = os.Stdin
+	if name != "-" {
+		opened, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer opened.Close()
+		file = opened
+	}
+	base := strings.TrimSuffix(strings.ToLower(name), ".gz")
+	options.Gzipped = base != strings.ToLower(name)
+	if options.Format == "" {
+		options.Format = "json"
+		if strings.HasSuffix(base, ".csv") {
+			options.Format = "csv"
+		}
+	}
+
+	featherClient := cli.client(ctx)
+	featherClient.APIKey = *apiKey
+	result, err := featherClient.ImportLogs(ctx, file, options)
+	if err != nil {
+		return err
+	}
+
+	if cli.output == outputJSON {
+		return printJSON(result)
+	}
+	return printTable([]string{"READ", "IMPORTED", "DUPLICATES"}, [][]string{{
+		strconv.FormatInt(result.Read, 10),
+		strconv.FormatInt(result.Imported, 10),
+		strconv.FormatInt(result.Duplicates, 10),
+	}})
+}
+
+// entryTime returns the time of an entry the query was sorted on, entries without an event time use their receive time
+func entryTime(entry client.LogEntry, timeField string) int64 {
+	if timeField == "event_time" && entry.EventTime != 0 {
+		return entry.EventTime
+	}
+	return entry.Timestamp
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"gofeather/internal/client"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const usage = `featherctl queries, tails and manages a GoFeather server
+
+Usage:
+  featherctl login [--username name | --email address] [--password password]
+  featherctl logout
+  featherctl domains
+  featherctl query <domain> [--group g] [--tag t] [--level l] [--exception-type e] [--limit n]
+                   [--time-field received_at|event_time] [--from time] [--to time]
+  featherctl tail <domain> [--group g] [--tag t] [--level l] [--exception-type e] [--no-color]
+  featherctl diff <domain> (--deploy time [--window 1h] | --baseline-from t --baseline-to t --compare-from t --compare-to t)
+  featherctl import <file> [--domain d] [--format json|csv] [--keep-duplicates] [--api-key key]
+  featherctl flags list
+  featherctl flags create <name> [--enabled] [--role r]... [--from time --until time]
+  featherctl flags toggle <name>
+  featherctl flags eval <name> [--role r]...
+
+Every command accepts:
+  --server url       server to talk to, defaults to $FEATHERCTL_SERVER, the server logged in to or http://localhost:8080
+  --output format    table or json
+  --flags-route      feature_flags_route of the server, defaults to featureflags
+`
+
+// commands maps the command names to their implementation, each parses its own arguments with cli.parse
+var commands = map[string]func(ctx context.Context, cli *cli, args []string) error{
+	"login":   login,
+	"logout":  logout,
+	"domains": listDomains,
+	"query":   queryLogs,
+	"tail":    tailLogs,
+	"diff":    diffLogs,
+	"import":  importLogs,
+	"flags":   manageFlags,
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "help" || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Print(usage)
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "featherctl:", err)
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, name string, args []string) error {
+	command, exists := commands[name]
+	if !exists {
+		return fmt.Errorf("%w: unknown command %q, run featherctl help for the list of commands", errUsage, name)
+	}
+	return command(ctx, newCLI(name), args)
+}
+
+// errUsage marks errors caused by wrong arguments
+var errUsage = errors.New("invalid usage")
+
+// cli holds the options shared by all commands
+type cli struct {
+	flags      *flag.FlagSet
+	server     string
+	output     string
+	flagsRoute string
+}
+
+func newCLI(name string) *cli {
+	c := &cli{flags: flag.NewFlagSet(name, flag.ContinueOnError)}
+	c.flags.StringVar(&c.server, "server", "", "server to talk to")
+	c.flags.StringVar(&c.output, "output", outputTable, "output format, table or json")
+	c.flags.StringVar(&c.flagsRoute, "flags-route", "", "feature_flags_route of the server")
+	c.flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	return c
+}
+
+// parse parses the flags of the command, which may be mixed with its positional arguments,
+// and checks that exactly the expected amount of positional arguments is given
+func (c *cli) parse(args []string, expected ...string) ([]string, error) {
+	var positional []string
+	for {
+		if err := c.flags.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if c.flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, c.flags.Arg(0))
+		args = c.flags.Args()[1:]
+	}
+
+	if len(positional) != len(expected) {
+		if len(expected) == 0 {
+			return nil, fmt.Errorf("%w: %s takes no arguments", errUsage, c.flags.Name())
+		}
+		return nil, fmt.Errorf("%w: %s expects <%s>", errUsage, c.flags.Name(), strings.Join(expected, "> <"))
+	}
+	if c.output != outputTable && c.output != outputJSON {
+		return nil, fmt.Errorf("%w: output must be either table or json", errUsage)
+	}
+	return positional, nil
+}
+
+// client creates a client for the server, signed in with the cached token when it belongs to that server
+func (c *cli) client(ctx context.Context) *client.Client {
+	cached, err := loadCredentials()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "featherctl: ignoring cached login:", err)
+	}
+
+	server := c.serverURL(cached)
+	featherClient := client.New(server)
+	featherClient.FlagsRoute = c.flagsRoute
+	if cached != nil && cached.Server == server {
+		featherClient.Token = cached.token(ctx, featherClient)
+	}
+	return featherClient
+}
+
+func (c *cli) serverURL(cached *credentials) string {
+	switch {
+	case c.server != "":
+		return strings.TrimRight(c.server, "/")
+	case os.Getenv("FEATHERCTL_SERVER") != "":
+		return strings.TrimRight(os.Getenv("FEATHERCTL_SERVER"), "/")
+	case cached != nil && cached.Server != "":
+		return cached.Server
+	default:
+		return "http://localhost:8080"
+	}
+}
+
+// stringList is a flag that may be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gofeather/internal/client"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+
+	timeLayout = "2006-01-02 15:04:05.000"
+
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorYellow = "\033[33m"
+	colorGreen  = "\033[32m"
+	colorGray   = "\033[90m"
+)
+
+// levelColors colors the levels when tailing, other levels are printed as is
+var levelColors = map[string]string{
+	"panic":    colorRed,
+	"fatal":    colorRed,
+	"critical": colorRed,
+	"error":    colorRed,
+	"warn":     colorYellow,
+	"warning":  colorYellow,
+	"info":     colorGreen,
+	"debug":    colorGray,
+	"trace":    colorGray,
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// printTable prints the rows aligned under the header
+func printTable(header []string, rows [][]string) error {
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+func formatTimestamp(timestamp int64) string {
+	if timestamp == 0 {
+		return "-"
+	}
+	return time.UnixMilli(timestamp).Local().Format(timeLayout)
+}
+
+// singleLine keeps multi-line messages such as stack traces on one row of a table
+func singleLine(message string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(message, "\r", ""), "\n", " ⏎ ")
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// formatTailLine formats an entry as a single line while tailing, with the level colored when color is on
+func formatTailLine(entry client.LogEntry, color bool) string {
+	level := strings.ToUpper(orDash(entry.Level))
+	if code, exists := levelColors[strings.ToLower(entry.Level)]; exists && color {
+		level = code + level + colorReset
+	}
+
+	source := entry.Group
+	if entry.Tag != "" {
+		source += "/" + entry.Tag
+	}
+	return fmt.Sprintf("%s %s [%s] %s", formatTimestamp(entry.Timestamp), level, orDash(source), entry.Log)
+}
+
+// colorEnabled tells if stdout is a terminal that should be colored, NO_COLOR turns color off
+func colorEnabled() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"gofeather/internal/agent"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// featherlog-agent follows log files on a host and ships their lines to a GoFeather server
+func main() {
+	configPath := flag.String("config", "config/agent.yml", "path to the agent configuration file")
+	flag.Parse()
+
+	cfg, err := agent.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load agent configuration: ", err)
+	}
+
+	featherAgent, err := agent.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to start agent: ", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Shipping logs to %s", cfg.Server)
+	if err := featherAgent.Run(ctx); err != nil {
+		log.Fatal("Failed to save agent state: ", err)
+	}
+}
@@ -10,15 +10,31 @@ import (
 	"github.com/swaggo/files"
 	"github.com/swaggo/gin-swagger"
 	"go.mongodb.org/mongo-driver/mongo"
+	"gofeather/internal/accesslog"
+	"gofeather/internal/annotations"
+	"gofeather/internal/anomaly"
+	"gofeather/internal/archive"
 	"gofeather/internal/auth"
 	"gofeather/internal/constants"
 	"gofeather/internal/database"
 	"gofeather/internal/featureflags"
+	"gofeather/internal/forwarding"
+	"gofeather/internal/listeners"
 	"gofeather/internal/logging"
+	"gofeather/internal/metrics"
+	"gofeather/internal/tenancy"
+	"google.golang.org/grpc"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
+// shutdownTimeout bounds how long open requests and the ingest queue are waited for on shutdown
+const shutdownTimeout = 30 * time.Second
+
 //	@title			GoFeather API
 //	@version		0.1
 //	@description	This is the api docs for the featherlog application, it will show all routes, even the disabled ones.
@@ -63,11 +79,48 @@ func main() {
 
 	//Create a new gin server
 	log.Println("Starting REST API")
-	server := gin.Default()
+	server := gin.New()
+	server.Use(gin.Recovery())
+
+	//Recording the requests into GoFeather itself, the middleware has to be added before the routes
+	var accessLog *accesslog.Recorder
+	if config.Bool(constants.AccessLogFeature) {
+		accessLogConfig := accesslog.LoadConfig()
+		accessLog = accesslog.NewRecorder(accessLogConfig)
+		server.Use(accessLog.Middleware())
+		if accessLogConfig.Stdout {
+			server.Use(gin.Logger())
+		}
+	} else {
+		server.Use(gin.Logger())
+	}
 
 	//Setting up routes
+	var tenants *tenancy.Registry
+	if config.Bool(constants.TenancyFeature) {
+		tenants = tenancy.CreateRoutes(server, mongoDB)
+	}
+	var logComponents *logging.Components
+	var grpcServer *grpc.Server
 	if config.Bool(constants.LogFeature) {
-		logging.CreateRoutes(server, mongoDB)
+		logComponents = logging.CreateRoutes(server, mongoDB, tenants)
+		if config.Bool(constants.GrpcFeature) {
+			grpcServer = logging.StartGRPCServer(config.String(constants.GrpcAddress), logComponents)
+		}
+		forwarding.Start(forwarding.LoadConfig(), logComponents.Pipeline)
+		listeners.Start(listeners.LoadConfig(), logComponents.Pipeline)
+		if accessLog != nil {
+			accessLog.Start(logComponents.Pipeline, tenants)
+		}
+		if config.Bool(constants.ArchiveFeature) {
+			archive.CreateRoutes(server, mongoDB, logComponents.LogRepo, tenants)
+		}
+		if config.Bool(constants.AnomalyFeature) {
+			anomaly.CreateRoutes(server, mongoDB, logComponents.Pipeline, tenants)
+		}
+		if config.Bool(constants.AnnotationFeature) {
+			annotations.CreateRoutes(server, mongoDB, logComponents.LogRepo, tenants)
+		}
 	}
 	if config.Bool(constants.FeatureFlagFeature) {
 		featureflags.Init(server, mongoDB)
@@ -77,14 +130,73 @@ func main() {
 		auth.Init(server, postgresConn)
 	}
 
+	if config.Bool(constants.MetricsFeature) {
+		//The metrics are labeled with the domains of every tenant, so only super admins may read them, without tenancy any user
+		server.GET("/metrics", tenants.Authenticate(), tenants.RequireSuperAdmin(), metrics.Handler())
+	}
+
 	//Setup swagger route
 	server.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	//Run server after establishing routes
-	runErr := server.Run()
-	if runErr != nil {
-		log.Fatalln(runErr)
+	//Run server after establishing routes, until the process is asked to stop
+	httpServer := &http.Server{Addr: address(), Handler: server}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening and serving HTTP on %s", httpServer.Addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	stop, cancelStop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancelStop()
+	select {
+	case err := <-serveErr:
+		log.Fatalln(err)
+	case <-stop.Done():
+	}
+
+	log.Println("Shutting down")
+	shutdown(httpServer, grpcServer, logComponents)
+}
+
+// shutdown stops taking requests, waiting for the open ones, and then stores the log entries that were accepted
+// but not inserted yet. Both steps get their own timeout, so open tail streams don't eat into storing the entries.
+func shutdown(httpServer *http.Server, grpcServer *grpc.Server, logComponents *logging.Components) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Closing the open HTTP requests: %v", err)
+		_ = httpServer.Close()
+	}
+	if grpcServer != nil {
+		//Tail streams stay open, so they are cut once the timeout passed
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
+	}
+
+	if logComponents != nil {
+		storeCtx, cancelStore := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelStore()
+		if err := logComponents.Shutdown(storeCtx); err != nil {
+			log.Printf("Failed to store the queued log entries: %v", err)
+		}
+	}
+}
+
+// address returns the address to listen on, the PORT environment variable sets the port like it does for gin
+func address() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
 	}
+	return ":8080"
 }
 
 // loadConfig loads the configuration file to be used by the application
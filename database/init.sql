@@ -15,4 +15,6 @@ CREATE TABLE IF NOT EXISTS "sessions"
     token         TEXT,
     refresh_token VARCHAR(255),
     expiry        TIMESTAMP
-);
\ No newline at end of file
+);
+
+CREATE INDEX IF NOT EXISTS sessions_token_idx ON "sessions" (token);
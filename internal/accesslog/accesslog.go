@@ -0,0 +1,257 @@
+package accesslog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/auth"
+	"gofeather/internal/constants"
+	"gofeather/internal/logging"
+	"gofeather/internal/metrics"
+	"gofeather/internal/tenancy"
+	"gofeather/internal/utility"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// RequestIDHeader carries the id of a request, it is generated when the client doesn't send one
+	RequestIDHeader = "X-Request-ID"
+
+	requestIDContextKey  = "request_id"
+	maxRequestIDLength   = 128
+	defaultDomain        = "gofeather_access"
+	defaultGroup         = "access"
+	defaultBufferSize    = 10000
+	defaultBatchSize     = 500
+	defaultFlushInterval = time.Second
+)
+
+// streamRoutes are never recorded, their entries would show up in the streams they are recorded from
+var streamRoutes = []string{"/log/:domain/tail"}
+
+// Config sets the domain and group the requests are recorded in, the ingest key applies rate limits and tenancy
+// like it does for listeners. Routes or paths in Exclude are not recorded. With Stdout the requests are printed
+// as well, like the default Gin logger does.
+type Config struct {
+	Domain        string   `mapstructure:"domain"`
+	Group         string   `mapstructure:"group"`
+	APIKey        string   `mapstructure:"api_key"`
+	Exclude       []string `mapstructure:"exclude"`
+	BufferSize    int      `mapstructure:"buffer_size"`
+	BatchSize     int      `mapstructure:"batch_size"`
+	FlushInterval string   `mapstructure:"flush_interval"`
+	Stdout        bool     `mapstructure:"stdout"`
+}
+
+// Recorder records the requests to the API as log entries, which are ingested in batches in the background
+type Recorder struct {
+	config        Config
+	flushInterval time.Duration
+	entries       chan logging.JsonLog
+	pipeline      atomic.Pointer[logging.Pipeline]
+	tenants       *tenancy.Registry
+	dropped       *metrics.Counter
+}
+
+// LoadConfig reads the access log settings from the configuration file
+func LoadConfig() Config {
+	var accessLogConfig Config
+	if config.Exists(constants.AccessLog) {
+		if err := config.BindStruct(constants.AccessLog, &accessLogConfig); err != nil {
+			log.Printf("Unable to read access log settings from config: %v", err)
+		}
+	}
+	return accessLogConfig
+}
+
+// NewRecorder creates a recorder, requests are only recorded once it is started with the ingest pipeline
+func NewRecorder(accessLogConfig Config) *Recorder {
+	if accessLogConfig.Domain == "" {
+		accessLogConfig.Domain = defaultDomain
+	}
+	if accessLogConfig.Group == "" {
+		accessLogConfig.Group = defaultGroup
+	}
+	recorder := &Recorder{
+		config:        accessLogConfig,
+		flushInterval: utility.DurationOrDefault(accessLogConfig.FlushInterval, defaultFlushInterval),
+		entries:       make(chan logging.JsonLog, utility.ValueOrDefault(accessLogConfig.BufferSize, defaultBufferSize)),
+	}
+	recorder.config.BatchSize = utility.ValueOrDefault(accessLogConfig.BatchSize, defaultBatchSize)
+
+	dropped, err := metrics.DefaultRegistry.NewCounter("gofeather_access_log_dropped_total",
+		"Access log entries dropped because the buffer was full")
+	if err != nil {
+		log.Printf("Unable to register the access log metric: %v", err)
+	}
+	recorder.dropped = dropped
+	return recorder
+}
+
+// Start ingests the recorded requests through the pipeline, the tenant registry identifies the callers of routes
+// that don't authenticate them and may be nil
+func (r *Recorder) Start(pipeline *logging.Pipeline, tenants *tenancy.Registry) {
+	r.tenants = tenants
+	r.pipeline.Store(pipeline)
+	go r.run()
+}
+
+// Middleware assigns every request an id and records it once it is handled. It must be added before the routes,
+// requests are recorded from the moment the recorder is started.
+func (r *Recorder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = newRequestID()
+		}
+		c.Set(requestIDContextKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+
+		if r.pipeline.Load() == nil || r.excluded(c) {
+			return
+		}
+		select {
+		case r.entries <- r.entry(c, requestID, start):
+		default:
+			r.dropped.Inc()
+		}
+	}
+}
+
+// RequestID returns the id of the request set by the middleware, empty when the access log is off
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
+
+// excluded tells whether the request is a stream or excluded by the configuration
+func (r *Recorder) excluded(c *gin.Context) bool {
+	route := c.FullPath()
+	if slices.Contains(streamRoutes, route) || strings.Contains(c.GetHeader("Accept"), "text/event-stream") ||
+		strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		return true
+	}
+	return slices.Contains(r.config.Exclude, route) || slices.Contains(r.config.Exclude, c.Request.URL.Path)
+}
+
+func (r *Recorder) entry(c *gin.Context, requestID string, start time.Time) logging.JsonLog {
+	latency := time.Since(start)
+	status := c.Writer.Status()
+	route := c.FullPath()
+
+	fields := map[string]interface{}{
+		"method":     c.Request.Method,
+		"path":       c.Request.URL.Path,
+		"status":     int64(status),
+		"latency":    latency.Seconds(),
+		"request_id": requestID,
+		"client_ip":  c.ClientIP(),
+		"bytes_out":  int64(max(c.Writer.Size(), 0)),
+	}
+	if route != "" {
+		fields["route"] = route
+	}
+	if c.Request.ContentLength > 0 {
+		fields["bytes_in"] = c.Request.ContentLength
+	}
+	if userAgent := c.Request.UserAgent(); userAgent != "" {
+		fields["user_agent"] = userAgent
+	}
+	if len(c.Errors) > 0 {
+		fields["errors"] = c.Errors.String()
+	}
+	caller := r.caller(c)
+	if caller.Tenant != "" {
+		fields["tenant"] = caller.Tenant
+	}
+	if caller.User != "" {
+		fields["user"] = caller.User
+	}
+	if caller.SuperAdmin {
+		fields["super_admin"] = true
+	}
+
+	level := "info"
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = "error"
+	case status >= http.StatusBadRequest:
+		level = "warn"
+	}
+	path := route
+	if path == "" {
+		path = c.Request.URL.Path
+	}
+
+	return logging.JsonLog{
+		Domain:    r.config.Domain,
+		Group:     r.config.Group,
+		Tag:       route,
+		Level:     level,
+		Log:       fmt.Sprintf("%s %s %d in %s", c.Request.Method, path, status, latency.Round(time.Microsecond)),
+		EventTime: start.UnixMilli(),
+		Fields:    fields,
+	}
+}
+
+// caller identifies who made the request, by the caller set by tenancy, the user set by auth or, for routes that
+// don't authenticate, the access token or ingest key of the request. Ingest keys are never recorded themselves.
+func (r *Recorder) caller(c *gin.Context) tenancy.Caller {
+	caller := tenancy.CallerFrom(c)
+	bearerToken, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if caller.Tenant == "" && caller.User == "" && !caller.SuperAdmin && r.tenants != nil {
+		caller, _ = r.tenants.Caller(bearerToken, c.GetHeader(logging.IngestKeyHeader))
+	}
+	if caller.User == "" {
+		if user, ok := auth.CurrentUser(c); ok {
+			caller.User = user.Id
+		}
+	}
+	//Without tenancy the routes that don't require a user still get their caller from a valid access token
+	if caller.User == "" && r.tenants == nil && bearerToken != "" {
+		if user, err := auth.ParseAccessToken(bearerToken); err == nil {
+			caller.User = user.Id
+		}
+	}
+	return caller
+}
+
+// run ingests the recorded entries in batches, when the batch is full or the flush interval passed
+func (r *Recorder) run() {
+	ticker := time.NewTicker(r.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]logging.JsonLog, 0, r.config.BatchSize)
+	for {
+		select {
+		case entry := <-r.entries:
+			batch = append(batch, entry)
+			if len(batch) < r.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if _, err := r.pipeline.Load().Ingest(r.config.APIKey, batch); err != nil {
+			log.Printf("Unable to ingest %d access log entries: %v", len(batch), err)
+		}
+		batch = make([]logging.JsonLog, 0, r.config.BatchSize)
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,291 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"gofeather/internal/client"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	readChunkSize = 256 * 1024
+	maxRetryWait  = time.Minute
+)
+
+// trackedFile is a followed file, kept open so it can still be read to the end after being rotated away
+type trackedFile struct {
+	id     string
+	path   string
+	file   *os.File
+	offset int64
+	parser *lineParser
+	seen   bool
+}
+
+// Agent follows the configured files and ships their new lines to GoFeather in batches
+type Agent struct {
+	config  *Config
+	client  *client.Client
+	parsers []*lineParser
+	state   *state
+	files   map[string]*trackedFile
+}
+
+func New(cfg *Config) (*Agent, error) {
+	loadedState, err := loadState(cfg.StateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	agent := &Agent{
+		config: cfg,
+		client: client.New(cfg.Server),
+		state:  loadedState,
+		files:  make(map[string]*trackedFile),
+	}
+	agent.client.APIKey = cfg.APIKey
+	agent.client.Headers = cfg.Headers
+
+	for _, fileConfig := range cfg.Files {
+		parser, err := newLineParser(fileConfig)
+		if err != nil {
+			return nil, err
+		}
+		agent.parsers = append(agent.parsers, parser)
+	}
+	return agent, nil
+}
+
+// Run follows the files until the context is cancelled, the read positions are saved before returning
+func (a *Agent) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.config.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		a.poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			for _, tracked := range a.files {
+				_ = tracked.file.Close()
+			}
+			return a.saveState()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll picks up new and rotated files and ships everything that was written to them since the last poll
+func (a *Agent) poll(ctx context.Context) {
+	a.discover()
+
+	for ctx.Err() == nil {
+		entries, offsets := a.read(a.config.BatchSize)
+		if len(entries) == 0 {
+			break
+		}
+		if err := a.ship(ctx, entries); err != nil {
+			log.Printf("Unable to ship %d log entries, retrying next poll: %v", len(entries), err)
+			return
+		}
+
+		for tracked, offset := range offsets {
+			tracked.offset = offset
+		}
+		if err := a.saveState(); err != nil {
+			log.Printf("Unable to save read positions: %v", err)
+		}
+		if len(entries) < a.config.BatchSize {
+			break
+		}
+	}
+
+	a.forgetDrained()
+}
+
+// discover matches the globs against the disk, opening new files and detecting truncated ones
+func (a *Agent) discover() {
+	for _, tracked := range a.files {
+		tracked.seen = false
+	}
+
+	for i, fileConfig := range a.config.Files {
+		for _, pattern := range fileConfig.Paths {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				log.Printf("Invalid file pattern %s: %v", pattern, err)
+				continue
+			}
+			for _, path := range matches {
+				a.track(path, a.parsers[i])
+			}
+		}
+	}
+}
+
+func (a *Agent) track(path string, parser *lineParser) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return
+	}
+	id := fileID(path, info)
+
+	if tracked, exists := a.files[id]; exists {
+		tracked.seen = true
+		tracked.path = path
+		if info.Size() < tracked.offset {
+			log.Printf("File %s was truncated, reading it from the start", path)
+			tracked.offset = 0
+		}
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Unable to open %s: %v", path, err)
+		return
+	}
+
+	tracked := &trackedFile{id: id, path: path, file: file, parser: parser, seen: true}
+	if saved, exists := a.state.Files[id]; exists && saved.Offset <= info.Size() {
+		tracked.offset = saved.Offset
+	}
+	a.files[id] = tracked
+	log.Printf("Following %s from offset %d", path, tracked.offset)
+}
+
+// read collects up to limit complete lines from the followed files, along with the offsets to store once shipped
+func (a *Agent) read(limit int) ([]client.LogEntry, map[*trackedFile]int64) {
+	entries := make([]client.LogEntry, 0, limit)
+	offsets := make(map[*trackedFile]int64)
+
+	for _, tracked := range a.sortedFiles() {
+		offset := tracked.offset
+		for len(entries) < limit {
+			chunk := make([]byte, readChunkSize)
+			n, err := tracked.file.ReadAt(chunk, offset)
+			if err != nil && !errors.Is(err, io.EOF) {
+				log.Printf("Unable to read %s: %v", tracked.path, err)
+				break
+			}
+			chunk = chunk[:n]
+
+			end := bytes.LastIndexByte(chunk, '\n')
+			if end < 0 {
+				//A line longer than a whole chunk is shipped in parts rather than blocking the file
+				if n < readChunkSize {
+					break
+				}
+				end = n - 1
+			}
+
+			consumed := 0
+			for _, line := range bytes.SplitAfter(chunk[:end+1], []byte("\n")) {
+				if len(entries) >= limit || len(line) == 0 {
+					break
+				}
+				consumed += len(line)
+				text := strings.TrimRight(string(line), "\r\n")
+				if text != "" {
+					entries = append(entries, tracked.parser.parse(text, tracked.path))
+				}
+			}
+			offset += int64(consumed)
+		}
+		if offset != tracked.offset {
+			offsets[tracked] = offset
+		}
+	}
+	return entries, offsets
+}
+
+// ship posts a batch, retrying with a growing wait when the server is unreachable or asks to retry later.
+// A batch that is too large is split in half, a batch refused for its ingest key is kept to be shipped on the
+// next poll once the key is fixed. Batches refused for any other reason are dropped, as sending them again
+// won't help. When a split batch fails halfway, the half that was shipped is sent again with the rest.
+func (a *Agent) ship(ctx context.Context, entries []client.LogEntry) error {
+	wait := a.config.retryInterval()
+	var err error
+	for attempt := 0; attempt <= a.config.MaxRetries; attempt++ {
+		err = a.client.PostLogs(ctx, entries)
+		if err == nil {
+			return nil
+		}
+
+		var apiErr *client.APIError
+		if errors.As(err, &apiErr) {
+			switch {
+			case apiErr.StatusCode == http.StatusRequestEntityTooLarge && len(entries) > 1:
+				half := len(entries) / 2
+				if err := a.ship(ctx, entries[:half]); err != nil {
+					return err
+				}
+				return a.ship(ctx, entries[half:])
+			case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+				return err
+			case !apiErr.Retryable():
+				log.Printf("Dropping %d log entries refused by the server: %v", len(entries), err)
+				return nil
+			}
+			if apiErr.RetryAfter > wait {
+				wait = apiErr.RetryAfter
+			}
+		}
+		if attempt == a.config.MaxRetries {
+			break
+		}
+
+		log.Printf("Failed to ship log entries, retrying in %s: %v", wait, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxRetryWait)
+	}
+	return err
+}
+
+// forgetDrained closes the files that no longer match any glob once they have been read to the end
+func (a *Agent) forgetDrained() {
+	for id, tracked := range a.files {
+		if tracked.seen {
+			continue
+		}
+		info, err := tracked.file.Stat()
+		if err == nil && tracked.offset < info.Size() {
+			continue
+		}
+		_ = tracked.file.Close()
+		delete(a.files, id)
+		log.Printf("Stopped following %s", tracked.path)
+	}
+}
+
+// saveState stores the read position of every followed file, forgotten files are left out
+func (a *Agent) saveState() error {
+	a.state.Files = make(map[string]fileState, len(a.files))
+	for id, tracked := range a.files {
+		a.state.Files[id] = fileState{Path: tracked.path, Offset: tracked.offset}
+	}
+	return a.state.save(a.config.StateFile)
+}
+
+func (a *Agent) sortedFiles() []*trackedFile {
+	files := make([]*trackedFile, 0, len(a.files))
+	for _, tracked := range a.files {
+		files = append(files, tracked)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+	return files
+}
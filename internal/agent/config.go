@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"fmt"
+	"github.com/gookit/config/v2"
+	"github.com/gookit/config/v2/yaml"
+	"time"
+)
+
+const (
+	FormatText  = "text"
+	FormatJSON  = "json"
+	FormatRegex = "regex"
+)
+
+// Config configures the agent, durations are written like "2s"
+type Config struct {
+	Server        string       `mapstructure:"server"`
+	APIKey        string       `mapstructure:"api_key"`
+	StateFile     string       `mapstructure:"state_file"`
+	BatchSize     int          `mapstructure:"batch_size"`
+	PollInterval  string       `mapstructure:"poll_interval"`
+	MaxRetries    int          `mapstructure:"max_retries"`
+	RetryInterval string       `mapstructure:"retry_interval"`
+	Files         []FileConfig `mapstructure:"files"`
+	// Headers are sent with every batch, the server enriches the entries with the ones it is configured to read
+	Headers map[string]string `mapstructure:"headers"`
+}
+
+// FileConfig describes a set of files to follow and how their lines become log entries.
+// The regex format uses named groups, the log, level, domain, group and tag groups fill those
+// fields of the entry and the other groups become structured fields.
+type FileConfig struct {
+	Paths   []string `mapstructure:"paths"`
+	Domain  string   `mapstructure:"domain"`
+	Group   string   `mapstructure:"group"`
+	Tag     string   `mapstructure:"tag"`
+	Format  string   `mapstructure:"format"`
+	Pattern string   `mapstructure:"pattern"`
+}
+
+// LoadConfig reads the agent configuration from a YAML file, environment variables in values are expanded
+func LoadConfig(path string) (*Config, error) {
+	agentConfig := config.NewWithOptions("agent", config.ParseEnv)
+	agentConfig.AddDriver(yaml.Driver)
+	if err := agentConfig.LoadFiles(path); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Server:        "http://localhost:8080",
+		StateFile:     "featherlog-agent.state",
+		BatchSize:     500,
+		PollInterval:  "1s",
+		MaxRetries:    5,
+		RetryInterval: "1s",
+	}
+	if err := agentConfig.Decode(cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Files) == 0 {
+		return nil, fmt.Errorf("no files configured in %s", path)
+	}
+	for _, interval := range []string{cfg.PollInterval, cfg.RetryInterval} {
+		if _, err := time.ParseDuration(interval); err != nil {
+			return nil, fmt.Errorf("invalid interval %s: %w", interval, err)
+		}
+	}
+	return cfg, nil
+}
+
+func (c *Config) pollInterval() time.Duration {
+	interval, _ := time.ParseDuration(c.PollInterval)
+	return interval
+}
+
+func (c *Config) retryInterval() time.Duration {
+	interval, _ := time.ParseDuration(c.RetryInterval)
+	return interval
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package agent
+
+import "os"
+
+// fileID identifies a file by its path on platforms without inodes, a rotated file is followed from the start
+func fileID(path string, _ os.FileInfo) string {
+	return path
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"gofeather/internal/client"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// lineParser turns a line of a followed file into a log entry
+type lineParser struct {
+	fileConfig FileConfig
+	pattern    *regexp.Regexp
+}
+
+func newLineParser(fileConfig FileConfig) (*lineParser, error) {
+	parser := &lineParser{fileConfig: fileConfig}
+	switch fileConfig.Format {
+	case FormatText, FormatJSON, "":
+	case FormatRegex:
+		pattern, err := regexp.Compile(fileConfig.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern for %v: %w", fileConfig.Paths, err)
+		}
+		parser.pattern = pattern
+	default:
+		return nil, fmt.Errorf("unknown format for %v: %s", fileConfig.Paths, fileConfig.Format)
+	}
+	return parser, nil
+}
+
+// parse converts a line, lines that don't match the format are shipped as plain text.
+// The entry is given the time it was read as its event time, so entries shipped late keep their order.
+func (p *lineParser) parse(line string, path string) client.LogEntry {
+	entry := client.LogEntry{
+		Domain:    p.fileConfig.Domain,
+		Group:     p.fileConfig.Group,
+		Tag:       p.fileConfig.Tag,
+		Log:       line,
+		Fields:    map[string]interface{}{"file": path},
+		EventTime: time.Now().UnixMilli(),
+	}
+
+	switch p.fileConfig.Format {
+	case FormatJSON:
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &object); err == nil {
+			entry.Log = ""
+			for key, value := range object {
+				setField(&entry, key, value)
+			}
+			if entry.Log == "" {
+				entry.Log = line
+			}
+		}
+	case FormatRegex:
+		if match := p.pattern.FindStringSubmatch(line); match != nil {
+			for i, name := range p.pattern.SubexpNames() {
+				if name != "" && match[i] != "" {
+					setField(&entry, name, match[i])
+				}
+			}
+		}
+	}
+	return entry
+}
+
+// setField fills the matching field of the entry, or adds a structured field for any other key
+func setField(entry *client.LogEntry, key string, value interface{}) {
+	text, isString := value.(string)
+	switch strings.ToLower(key) {
+	case "log", "message", "msg":
+		if isString {
+			entry.Log = text
+			return
+		}
+	case "level", "severity":
+		if isString {
+			entry.Level = strings.ToLower(text)
+			return
+		}
+	case "domain":
+		if isString {
+			entry.Domain = text
+			return
+		}
+	case "group":
+		if isString {
+			entry.Group = text
+			return
+		}
+	case "tag":
+		if isString {
+			entry.Tag = text
+			return
+		}
+	}
+	entry.Fields[key] = value
+}
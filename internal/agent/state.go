@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// fileState is the read position in a followed file, it only moves forward once the lines before it were shipped
+type fileState struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+}
+
+// state holds the read positions of the followed files by file id
+type state struct {
+	Files map[string]fileState `json:"files"`
+}
+
+func loadState(path string) (*state, error) {
+	loaded := &state{Files: make(map[string]fileState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return loaded, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, loaded); err != nil {
+		return nil, err
+	}
+	if loaded.Files == nil {
+		loaded.Files = make(map[string]fileState)
+	}
+	return loaded, nil
+}
+
+// save writes the state to a temporary file first, so a crash never leaves a half written state behind
+func (s *state) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
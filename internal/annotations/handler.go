@@ -0,0 +1,407 @@
+package annotations
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gofeather/internal/auth"
+	"gofeather/internal/logging"
+	"gofeather/internal/utility"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxTextLength = 10000
+	maxLabels     = 20
+)
+
+// EntryGetter looks up a log entry, implemented by the log repository
+type EntryGetter interface {
+	GetLog(domain string, id primitive.ObjectID) (*logging.JsonLog, error)
+}
+
+type AnnotationHandler struct {
+	repo    AnnotationRepository
+	entries EntryGetter
+}
+
+func NewAnnotationHandler(repo AnnotationRepository, entries EntryGetter) *AnnotationHandler {
+	return &AnnotationHandler{repo: repo, entries: entries}
+}
+
+// GetAnnotations godoc
+//
+//	@Summary		List the annotations of a domain
+//	@Description	Retrieves the annotations overlapping with the range in chronological order
+//	@Tags			Annotations
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Param			from	query		string	false	"Start of the range, unix milliseconds or RFC 3339"
+//	@Param			to		query		string	false	"End of the range, unix milliseconds or RFC 3339"
+//	@Param			label	query		string	false	"Only annotations with this label"
+//	@Param			log_id	query		string	false	"Only annotations on this log entry"
+//	@Param			pinned	query		bool	false	"Only annotations pinning their log entry"
+//	@Success		200		{array}		Annotation
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/annotations/{domain} [get]
+func (h *AnnotationHandler) GetAnnotations(c *gin.Context) {
+	filter, ok := filterFromQuery(c)
+	if !ok {
+		return
+	}
+	if logID := c.Query("log_id"); logID != "" {
+		id, err := primitive.ObjectIDFromHex(logID)
+		if err != nil {
+			utility.RespondWithError(c, http.StatusBadRequest, "invalid log entry id")
+			return
+		}
+		filter.LogID = &id
+	}
+	filter.Pinned = c.Query("pinned") == "true"
+
+	annotations, err := h.repo.GetAnnotations(c.Param("domain"), filter)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, annotations)
+}
+
+// CreateAnnotation godoc
+//
+//	@Summary		Annotate a log entry or a time range
+//	@Description	Adds a note to a log entry or to a time range of a domain, the author is the user of the access token.
+//	@Description	A pinned annotation on a log entry puts the entry on the timeline.
+//	@Tags			Annotations
+//	@Accept			json
+//	@Produce		json
+//	@Param			domain			path		string				true	"Domain name"
+//	@Param			Authorization	header		string				true	"Bearer access token"
+//	@Param			annotation		body		AnnotationRequest	true	"Annotation"
+//	@Success		201				{object}	Annotation
+//	@Failure		400				{object}	error
+//	@Failure		401				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/annotations/{domain} [post]
+func (h *AnnotationHandler) CreateAnnotation(c *gin.Context) {
+	user, _ := auth.CurrentUser(c)
+	domain := c.Param("domain")
+
+	var request AnnotationRequest
+	if err := c.BindJSON(&request); err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	labels, err := validateContent(request.Text, request.Labels)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	annotation := Annotation{
+		Domain:  domain,
+		Author:  user,
+		Text:    request.Text,
+		Labels:  labels,
+		Pinned:  request.Pinned,
+		Created: now,
+		Updated: now,
+	}
+
+	switch {
+	case request.LogID != "":
+		if request.From != "" || request.To != "" {
+			utility.RespondWithError(c, http.StatusBadRequest, "an annotation is either on a log entry or on a range")
+			return
+		}
+		id, err := primitive.ObjectIDFromHex(request.LogID)
+		if err != nil {
+			utility.RespondWithError(c, http.StatusBadRequest, "invalid log entry id")
+			return
+		}
+		entry, err := h.entries.GetLog(domain, id)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				utility.RespondWithError(c, http.StatusNotFound, "log entry not found")
+			} else {
+				utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		annotation.LogID = &id
+		annotation.From = entry.Timestamp
+		annotation.To = entry.Timestamp
+	case request.From != "":
+		if request.Pinned {
+			utility.RespondWithError(c, http.StatusBadRequest, "only annotations on a log entry can be pinned")
+			return
+		}
+		if annotation.From, err = utility.ParseTime(request.From, 0); err != nil {
+			utility.RespondWithError(c, http.StatusBadRequest, "from "+err.Error())
+			return
+		}
+		if annotation.To, err = utility.ParseTime(request.To, annotation.From); err != nil {
+			utility.RespondWithError(c, http.StatusBadRequest, "to "+err.Error())
+			return
+		}
+		if annotation.From > annotation.To {
+			utility.RespondWithError(c, http.StatusBadRequest, "from must be before to")
+			return
+		}
+	default:
+		utility.RespondWithError(c, http.StatusBadRequest, "either log_id or from is required")
+		return
+	}
+
+	if annotation.ID, err = h.repo.AddAnnotation(annotation); err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusCreated, annotation)
+}
+
+// UpdateAnnotation godoc
+//
+//	@Summary		Update an annotation
+//	@Description	Changes the text, labels or pin of an annotation, only its author can update it
+//	@Tags			Annotations
+//	@Accept			json
+//	@Produce		json
+//	@Param			domain			path		string				true	"Domain name"
+//	@Param			id				path		string				true	"Annotation id"
+//	@Param			Authorization	header		string				true	"Bearer access token"
+//	@Param			update			body		AnnotationUpdate	true	"Changed fields"
+//	@Success		200				{object}	Annotation
+//	@Failure		400				{object}	error
+//	@Failure		401				{object}	error
+//	@Failure		403				{object}	error
+//	@Failure		404				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/annotations/{domain}/{id} [put]
+func (h *AnnotationHandler) UpdateAnnotation(c *gin.Context) {
+	annotation, ok := h.authoredAnnotation(c)
+	if !ok {
+		return
+	}
+
+	var request AnnotationUpdate
+	if err := c.BindJSON(&request); err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	update := bson.M{"updated": time.Now().UnixMilli()}
+	text, labels := annotation.Text, annotation.Labels
+	if request.Text != nil {
+		text = *request.Text
+	}
+	if request.Labels != nil {
+		labels = *request.Labels
+	}
+	labels, err := validateContent(text, labels)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	update["text"] = text
+	update["labels"] = labels
+	if request.Pinned != nil {
+		if *request.Pinned && annotation.LogID == nil {
+			utility.RespondWithError(c, http.StatusBadRequest, "only annotations on a log entry can be pinned")
+			return
+		}
+		update["pinned"] = *request.Pinned
+	}
+
+	updated, err := h.repo.UpdateAnnotation(annotation.Domain, annotation.ID, update)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteAnnotation godoc
+//
+//	@Summary		Delete an annotation
+//	@Description	Removes an annotation, only its author can delete it
+//	@Tags			Annotations
+//	@Param			domain			path		string	true	"Domain name"
+//	@Param			id				path		string	true	"Annotation id"
+//	@Param			Authorization	header		string	true	"Bearer access token"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/annotations/{domain}/{id} [delete]
+func (h *AnnotationHandler) DeleteAnnotation(c *gin.Context) {
+	annotation, ok := h.authoredAnnotation(c)
+	if !ok {
+		return
+	}
+
+	if err := h.repo.DeleteAnnotation(annotation.Domain, annotation.ID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utility.RespondWithError(c, http.StatusNotFound, "annotation not found")
+		} else {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetTimeline godoc
+//
+//	@Summary		Get the incident timeline of a domain
+//	@Description	Merges the annotations overlapping with the range and the log entries they pin in chronological order.
+//	@Description	Annotations are placed at the start of their range, pinned entries that no longer exist are left out.
+//	@Tags			Annotations
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Param			from	query		string	false	"Start of the range, unix milliseconds or RFC 3339"
+//	@Param			to		query		string	false	"End of the range, unix milliseconds or RFC 3339"
+//	@Param			label	query		string	false	"Only annotations with this label, such as the incident"
+//	@Success		200		{array}		TimelineItem
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/annotations/{domain}/timeline [get]
+func (h *AnnotationHandler) GetTimeline(c *gin.Context) {
+	filter, ok := filterFromQuery(c)
+	if !ok {
+		return
+	}
+	domain := c.Param("domain")
+
+	annotations, err := h.repo.GetAnnotations(domain, filter)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	timeline := make([]TimelineItem, 0, len(annotations))
+	pinned := make(map[primitive.ObjectID]struct{})
+	for i := range annotations {
+		annotation := &annotations[i]
+		timeline = append(timeline, TimelineItem{Timestamp: annotation.From, Type: ItemAnnotation, Annotation: annotation})
+
+		if !annotation.Pinned || annotation.LogID == nil {
+			continue
+		}
+		if _, exists := pinned[*annotation.LogID]; exists {
+			continue
+		}
+		pinned[*annotation.LogID] = struct{}{}
+
+		entry, err := h.entries.GetLog(domain, *annotation.LogID)
+		if err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				continue
+			}
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		entry.Domain = domain
+		timeline = append(timeline, TimelineItem{Timestamp: entry.Timestamp, Type: ItemEntry, Entry: entry})
+	}
+
+	//A pinned entry comes before the annotations made on it
+	sort.SliceStable(timeline, func(i, j int) bool {
+		if timeline[i].Timestamp != timeline[j].Timestamp {
+			return timeline[i].Timestamp < timeline[j].Timestamp
+		}
+		return timeline[i].Type == ItemEntry && timeline[j].Type != ItemEntry
+	})
+
+	c.JSON(http.StatusOK, timeline)
+}
+
+// authoredAnnotation looks up the annotation in the path, responding with an error when it doesn't exist
+// or wasn't written by the current user
+func (h *AnnotationHandler) authoredAnnotation(c *gin.Context) (*Annotation, bool) {
+	user, _ := auth.CurrentUser(c)
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, "invalid annotation id")
+		return nil, false
+	}
+
+	annotation, err := h.repo.GetAnnotation(c.Param("domain"), id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utility.RespondWithError(c, http.StatusNotFound, "annotation not found")
+		} else {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return nil, false
+	}
+	if annotation.Author.Id != user.Id {
+		utility.RespondWithError(c, http.StatusForbidden, "only the author can change an annotation")
+		return nil, false
+	}
+
+	return annotation, true
+}
+
+// validateContent checks the text and labels of an annotation, returning the labels trimmed and without duplicates
+func validateContent(text string, labels []string) ([]string, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("text is required")
+	}
+	if len(text) > maxTextLength {
+		return nil, errors.New("text can be at most " + strconv.Itoa(maxTextLength) + " characters")
+	}
+	if len(labels) > maxLabels {
+		return nil, errors.New("an annotation can have at most " + strconv.Itoa(maxLabels) + " labels")
+	}
+
+	cleaned := make([]string, 0, len(labels))
+	seen := make(map[string]struct{}, len(labels))
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			return nil, errors.New("labels can not be empty")
+		}
+		if _, exists := seen[label]; exists {
+			continue
+		}
+		seen[label] = struct{}{}
+		cleaned = append(cleaned, label)
+	}
+	return cleaned, nil
+}
+
+// filterFromQuery reads the range and label from the query, an open end covers all time
+func filterFromQuery(c *gin.Context) (AnnotationFilter, bool) {
+	from, err := utility.ParseTime(c.Query("from"), 0)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, "from "+err.Error())
+		return AnnotationFilter{}, false
+	}
+	to, err := utility.ParseTime(c.Query("to"), math.MaxInt64)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, "to "+err.Error())
+		return AnnotationFilter{}, false
+	}
+	if from > to {
+		utility.RespondWithError(c, http.StatusBadRequest, "from must be before to")
+		return AnnotationFilter{}, false
+	}
+	return AnnotationFilter{From: from, To: to, Label: c.Query("label")}, true
+}
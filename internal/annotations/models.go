@@ -0,0 +1,67 @@
+package annotations
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gofeather/internal/auth"
+	"gofeather/internal/logging"
+)
+
+const (
+	// Collection starts with an underscore to stay out of the domain list
+	Collection = "_annotations"
+
+	ItemAnnotation = "annotation"
+	ItemEntry      = "entry"
+)
+
+// Annotation is a note on a log entry or on a time range of a domain, timestamps are in unix milliseconds.
+// An annotation on an entry covers the timestamp of that entry, a pinned one bookmarks the entry for the timeline.
+type Annotation struct {
+	ID      primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	Domain  string              `json:"domain" bson:"domain"`
+	LogID   *primitive.ObjectID `json:"log_id,omitempty" bson:"log_id,omitempty"`
+	From    int64               `json:"from" bson:"from"`
+	To      int64               `json:"to" bson:"to"`
+	Author  auth.User           `json:"author" bson:"author"`
+	Text    string              `json:"text" bson:"text"`
+	Labels  []string            `json:"labels" bson:"labels"`
+	Pinned  bool                `json:"pinned" bson:"pinned"`
+	Created int64               `json:"created" bson:"created"`
+	Updated int64               `json:"updated" bson:"updated"`
+}
+
+// AnnotationRequest is the body creating an annotation, either log_id or from is required.
+// from and to take unix milliseconds or RFC 3339 times, to defaults to from.
+type AnnotationRequest struct {
+	LogID  string   `json:"log_id"`
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Text   string   `json:"text"`
+	Labels []string `json:"labels"`
+	Pinned bool     `json:"pinned"`
+}
+
+// AnnotationUpdate is the body updating an annotation, fields left out are kept
+type AnnotationUpdate struct {
+	Text   *string   `json:"text"`
+	Labels *[]string `json:"labels"`
+	Pinned *bool     `json:"pinned"`
+}
+
+// AnnotationFilter narrows down the listed annotations, empty fields are not filtered on.
+// An annotation matches the range when it overlaps with it.
+type AnnotationFilter struct {
+	From   int64
+	To     int64
+	Label  string
+	LogID  *primitive.ObjectID
+	Pinned bool
+}
+
+// TimelineItem is either an annotation or a pinned log entry, placed on the timeline at its timestamp
+type TimelineItem struct {
+	Timestamp  int64            `json:"timestamp"`
+	Type       string           `json:"type"`
+	Annotation *Annotation      `json:"annotation,omitempty"`
+	Entry      *logging.JsonLog `json:"entry,omitempty"`
+}
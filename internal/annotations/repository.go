@@ -0,0 +1,116 @@
+package annotations
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gofeather/internal/utility"
+)
+
+type AnnotationRepository interface {
+	AddAnnotation(annotation Annotation) (primitive.ObjectID, error)
+	GetAnnotations(domain string, filter AnnotationFilter) ([]Annotation, error)
+	GetAnnotation(domain string, id primitive.ObjectID) (*Annotation, error)
+	UpdateAnnotation(domain string, id primitive.ObjectID, update bson.M) (*Annotation, error)
+	DeleteAnnotation(domain string, id primitive.ObjectID) error
+}
+
+type MongoAnnotationRepository struct {
+	database *mongo.Database
+}
+
+func NewMongoAnnotationRepository(database *mongo.Database) *MongoAnnotationRepository {
+	return &MongoAnnotationRepository{database: database}
+}
+
+func (r *MongoAnnotationRepository) AddAnnotation(annotation Annotation) (primitive.ObjectID, error) {
+	var id primitive.ObjectID
+	coll := r.database.Collection(Collection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		result, insertErr := coll.InsertOne(ctx, annotation)
+		if insertErr != nil {
+			return insertErr
+		}
+		id = result.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+
+	return id, err
+}
+
+// GetAnnotations returns the annotations of a domain matching the filter in chronological order
+func (r *MongoAnnotationRepository) GetAnnotations(domain string, filter AnnotationFilter) ([]Annotation, error) {
+	results := make([]Annotation, 0)
+	coll := r.database.Collection(Collection)
+
+	query := bson.M{"domain": domain, "from": bson.M{"$lte": filter.To}, "to": bson.M{"$gte": filter.From}}
+	if filter.Label != "" {
+		query["labels"] = filter.Label
+	}
+	if filter.LogID != nil {
+		query["log_id"] = *filter.LogID
+	}
+	if filter.Pinned {
+		query["pinned"] = true
+	}
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		opts := options.Find().SetSort(bson.D{{Key: "from", Value: 1}, {Key: "_id", Value: 1}})
+		cur, findErr := coll.Find(ctx, query, opts)
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+func (r *MongoAnnotationRepository) GetAnnotation(domain string, id primitive.ObjectID) (*Annotation, error) {
+	var annotation Annotation
+	coll := r.database.Collection(Collection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		return coll.FindOne(ctx, bson.M{"_id": id, "domain": domain}).Decode(&annotation)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &annotation, nil
+}
+
+// UpdateAnnotation sets the fields of an annotation and returns the updated annotation
+func (r *MongoAnnotationRepository) UpdateAnnotation(domain string, id primitive.ObjectID, update bson.M) (*Annotation, error) {
+	var annotation Annotation
+	coll := r.database.Collection(Collection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		return coll.FindOneAndUpdate(ctx, bson.M{"_id": id, "domain": domain}, bson.M{"$set": update}, opts).
+			Decode(&annotation)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &annotation, nil
+}
+
+func (r *MongoAnnotationRepository) DeleteAnnotation(domain string, id primitive.ObjectID) error {
+	coll := r.database.Collection(Collection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		result, err := coll.DeleteOne(ctx, bson.M{"_id": id, "domain": domain})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return nil
+	})
+}
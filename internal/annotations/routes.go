@@ -0,0 +1,23 @@
+package annotations
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gofeather/internal/auth"
+	"gofeather/internal/tenancy"
+)
+
+// CreateRoutes registers the annotation routes, changing annotations requires an access token.
+// With a tenant registry the routes are scoped to the caller's tenant.
+func CreateRoutes(engine *gin.Engine, database *mongo.Database, entries EntryGetter, tenants *tenancy.Registry) {
+	annotationHandler := NewAnnotationHandler(NewMongoAnnotationRepository(database), entries)
+
+	scoped := engine.Group("/annotations", tenants.Authenticate(), tenants.RequireDomain())
+	scoped.GET("/:domain", annotationHandler.GetAnnotations)
+	scoped.GET("/:domain/timeline", annotationHandler.GetTimeline)
+
+	authorized := scoped.Group("", auth.RequireUser())
+	authorized.POST("/:domain", annotationHandler.CreateAnnotation)
+	authorized.PUT("/:domain/:id", annotationHandler.UpdateAnnotation)
+	authorized.DELETE("/:domain/:id", annotationHandler.DeleteAnnotation)
+}
@@ -0,0 +1,371 @@
+package anomaly
+
+import (
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/logging"
+	"gofeather/internal/metrics"
+	"gofeather/internal/templates"
+	"gofeather/internal/utility"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultInterval       = time.Minute
+	defaultAlpha          = 0.1
+	defaultThreshold      = 3.0
+	defaultMinSamples     = 30
+	defaultMinDelta       = 10
+	defaultCooldown       = 15 * time.Minute
+	defaultLearningPeriod = time.Hour
+	defaultMaxTemplates   = 10000
+	defaultMaxSources     = 10000
+
+	// restingLevel is the mean and variance below which the baseline of a silent source no longer changes
+	restingLevel = 1e-3
+
+	// backlogSize is the amount of ingested batches waiting to be analysed, batches are skipped when it is full
+	backlogSize = 1024
+)
+
+// sourceKey identifies a source of volume within a domain
+type sourceKey struct {
+	domain string
+	group  string
+	level  string
+}
+
+// Detector learns a seasonal baseline of the volume per domain, group and level and the message templates per domain,
+// and records an event when the volume deviates significantly or a new template appears.
+// It receives every accepted entry from the ingest pipeline, before sampling, and analyses them in the background.
+type Detector struct {
+	repo     AnomalyRepository
+	notifier *notifier
+	backlog  chan []logging.JsonLog
+	skipped  *metrics.Counter
+
+	interval       time.Duration
+	seasonality    string
+	alpha          float64
+	threshold      float64
+	minSamples     int64
+	minDelta       float64
+	cooldown       time.Duration
+	learningPeriod time.Duration
+	maxTemplates   int
+	maxSources     int
+
+	//Only used by the analysing goroutine
+	counts     map[sourceKey]float64
+	baselines  map[sourceKey]map[int]*Baseline
+	lastEvent  map[string]time.Time
+	templates  map[string]map[string]struct{}
+	domainSeen map[string]time.Time
+}
+
+func NewDetector(repo AnomalyRepository, cfg Config) *Detector {
+	detector := &Detector{
+		repo:           repo,
+		notifier:       newNotifier(cfg.Webhook),
+		backlog:        make(chan []logging.JsonLog, backlogSize),
+		interval:       utility.DurationOrDefault(cfg.Interval, defaultInterval),
+		seasonality:    cfg.Seasonality,
+		alpha:          cfg.Alpha,
+		threshold:      cfg.Threshold,
+		minSamples:     cfg.MinSamples,
+		minDelta:       cfg.MinDelta,
+		cooldown:       utility.DurationOrDefault(cfg.Cooldown, defaultCooldown),
+		learningPeriod: utility.DurationOrDefault(cfg.LearningPeriod, defaultLearningPeriod),
+		maxTemplates:   cfg.MaxTemplates,
+		maxSources:     cfg.MaxSources,
+		counts:         make(map[sourceKey]float64),
+		baselines:      make(map[sourceKey]map[int]*Baseline),
+		lastEvent:      make(map[string]time.Time),
+		templates:      make(map[string]map[string]struct{}),
+		domainSeen:     make(map[string]time.Time),
+	}
+	if detector.seasonality == "" {
+		detector.seasonality = SeasonalityHourOfWeek
+	}
+	if detector.alpha <= 0 || detector.alpha >= 1 {
+		detector.alpha = defaultAlpha
+	}
+	if detector.threshold <= 0 {
+		detector.threshold = defaultThreshold
+	}
+	if detector.minSamples <= 0 {
+		detector.minSamples = defaultMinSamples
+	}
+	if detector.minDelta <= 0 {
+		detector.minDelta = defaultMinDelta
+	}
+	if detector.maxTemplates <= 0 {
+		detector.maxTemplates = defaultMaxTemplates
+	}
+	if detector.maxSources <= 0 {
+		detector.maxSources = defaultMaxSources
+	}
+
+	var err error
+	if detector.skipped, err = metrics.DefaultRegistry.NewCounter("gofeather_anomaly_skipped_batches_total",
+		"Ingested batches not analysed for anomalies because the detector fell behind"); err != nil {
+		log.Printf("Unable to register anomaly metric: %v", err)
+	}
+
+	detector.load()
+	go detector.run()
+	return detector
+}
+
+func LoadConfig() Config {
+	var anomalyConfig Config
+	if config.Exists(constants.Anomalies) {
+		if err := config.BindStruct(constants.Anomalies, &anomalyConfig); err != nil {
+			log.Printf("Unable to read anomaly detection settings from config: %v", err)
+		}
+	}
+	return anomalyConfig
+}
+
+// load restores the learned baselines and templates, so a restart doesn't start learning from scratch
+func (d *Detector) load() {
+	baselines, err := d.repo.GetBaselines()
+	if err != nil {
+		log.Printf("Unable to load anomaly baselines: %v", err)
+	}
+	for i := range baselines {
+		baseline := baselines[i]
+		key := sourceKey{domain: baseline.Domain, group: baseline.Group, level: baseline.Level}
+		if d.baselines[key] == nil {
+			d.baselines[key] = make(map[int]*Baseline)
+		}
+		d.baselines[key][baseline.Season] = &baseline
+	}
+
+	templates, err := d.repo.GetTemplates()
+	if err != nil {
+		log.Printf("Unable to load message templates: %v", err)
+	}
+	for _, template := range templates {
+		d.rememberTemplate(template.Domain, template.ID)
+		firstSeen := time.UnixMilli(template.FirstSeen)
+		if seen, exists := d.domainSeen[template.Domain]; !exists || firstSeen.Before(seen) {
+			d.domainSeen[template.Domain] = firstSeen
+		}
+	}
+}
+
+// Observe hands the accepted entries to the detector without blocking ingest
+func (d *Detector) Observe(entries []logging.JsonLog) {
+	select {
+	case d.backlog <- entries:
+	default:
+		d.skipped.Inc()
+	}
+}
+
+func (d *Detector) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	intervalStart := time.Now()
+
+	for {
+		select {
+		case entries := <-d.backlog:
+			d.analyse(entries)
+		case now := <-ticker.C:
+			d.checkVolume(intervalStart)
+			intervalStart = now
+		}
+	}
+}
+
+// analyse counts the entries towards the volume of their source and checks their templates
+func (d *Detector) analyse(entries []logging.JsonLog) {
+	var events []Event
+	var newTemplates []Template
+	now := time.Now()
+
+	for _, entry := range entries {
+		d.count(sourceKey{domain: entry.Domain, group: entry.Group, level: entry.Level})
+
+		template := templates.Of(entry.Log)
+		id := templates.ID(entry.Domain, template)
+		if _, known := d.templates[entry.Domain][id]; known || template == "" {
+			continue
+		}
+		if len(d.templates[entry.Domain]) >= d.maxTemplates {
+			continue
+		}
+		d.rememberTemplate(entry.Domain, id)
+		newTemplates = append(newTemplates, Template{ID: id, Domain: entry.Domain, Template: template, FirstSeen: now.UnixMilli()})
+
+		seen, exists := d.domainSeen[entry.Domain]
+		if !exists {
+			d.domainSeen[entry.Domain] = now
+			continue
+		}
+		if now.Sub(seen) < d.learningPeriod {
+			continue
+		}
+		events = append(events, Event{
+			Type:      TypeNewTemplate,
+			Domain:    entry.Domain,
+			Group:     entry.Group,
+			Level:     entry.Level,
+			Timestamp: now.UnixMilli(),
+			Template:  template,
+			Sample:    entry.Log,
+		})
+	}
+
+	if len(newTemplates) > 0 {
+		if err := d.repo.AddTemplates(newTemplates); err != nil {
+			log.Printf("Unable to store message templates: %v", err)
+		}
+	}
+	d.record(events)
+}
+
+// count adds an entry to the volume of its source, new sources are ignored once maxSources are tracked
+func (d *Detector) count(key sourceKey) {
+	if _, counted := d.counts[key]; !counted {
+		if _, known := d.baselines[key]; !known && len(d.baselines)+len(d.counts) >= d.maxSources {
+			return
+		}
+	}
+	d.counts[key]++
+}
+
+func (d *Detector) rememberTemplate(domain string, id string) {
+	if d.templates[domain] == nil {
+		d.templates[domain] = make(map[string]struct{})
+	}
+	d.templates[domain][id] = struct{}{}
+}
+
+// checkVolume compares the counts of the interval that started at intervalStart with the baselines of its season,
+// then folds the counts into the baselines. Known sources that logged nothing count as zero, until their baseline
+// of the season is at rest. Only the baselines that changed are saved.
+func (d *Detector) checkVolume(intervalStart time.Time) {
+	season := d.seasonOf(intervalStart)
+	for key, seasons := range d.baselines {
+		if _, counted := d.counts[key]; counted {
+			continue
+		}
+		if baseline, exists := seasons[season]; exists && (baseline.Mean > restingLevel || baseline.Variance > restingLevel) {
+			d.counts[key] = 0
+		}
+	}
+
+	var events []Event
+	updated := make([]Baseline, 0, len(d.counts))
+	for key, count := range d.counts {
+		if d.baselines[key] == nil {
+			d.baselines[key] = make(map[int]*Baseline)
+		}
+		baseline, exists := d.baselines[key][season]
+		if !exists {
+			baseline = &Baseline{
+				ID:     baselineID(key, season),
+				Domain: key.domain,
+				Group:  key.group,
+				Level:  key.level,
+				Season: season,
+				Mean:   count,
+			}
+			d.baselines[key][season] = baseline
+		}
+
+		if event := d.compare(key, baseline, count); event != nil {
+			events = append(events, *event)
+		}
+
+		//Exponentially weighted moving average and variance
+		delta := count - baseline.Mean
+		baseline.Mean += d.alpha * delta
+		baseline.Variance = (1 - d.alpha) * (baseline.Variance + d.alpha*delta*delta)
+		baseline.Samples++
+		updated = append(updated, *baseline)
+	}
+	d.counts = make(map[sourceKey]float64)
+
+	if len(updated) > 0 {
+		if err := d.repo.SaveBaselines(updated); err != nil {
+			log.Printf("Unable to store anomaly baselines: %v", err)
+		}
+	}
+	d.record(events)
+}
+
+// compare returns an event when the count deviates significantly from a baseline with enough samples
+func (d *Detector) compare(key sourceKey, baseline *Baseline, count float64) *Event {
+	if baseline.Samples < d.minSamples {
+		return nil
+	}
+
+	//Counts behave roughly like a Poisson process, so the deviation is never assumed smaller than that of one
+	stdDev := math.Max(math.Sqrt(baseline.Variance), math.Sqrt(math.Max(baseline.Mean, 1)))
+	delta := count - baseline.Mean
+	score := delta / stdDev
+	if math.Abs(delta) < d.minDelta || math.Abs(score) < d.threshold {
+		return nil
+	}
+
+	eventType := TypeVolumeSpike
+	if delta < 0 {
+		eventType = TypeVolumeDrop
+	}
+	cooldownKey := eventType + "\x00" + key.domain + "\x00" + key.group + "\x00" + key.level
+	now := time.Now()
+	if last, exists := d.lastEvent[cooldownKey]; exists && now.Sub(last) < d.cooldown {
+		return nil
+	}
+	d.lastEvent[cooldownKey] = now
+
+	return &Event{
+		Type:      eventType,
+		Domain:    key.domain,
+		Group:     key.group,
+		Level:     key.level,
+		Timestamp: now.UnixMilli(),
+		Observed:  count,
+		Expected:  baseline.Mean,
+		StdDev:    stdDev,
+		Score:     score,
+	}
+}
+
+// seasonOf returns the seasonal bucket of a time, in UTC
+func (d *Detector) seasonOf(t time.Time) int {
+	t = t.UTC()
+	switch d.seasonality {
+	case SeasonalityNone:
+		return 0
+	case SeasonalityHourOfDay:
+		return t.Hour()
+	default:
+		return int(t.Weekday())*24 + t.Hour()
+	}
+}
+
+// record stores the events and passes them to the webhook
+func (d *Detector) record(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+	if err := d.repo.AddEvents(events); err != nil {
+		log.Printf("Unable to store anomaly events: %v", err)
+	}
+	for _, event := range events {
+		log.Printf("Anomaly detected: %s in %s", event.Type, event.Domain)
+		d.notifier.notify(event)
+	}
+}
+
+func baselineID(key sourceKey, season int) string {
+	return key.domain + "\x00" + key.group + "\x00" + key.level + "\x00" + strconv.Itoa(season)
+}
@@ -0,0 +1,81 @@
+package anomaly
+
+import (
+	"github.com/gin-gonic/gin"
+	"gofeather/internal/tenancy"
+	"gofeather/internal/utility"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+type AnomalyHandler struct {
+	repo    AnomalyRepository
+	tenants *tenancy.Registry
+}
+
+func NewAnomalyHandler(repo AnomalyRepository, tenants *tenancy.Registry) *AnomalyHandler {
+	return &AnomalyHandler{repo: repo, tenants: tenants}
+}
+
+// GetAnomalies godoc
+//
+//	@Summary		List detected anomalies
+//	@Description	Retrieves the volume spikes, volume drops and new message templates that were detected, newest first
+//	@Tags			Anomalies
+//	@Produce		json
+//	@Param			domain	query		string	false	"Only anomalies of this domain"
+//	@Param			type	query		string	false	"volume_spike, volume_drop or new_template"
+//	@Param			since	query		string	false	"Only anomalies detected since, unix milliseconds or RFC 3339"
+//	@Param			limit	query		int		false	"Maximum amount of anomalies (default 100, max 1000)"
+//	@Success		200		{array}		Event
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/anomalies [get]
+func (h *AnomalyHandler) GetAnomalies(c *gin.Context) {
+	filter := EventFilter{Domain: c.Query("domain"), Type: c.Query("type")}
+
+	switch filter.Type {
+	case "", TypeVolumeSpike, TypeVolumeDrop, TypeNewTemplate:
+	default:
+		utility.RespondWithError(c, http.StatusBadRequest, "type must be volume_spike, volume_drop or new_template")
+		return
+	}
+
+	if since := c.Query("since"); since != "" {
+		timestamp, err := utility.ParseTime(since, 0)
+		if err != nil {
+			utility.RespondWithError(c, http.StatusBadRequest, "since must be unix milliseconds or RFC 3339")
+			return
+		}
+		filter.Since = timestamp
+	}
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(defaultEventLimit)), 10, 64)
+	if err != nil || limit <= 0 {
+		utility.RespondWithError(c, http.StatusBadRequest, "limit must be a positive number")
+		return
+	}
+	filter.Limit = min(limit, maxEventLimit)
+
+	//Callers of a tenant only see the anomalies of its domains
+	if caller := tenancy.CallerFrom(c); h.tenants != nil && !caller.SuperAdmin {
+		if filter.Domain != "" && !h.tenants.CanAccess(caller, filter.Domain) {
+			utility.RespondWithError(c, http.StatusNotFound, "domain not found")
+			return
+		}
+		filter.Domains = h.tenants.Domains(caller.Tenant)
+	}
+
+	events, err := h.repo.GetEvents(filter)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,88 @@
+package anomaly
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	// EventCollection, BaselineCollection and TemplateCollection start with an underscore to stay out of the domain list
+	EventCollection    = "_anomalies"
+	BaselineCollection = "_anomaly_baselines"
+	TemplateCollection = "_anomaly_templates"
+
+	TypeVolumeSpike = "volume_spike"
+	TypeVolumeDrop  = "volume_drop"
+	TypeNewTemplate = "new_template"
+
+	SeasonalityHourOfWeek = "hour_of_week"
+	SeasonalityHourOfDay  = "hour_of_day"
+	SeasonalityNone       = "none"
+)
+
+// Config configures the detector, durations are written like "1m"
+type Config struct {
+	// Interval is the period the volume is counted over and compared with the baseline
+	Interval    string `mapstructure:"interval"`
+	Seasonality string `mapstructure:"seasonality"`
+	// Alpha is the weight of a new count in the moving average and variance of its seasonal bucket
+	Alpha float64 `mapstructure:"alpha"`
+	// Threshold is the amount of standard deviations a count must differ from the average to be flagged
+	Threshold  float64 `mapstructure:"threshold"`
+	MinSamples int64   `mapstructure:"min_samples"`
+	// MinDelta is the minimal absolute difference in entries, so quiet sources don't alert on a handful of logs
+	MinDelta float64 `mapstructure:"min_delta"`
+	// Cooldown suppresses repeated volume events for the same source and direction
+	Cooldown string `mapstructure:"cooldown"`
+	// LearningPeriod is how long the templates of a new domain are learned before new ones are flagged
+	LearningPeriod string `mapstructure:"learning_period"`
+	MaxTemplates   int    `mapstructure:"max_templates"`
+	// MaxSources bounds the domain, group and level combinations of which the volume is learned
+	MaxSources int    `mapstructure:"max_sources"`
+	Webhook    string `mapstructure:"webhook"`
+}
+
+// Event is a detected anomaly, timestamps are in unix milliseconds
+type Event struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Type      string             `json:"type" bson:"type"`
+	Domain    string             `json:"domain" bson:"domain"`
+	Group     string             `json:"group,omitempty" bson:"group,omitempty"`
+	Level     string             `json:"level,omitempty" bson:"level,omitempty"`
+	Timestamp int64              `json:"timestamp" bson:"timestamp"`
+	// Observed, Expected, StdDev and Score describe volume events, the count of the interval against the baseline
+	Observed float64 `json:"observed,omitempty" bson:"observed,omitempty"`
+	Expected float64 `json:"expected,omitempty" bson:"expected,omitempty"`
+	StdDev   float64 `json:"std_dev,omitempty" bson:"std_dev,omitempty"`
+	Score    float64 `json:"score,omitempty" bson:"score,omitempty"`
+	// Template and Sample describe new template events, the sample being the first message with the template
+	Template string `json:"template,omitempty" bson:"template,omitempty"`
+	Sample   string `json:"sample,omitempty" bson:"sample,omitempty"`
+}
+
+// Baseline is the moving average and variance of the volume of a source in one seasonal bucket
+type Baseline struct {
+	ID       string  `json:"id" bson:"_id"`
+	Domain   string  `json:"domain" bson:"domain"`
+	Group    string  `json:"group" bson:"group"`
+	Level    string  `json:"level" bson:"level"`
+	Season   int     `json:"season" bson:"season"`
+	Mean     float64 `json:"mean" bson:"mean"`
+	Variance float64 `json:"variance" bson:"variance"`
+	Samples  int64   `json:"samples" bson:"samples"`
+}
+
+// Template is a message shape seen in a domain, with the variable parts replaced by placeholders
+type Template struct {
+	ID        string `json:"id" bson:"_id"`
+	Domain    string `json:"domain" bson:"domain"`
+	Template  string `json:"template" bson:"template"`
+	FirstSeen int64  `json:"first_seen" bson:"first_seen"`
+}
+
+// EventFilter narrows down the listed events, empty fields are not filtered on
+type EventFilter struct {
+	Domain string
+	// Domains limits the events to a set of domains, nil doesn't limit them
+	Domains []string
+	Type    string
+	Since   int64
+	Limit   int64
+}
@@ -0,0 +1,73 @@
+package anomaly
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	notifyQueueSize = 256
+	notifyAttempts  = 3
+)
+
+// notifier posts events to the configured webhook in the background, a nil notifier does nothing
+type notifier struct {
+	url    string
+	client *http.Client
+	queue  chan Event
+}
+
+func newNotifier(url string) *notifier {
+	if url == "" {
+		return nil
+	}
+	n := &notifier{url: url, client: &http.Client{Timeout: 10 * time.Second}, queue: make(chan Event, notifyQueueSize)}
+	go n.run()
+	return n
+}
+
+func (n *notifier) notify(event Event) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.queue <- event:
+	default:
+		log.Printf("Anomaly webhook queue is full, dropping %s event of %s", event.Type, event.Domain)
+	}
+}
+
+func (n *notifier) run() {
+	for event := range n.queue {
+		var err error
+		for attempt := 1; attempt <= notifyAttempts; attempt++ {
+			if err = n.post(event); err == nil {
+				break
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if err != nil {
+			log.Printf("Unable to post anomaly event to webhook: %v", err)
+		}
+	}
+}
+
+func (n *notifier) post(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	response, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package anomaly
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gofeather/internal/utility"
+)
+
+type AnomalyRepository interface {
+	AddEvents(events []Event) error
+	GetEvents(filter EventFilter) ([]Event, error)
+	GetBaselines() ([]Baseline, error)
+	SaveBaselines(baselines []Baseline) error
+	GetTemplates() ([]Template, error)
+	AddTemplates(templates []Template) error
+}
+
+type MongoAnomalyRepository struct {
+	database *mongo.Database
+}
+
+func NewMongoAnomalyRepository(database *mongo.Database) *MongoAnomalyRepository {
+	return &MongoAnomalyRepository{database: database}
+}
+
+func (r *MongoAnomalyRepository) AddEvents(events []Event) error {
+	coll := r.database.Collection(EventCollection)
+	documents := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		documents = append(documents, event)
+	}
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.InsertMany(ctx, documents)
+		return err
+	})
+}
+
+// GetEvents returns the events matching the filter, newest first
+func (r *MongoAnomalyRepository) GetEvents(filter EventFilter) ([]Event, error) {
+	results := make([]Event, 0)
+	coll := r.database.Collection(EventCollection)
+
+	query := bson.M{}
+	if filter.Domains != nil {
+		query["domain"] = bson.M{"$in": filter.Domains}
+	}
+	if filter.Domain != "" {
+		query["domain"] = filter.Domain
+	}
+	if filter.Type != "" {
+		query["type"] = filter.Type
+	}
+	if filter.Since > 0 {
+		query["timestamp"] = bson.M{"$gte": filter.Since}
+	}
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(filter.Limit)
+		cur, findErr := coll.Find(ctx, query, opts)
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+func (r *MongoAnomalyRepository) GetBaselines() ([]Baseline, error) {
+	var results []Baseline
+	coll := r.database.Collection(BaselineCollection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		cur, findErr := coll.Find(ctx, bson.M{})
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+// SaveBaselines replaces the stored baselines with the given ones, adding the ones that are new
+func (r *MongoAnomalyRepository) SaveBaselines(baselines []Baseline) error {
+	coll := r.database.Collection(BaselineCollection)
+	models := make([]mongo.WriteModel, 0, len(baselines))
+	for _, baseline := range baselines {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": baseline.ID}).SetReplacement(baseline).SetUpsert(true))
+	}
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		return err
+	})
+}
+
+func (r *MongoAnomalyRepository) GetTemplates() ([]Template, error) {
+	var results []Template
+	coll := r.database.Collection(TemplateCollection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		cur, findErr := coll.Find(ctx, bson.M{})
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+func (r *MongoAnomalyRepository) AddTemplates(templates []Template) error {
+	coll := r.database.Collection(TemplateCollection)
+	models := make([]mongo.WriteModel, 0, len(templates))
+	for _, template := range templates {
+		//Another instance may have seen the template first, which is kept
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": template.ID}).
+			SetUpdate(bson.M{"$setOnInsert": template}).SetUpsert(true))
+	}
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		return err
+	})
+}
@@ -0,0 +1,18 @@
+package anomaly
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gofeather/internal/logging"
+	"gofeather/internal/tenancy"
+)
+
+// CreateRoutes starts the detector on the entries accepted by the pipeline and registers the route listing its events.
+// With a tenant registry the events are scoped to the caller's tenant.
+func CreateRoutes(engine *gin.Engine, database *mongo.Database, pipeline *logging.Pipeline, tenants *tenancy.Registry) {
+	anomalyRepo := NewMongoAnomalyRepository(database)
+	pipeline.AddObserver(NewDetector(anomalyRepo, LoadConfig()))
+	anomalyHandler := NewAnomalyHandler(anomalyRepo, tenants)
+
+	engine.GET("/anomalies", tenants.Authenticate(), anomalyHandler.GetAnomalies)
+}
@@ -0,0 +1,407 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gookit/config/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gofeather/internal/constants"
+	"gofeather/internal/logging"
+	"gofeather/internal/utility"
+	"io"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInterval   = time.Hour
+	defaultBatchSize  = 10000
+	defaultRestoreTTL = 24 * time.Hour
+	storeTimeout      = time.Minute
+
+	// deleteChunkSize bounds the amount of ids in a single delete query
+	deleteChunkSize = 1000
+	// disabledAge keeps a domain out of the archive when used as its age
+	disabledAge = "off"
+)
+
+// DomainLister lists the domains holding logs, implemented by the log repository
+type DomainLister interface {
+	ListDomains() ([]string, error)
+}
+
+// RetentionSource provides the age of a domain when it isn't set in the archive settings,
+// implemented by the tenant registry with the retention of the tenant owning the domain
+type RetentionSource interface {
+	Retention(domain string) (string, bool)
+}
+
+// Archiver moves log entries past their domain's age into compressed NDJSON files partitioned by time,
+// and restores archived ranges on request
+type Archiver struct {
+	repo       ArchiveRepository
+	domains    DomainLister
+	retention  RetentionSource
+	store      Store
+	config     Config
+	interval   time.Duration
+	defaultAge time.Duration
+	ages       map[string]time.Duration
+	partition  time.Duration
+	restoreTTL time.Duration
+	// running makes sure a manually triggered run doesn't overlap with a scheduled one, or with a restore whose
+	// entries the run would otherwise archive again
+	running sync.Mutex
+}
+
+func NewArchiver(repo ArchiveRepository, domains DomainLister, retention RetentionSource, store Store, cfg Config) *Archiver {
+	archiver := &Archiver{
+		repo:       repo,
+		domains:    domains,
+		retention:  retention,
+		store:      store,
+		config:     cfg,
+		interval:   utility.DurationOrDefault(cfg.Interval, defaultInterval),
+		defaultAge: utility.DurationOrDefault(cfg.After, 0),
+		ages:       make(map[string]time.Duration),
+		partition:  24 * time.Hour,
+		restoreTTL: utility.DurationOrDefault(cfg.RestoreTTL, defaultRestoreTTL),
+	}
+	if cfg.Partition == PartitionHour {
+		archiver.partition = time.Hour
+	}
+	if archiver.config.BatchSize <= 0 {
+		archiver.config.BatchSize = defaultBatchSize
+	}
+	if !logging.ValidTimeField(cfg.TimeField) {
+		log.Printf("Invalid archive time field %s, using %s", cfg.TimeField, logging.TimeFieldReceived)
+		archiver.config.TimeField = ""
+	}
+	for domain, age := range cfg.Domains {
+		if age == disabledAge {
+			archiver.ages[domain] = 0
+		} else {
+			archiver.ages[domain] = utility.DurationOrDefault(age, archiver.defaultAge)
+		}
+	}
+	return archiver
+}
+
+func LoadConfig() Config {
+	var archiveConfig Config
+	if config.Exists(constants.Archive) {
+		if err := config.BindStruct(constants.Archive, &archiveConfig); err != nil {
+			log.Printf("Unable to read archive settings from config: %v", err)
+		}
+	}
+	return archiveConfig
+}
+
+// Start archives on the configured interval in the background
+func (a *Archiver) Start() {
+	go func() {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			if err := a.Run(context.Background()); err != nil {
+				log.Printf("Archiving failed: %v", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Run archives every domain with an age once, expired restores are removed along the way
+func (a *Archiver) Run(ctx context.Context) error {
+	a.running.Lock()
+	defer a.running.Unlock()
+
+	domains, err := a.domains.ListDomains()
+	if err != nil {
+		return err
+	}
+
+	var failed []error
+	for _, domain := range domains {
+		age := a.ageOf(domain)
+		if age <= 0 {
+			continue
+		}
+		if err := a.archiveDomain(ctx, domain, time.Now().Add(-age).UnixMilli()); err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", domain, err))
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// ageOf returns the age of a domain set in the archive settings, then that of the retention source
+// and finally the default age. An age of zero keeps the domain out of the archive.
+func (a *Archiver) ageOf(domain string) time.Duration {
+	if age, exists := a.ages[domain]; exists {
+		return age
+	}
+	if a.retention != nil {
+		if retention, exists := a.retention.Retention(domain); exists {
+			if retention == disabledAge {
+				return 0
+			}
+			return utility.DurationOrDefault(retention, a.defaultAge)
+		}
+	}
+	return a.defaultAge
+}
+
+// archiveDomain writes the entries logged before the cutoff to archive files, one file per partition and batch.
+// Entries are only deleted after their file is stored and indexed.
+func (a *Archiver) archiveDomain(ctx context.Context, domain string, cutoff int64) error {
+	active, err := a.activeRestores(domain)
+	if err != nil {
+		return err
+	}
+
+	for ctx.Err() == nil {
+		entries, err := a.repo.FindOld(domain, a.config.TimeField, cutoff, active, a.config.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		//A file only holds a single partition, the rest of the batch is picked up by the next query
+		partitionStart := a.partitionStart(logging.EntryTime(entries[0], a.config.TimeField))
+		partitionEnd := partitionStart + a.partition.Milliseconds()
+		end := 0
+		for end < len(entries) && logging.EntryTime(entries[end], a.config.TimeField) < partitionEnd {
+			end++
+		}
+		entries = entries[:end]
+
+		archive, err := a.writeArchive(ctx, domain, partitionStart, entries)
+		if err != nil {
+			return err
+		}
+		if err := a.deleteArchived(domain, entries); err != nil {
+			return err
+		}
+		log.Printf("Archived %d log entries of %s to %s", archive.Count, domain, archive.Key)
+	}
+	return ctx.Err()
+}
+
+// activeRestores returns the restored ranges that are still in use, ranges that expired are removed from the domain
+func (a *Archiver) activeRestores(domain string) ([]Restore, error) {
+	restores, err := a.repo.GetRestores(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	active := make([]Restore, 0, len(restores))
+	for _, restore := range restores {
+		if restore.ExpiresAt > now {
+			active = append(active, restore)
+			continue
+		}
+		//The restored entries are still in their archive files, so they can simply be removed again. Entries logged
+		//within the range that were never archived aren't marked with the restore and stay.
+		if err := a.repo.DeleteRestored(domain, restore.ID); err != nil {
+			return nil, err
+		}
+		if err := a.repo.DeleteRestore(restore.ID); err != nil {
+			return nil, err
+		}
+	}
+	return active, nil
+}
+
+func (a *Archiver) writeArchive(ctx context.Context, domain string, partitionStart int64, entries []logging.JsonLog) (*Archive, error) {
+	var body bytes.Buffer
+	writer := gzip.NewWriter(&body)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	archive := Archive{
+		ID:             primitive.NewObjectID(),
+		Domain:         domain,
+		Key:            a.archiveKey(domain, partitionStart, entries[0].ID),
+		PartitionStart: partitionStart,
+		From:           logging.EntryTime(entries[0], a.config.TimeField),
+		To:             logging.EntryTime(entries[0], a.config.TimeField),
+		Count:          int64(len(entries)),
+		Size:           int64(body.Len()),
+		CreatedAt:      time.Now().UnixMilli(),
+		TimeField:      a.config.TimeField,
+	}
+	//Entries without an event time are sorted first, so the range is taken from all entries
+	for _, entry := range entries {
+		archive.From = min(archive.From, logging.EntryTime(entry, a.config.TimeField))
+		archive.To = max(archive.To, logging.EntryTime(entry, a.config.TimeField))
+	}
+
+	storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	if err := a.store.Put(storeCtx, archive.Key, body.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := a.repo.AddArchive(archive); err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+func (a *Archiver) deleteArchived(domain string, entries []logging.JsonLog) error {
+	ids := make([]primitive.ObjectID, 0, deleteChunkSize)
+	for i, entry := range entries {
+		ids = append(ids, entry.ID)
+		if len(ids) == deleteChunkSize || i == len(entries)-1 {
+			if err := a.repo.DeleteLogs(domain, ids); err != nil {
+				return err
+			}
+			ids = ids[:0]
+		}
+	}
+	return nil
+}
+
+// partitionStart truncates a timestamp to the start of its partition in UTC
+func (a *Archiver) partitionStart(timestamp int64) int64 {
+	return time.UnixMilli(timestamp).UTC().Truncate(a.partition).UnixMilli()
+}
+
+// archiveKey names a file after its domain, partition and first entry, like payments/2024/05/01/<id>.ndjson.gz.
+// The domain is escaped, so it is always a single segment of the key.
+func (a *Archiver) archiveKey(domain string, partitionStart int64, firstID primitive.ObjectID) string {
+	layout := "2006/01/02"
+	if a.partition == time.Hour {
+		layout = "2006/01/02/15"
+	}
+	return fmt.Sprintf("%s/%s/%s.ndjson.gz", url.PathEscape(domain), time.UnixMilli(partitionStart).UTC().Format(layout), firstID.Hex())
+}
+
+// Read passes the archived entries of a domain within the range that match the filter to handle, oldest first.
+// The range applies to the time field each archive was partitioned on. Reading stops without an error when handle returns false.
+func (a *Archiver) Read(ctx context.Context, domain string, from int64, to int64, filter logging.LogFilter, handle func(logging.JsonLog) bool) error {
+	archives, err := a.repo.GetArchives(domain, from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, archive := range archives {
+		more, err := a.readArchive(ctx, archive, func(entry logging.JsonLog) bool {
+			entryTime := logging.EntryTime(entry, archive.TimeField)
+			if entryTime < from || entryTime > to || !filter.Matches(entry) {
+				return true
+			}
+			return handle(entry)
+		})
+		if err != nil {
+			return fmt.Errorf("reading archive %s: %w", archive.Key, err)
+		}
+		if !more {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (a *Archiver) readArchive(ctx context.Context, archive Archive, handle func(logging.JsonLog) bool) (bool, error) {
+	storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	file, err := a.store.Open(storeCtx, archive.Key)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return false, err
+	}
+	decoder := json.NewDecoder(reader)
+	for {
+		var entry logging.JsonLog
+		if err := decoder.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				return true, nil
+			}
+			return false, err
+		}
+		entry.Domain = archive.Domain
+		if !handle(entry) {
+			return false, nil
+		}
+	}
+}
+
+// Restore copies the archived entries of a domain within the range back into the domain until the restore expires.
+// The range is cut off at the newest archived entry, so the archiver only leaves the archived part of it alone.
+// A restore waits for a running archive run to finish, which reads the active restores only once per domain.
+func (a *Archiver) Restore(ctx context.Context, domain string, from int64, to int64) (*RestoreResult, error) {
+	a.running.Lock()
+	defer a.running.Unlock()
+
+	archives, err := a.repo.GetArchives(domain, from, to)
+	if err != nil {
+		return nil, err
+	}
+	result := &RestoreResult{Archives: len(archives)}
+	if len(archives) == 0 {
+		return result, nil
+	}
+
+	newest := from
+	for _, archive := range archives {
+		newest = max(newest, archive.To)
+	}
+	to = min(to, newest)
+
+	//The restore is recorded first, so the archiver leaves the range alone while it is being copied back
+	result.Restore = Restore{
+		ID:        primitive.NewObjectID(),
+		Domain:    domain,
+		From:      from,
+		To:        to,
+		ExpiresAt: time.Now().Add(a.restoreTTL).UnixMilli(),
+		TimeField: a.config.TimeField,
+	}
+	if err := a.repo.AddRestore(result.Restore); err != nil {
+		return nil, err
+	}
+
+	batch := make([]logging.JsonLog, 0, deleteChunkSize)
+	var insertErr error
+	flush := func() bool {
+		inserted, err := a.repo.RestoreLogs(domain, result.Restore.ID, batch)
+		result.Restored += inserted
+		batch = batch[:0]
+		insertErr = err
+		return err == nil
+	}
+	err = a.Read(ctx, domain, from, to, logging.LogFilter{}, func(entry logging.JsonLog) bool {
+		batch = append(batch, entry)
+		return len(batch) < deleteChunkSize || flush()
+	})
+	if err == nil && insertErr == nil && len(batch) > 0 {
+		flush()
+	}
+	if err == nil {
+		err = insertErr
+	}
+	return result, err
+}
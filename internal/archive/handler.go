@@ -0,0 +1,163 @@
+package archive
+
+import (
+	"github.com/gin-gonic/gin"
+	"gofeather/internal/logging"
+	"gofeather/internal/utility"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultReadLimit = 1000
+	maxReadLimit     = 10000
+)
+
+type ArchiveHandler struct {
+	archiver *Archiver
+	repo     ArchiveRepository
+}
+
+func NewArchiveHandler(archiver *Archiver, repo ArchiveRepository) *ArchiveHandler {
+	return &ArchiveHandler{archiver: archiver, repo: repo}
+}
+
+// GetArchives godoc
+//
+//	@Summary		List the archives of a domain
+//	@Description	Retrieves the index of the archive files holding entries of a domain within the range
+//	@Tags			Archive
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Param			from	query		string	false	"Start of the range, unix milliseconds or RFC 3339"
+//	@Param			to		query		string	false	"End of the range, unix milliseconds or RFC 3339"
+//	@Success		200		{array}		Archive
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/archive/{domain} [get]
+func (h *ArchiveHandler) GetArchives(c *gin.Context) {
+	from, to, ok := rangeFromQuery(c, false)
+	if !ok {
+		return
+	}
+
+	archives, err := h.repo.GetArchives(c.Param("domain"), from, to)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, archives)
+}
+
+// GetArchivedLogs godoc
+//
+//	@Summary		Query archived logs
+//	@Description	Reads the archived entries of a domain within the range from the archive files, oldest first
+//	@Tags			Archive
+//	@Produce		json
+//	@Param			domain			path		string	true	"Domain name"
+//	@Param			from			query		string	true	"Start of the range, unix milliseconds or RFC 3339"
+//	@Param			to				query		string	true	"End of the range, unix milliseconds or RFC 3339"
+//	@Param			group			query		string	false	"Only logs of this group"
+//	@Param			tag				query		string	false	"Only logs with this tag"
+//	@Param			level			query		string	false	"Only logs of this level"
+//	@Param			exception_type	query		string	false	"Only logs with an exception of this type"
+//	@Param			limit			query		int		false	"Maximum amount of logs (default 1000, max 10000)"
+//	@Success		200				{array}		logging.JsonLog
+//	@Failure		400				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/archive/{domain}/logs [get]
+func (h *ArchiveHandler) GetArchivedLogs(c *gin.Context) {
+	from, to, ok := rangeFromQuery(c, true)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultReadLimit)))
+	if err != nil || limit <= 0 {
+		utility.RespondWithError(c, http.StatusBadRequest, "limit must be a positive number")
+		return
+	}
+	limit = min(limit, maxReadLimit)
+
+	results := make([]logging.JsonLog, 0)
+	err = h.archiver.Read(c.Request.Context(), c.Param("domain"), from, to, logging.FilterFromQuery(c), func(entry logging.JsonLog) bool {
+		results = append(results, entry)
+		return len(results) < limit
+	})
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// RestoreLogs godoc
+//
+//	@Summary		Restore archived logs
+//	@Description	Copies the archived entries of a domain within the range back into the domain, they are removed again once restore_ttl passed
+//	@Tags			Archive
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Param			from	query		string	true	"Start of the range, unix milliseconds or RFC 3339"
+//	@Param			to		query		string	true	"End of the range, unix milliseconds or RFC 3339"
+//	@Success		200		{object}	RestoreResult
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/archive/{domain}/restore [post]
+func (h *ArchiveHandler) RestoreLogs(c *gin.Context) {
+	from, to, ok := rangeFromQuery(c, true)
+	if !ok {
+		return
+	}
+
+	result, err := h.archiver.Restore(c.Request.Context(), c.Param("domain"), from, to)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RunArchiver godoc
+//
+//	@Summary		Archive now
+//	@Description	Runs the archiver right away instead of waiting for the interval
+//	@Tags			Archive
+//	@Success		204
+//	@Failure		500	{object}	error
+//	@Router			/admin/archive/run [post]
+func (h *ArchiveHandler) RunArchiver(c *gin.Context) {
+	if err := h.archiver.Run(c.Request.Context()); err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// rangeFromQuery reads the from and to query parameters, an open end covers all time unless the range is required
+func rangeFromQuery(c *gin.Context, required bool) (int64, int64, bool) {
+	from, err := utility.ParseTime(c.Query("from"), 0)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, "from "+err.Error())
+		return 0, 0, false
+	}
+	to, err := utility.ParseTime(c.Query("to"), math.MaxInt64)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, "to "+err.Error())
+		return 0, 0, false
+	}
+	if required && (c.Query("from") == "" || c.Query("to") == "") {
+		utility.RespondWithError(c, http.StatusBadRequest, "from and to are required")
+		return 0, 0, false
+	}
+	if from > to {
+		utility.RespondWithError(c, http.StatusBadRequest, "from must be before to")
+		return 0, 0, false
+	}
+	return from, to, true
+}
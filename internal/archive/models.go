@@ -0,0 +1,76 @@
+package archive
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	// ArchiveCollection indexes the archive files, the underscore keeps it out of the domain list
+	ArchiveCollection = "_archives"
+	// RestoreCollection holds the archived ranges that were restored into their domain
+	RestoreCollection = "_archive_restores"
+
+	PartitionDay  = "day"
+	PartitionHour = "hour"
+
+	StoreLocal = "local"
+	StoreS3    = "s3"
+)
+
+// Config configures the archiver, durations are written like "720h"
+type Config struct {
+	Interval string `mapstructure:"interval"`
+	// After is the default age after which entries are archived, domains without an age are not archived
+	After string `mapstructure:"after"`
+	// Domains overrides the age per domain, "off" keeps a domain out of the archive
+	Domains    map[string]string `mapstructure:"domains"`
+	Partition  string            `mapstructure:"partition"`
+	BatchSize  int64             `mapstructure:"batch_size"`
+	RestoreTTL string            `mapstructure:"restore_ttl"`
+	Store      StoreConfig       `mapstructure:"store"`
+	// TimeField is the time of the entries their age and partition are taken from, received_at (the default) or event_time
+	TimeField string `mapstructure:"time_field"`
+}
+
+// StoreConfig selects where archive files are written, a local directory or an S3-compatible bucket
+type StoreConfig struct {
+	Type      string `mapstructure:"type"`
+	Path      string `mapstructure:"path"`
+	Endpoint  string `mapstructure:"endpoint"`
+	Bucket    string `mapstructure:"bucket"`
+	Prefix    string `mapstructure:"prefix"`
+	Region    string `mapstructure:"region"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	UseSSL    bool   `mapstructure:"use_ssl"`
+}
+
+// Archive is an index entry of an archive file, holding the entries of a domain from one partition.
+// Timestamps are in unix milliseconds like the log entries and refer to the time field the file was partitioned on.
+type Archive struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Domain         string             `json:"domain" bson:"domain"`
+	Key            string             `json:"key" bson:"key"`
+	PartitionStart int64              `json:"partition_start" bson:"partition_start"`
+	From           int64              `json:"from" bson:"from"`
+	To             int64              `json:"to" bson:"to"`
+	Count          int64              `json:"count" bson:"count"`
+	Size           int64              `json:"size" bson:"size"`
+	CreatedAt      int64              `json:"created_at" bson:"created_at"`
+	// TimeField is empty for archives written before entries had an event time, which used the receive time
+	TimeField string `json:"time_field,omitempty" bson:"time_field,omitempty"`
+}
+
+// Restore is a range of archived entries copied back into their domain, which the archiver removes again once it expires
+type Restore struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Domain    string             `json:"domain" bson:"domain"`
+	From      int64              `json:"from" bson:"from"`
+	To        int64              `json:"to" bson:"to"`
+	ExpiresAt int64              `json:"expires_at" bson:"expires_at"`
+	TimeField string             `json:"time_field,omitempty" bson:"time_field,omitempty"`
+}
+
+type RestoreResult struct {
+	Restored int64   `json:"restored"`
+	Archives int     `json:"archives"`
+	Restore  Restore `json:"restore"`
+}
@@ -0,0 +1,191 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gofeather/internal/logging"
+	"gofeather/internal/utility"
+)
+
+// restoreIDKey marks the entries copied back by a restore, so only those are removed once it expires
+const restoreIDKey = "restore_id"
+
+// restoredLog is an entry copied back from an archive, marked with the restore that copied it
+type restoredLog struct {
+	logging.JsonLog `bson:",inline"`
+	RestoreID       primitive.ObjectID `bson:"restore_id"`
+}
+
+type ArchiveRepository interface {
+	EnsureIndexes() error
+	FindOld(domain string, timeField string, before int64, exclude []Restore, limit int64) ([]logging.JsonLog, error)
+	DeleteLogs(domain string, ids []primitive.ObjectID) error
+	DeleteRestored(domain string, restoreID primitive.ObjectID) error
+	RestoreLogs(domain string, restoreID primitive.ObjectID, entries []logging.JsonLog) (int64, error)
+	AddArchive(archive Archive) error
+	GetArchives(domain string, from int64, to int64) ([]Archive, error)
+	AddRestore(restore Restore) error
+	GetRestores(domain string) ([]Restore, error)
+	DeleteRestore(id primitive.ObjectID) error
+}
+
+type MongoArchiveRepository struct {
+	database *mongo.Database
+}
+
+func NewMongoArchiveRepository(database *mongo.Database) *MongoArchiveRepository {
+	return &MongoArchiveRepository{database: database}
+}
+
+// EnsureIndexes indexes the archives on their domain and range, which every lookup of archive files filters on
+func (r *MongoArchiveRepository) EnsureIndexes() error {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "domain", Value: 1}, {Key: "from", Value: 1}, {Key: "to", Value: 1}}}
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := r.database.Collection(ArchiveCollection).Indexes().CreateOne(ctx, model)
+		return err
+	})
+}
+
+// FindOld returns the oldest entries of a domain of which the time field is before the timestamp, oldest first,
+// leaving out the restored ranges
+func (r *MongoArchiveRepository) FindOld(domain string, timeField string, before int64, exclude []Restore, limit int64) ([]logging.JsonLog, error) {
+	var results []logging.JsonLog
+	coll := r.database.Collection(domain)
+
+	filter := logging.TimeCondition(timeField, bson.M{"$lt": before})
+	if len(exclude) > 0 {
+		ranges := make(bson.A, 0, len(exclude))
+		for _, restore := range exclude {
+			ranges = append(ranges, logging.TimeCondition(restore.TimeField, bson.M{"$gte": restore.From, "$lte": restore.To}))
+		}
+		filter["$nor"] = ranges
+	}
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		pipeline := logging.TimeSortPipeline(timeField, filter, 1, limit)
+		cur, aggregateErr := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+		if aggregateErr != nil {
+			return aggregateErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	for i := range results {
+		results[i].Domain = domain
+	}
+	return results, err
+}
+
+func (r *MongoArchiveRepository) DeleteLogs(domain string, ids []primitive.ObjectID) error {
+	coll := r.database.Collection(domain)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+		return err
+	})
+}
+
+// DeleteRestored removes the entries of a domain copied back by the restore
+func (r *MongoArchiveRepository) DeleteRestored(domain string, restoreID primitive.ObjectID) error {
+	coll := r.database.Collection(domain)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.DeleteMany(ctx, bson.M{restoreIDKey: restoreID})
+		return err
+	})
+}
+
+// RestoreLogs inserts archived entries back into their domain with their original ids, marked with the restore.
+// Entries that are still present are skipped and left unmarked. It returns the amount of entries inserted.
+func (r *MongoArchiveRepository) RestoreLogs(domain string, restoreID primitive.ObjectID, entries []logging.JsonLog) (int64, error) {
+	coll := r.database.Collection(domain)
+	documents := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		documents = append(documents, restoredLog{JsonLog: entry, RestoreID: restoreID})
+	}
+
+	var inserted int64
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		//The inserted ids of the result hold the ids of every document, so the failed writes are counted from the error
+		_, insertErr := coll.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+		if insertErr == nil {
+			inserted = int64(len(documents))
+			return nil
+		}
+
+		var bulkErr mongo.BulkWriteException
+		if errors.As(insertErr, &bulkErr) {
+			inserted = int64(len(documents) - len(bulkErr.WriteErrors))
+		}
+		if utility.OnlyDuplicateKeys(insertErr) {
+			return nil
+		}
+		return insertErr
+	})
+	return inserted, err
+}
+
+func (r *MongoArchiveRepository) AddArchive(archive Archive) error {
+	coll := r.database.Collection(ArchiveCollection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.InsertOne(ctx, archive)
+		return err
+	})
+}
+
+// GetArchives returns the archives of a domain holding entries within the range, oldest first
+func (r *MongoArchiveRepository) GetArchives(domain string, from int64, to int64) ([]Archive, error) {
+	results := make([]Archive, 0)
+	coll := r.database.Collection(ArchiveCollection)
+	filter := bson.M{"domain": domain, "from": bson.M{"$lte": to}, "to": bson.M{"$gte": from}}
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		opts := options.Find().SetSort(bson.D{{Key: "from", Value: 1}})
+		cur, findErr := coll.Find(ctx, filter, opts)
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+func (r *MongoArchiveRepository) AddRestore(restore Restore) error {
+	coll := r.database.Collection(RestoreCollection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.InsertOne(ctx, restore)
+		return err
+	})
+}
+
+func (r *MongoArchiveRepository) GetRestores(domain string) ([]Restore, error) {
+	var results []Restore
+	coll := r.database.Collection(RestoreCollection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		cur, findErr := coll.Find(ctx, bson.M{"domain": domain})
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+func (r *MongoArchiveRepository) DeleteRestore(id primitive.ObjectID) error {
+	coll := r.database.Collection(RestoreCollection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.DeleteOne(ctx, bson.M{"_id": id})
+		return err
+	})
+}
@@ -0,0 +1,37 @@
+package archive
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gofeather/internal/tenancy"
+	"log"
+)
+
+// CreateRoutes starts the archiver and registers its routes, the program is fatally closed when the store can't be reached.
+// With a tenant registry the routes are scoped to the caller's tenant and its retention applies to its domains.
+func CreateRoutes(engine *gin.Engine, database *mongo.Database, domains DomainLister, tenants *tenancy.Registry) {
+	archiveConfig := LoadConfig()
+	store, err := NewStore(archiveConfig.Store)
+	if err != nil {
+		log.Fatalf("Failed to open archive store: %v", err)
+	}
+
+	archiveRepo := NewMongoArchiveRepository(database)
+	if err := archiveRepo.EnsureIndexes(); err != nil {
+		log.Printf("Unable to create the archive indexes: %v", err)
+	}
+	var retention RetentionSource
+	if tenants != nil {
+		retention = tenants
+	}
+	archiver := NewArchiver(archiveRepo, domains, retention, store, archiveConfig)
+	archiveHandler := NewArchiveHandler(archiver, archiveRepo)
+	archiver.Start()
+
+	scoped := engine.Group("/archive", tenants.Authenticate(), tenants.RequireDomain())
+	scoped.GET("/:domain", archiveHandler.GetArchives)
+	scoped.GET("/:domain/logs", archiveHandler.GetArchivedLogs)
+	scoped.POST("/:domain/restore", archiveHandler.RestoreLogs)
+
+	engine.POST("/admin/archive/run", tenants.Authenticate(), tenants.RequireSuperAdmin(), archiveHandler.RunArchiver)
+}
@@ -0,0 +1,159 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Store holds the archive files, keys are slash separated paths
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// errInvalidKey is returned for keys that would point outside the root or prefix of the store
+var errInvalidKey = errors.New("invalid archive key")
+
+// checkKey makes sure a key is a relative path without . or .. segments, so it stays below the root or prefix
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("%w %q", errInvalidKey, key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w %q", errInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+// NewStore creates the store selected in the configuration
+func NewStore(cfg StoreConfig) (Store, error) {
+	switch cfg.Type {
+	case "", StoreLocal:
+		root := cfg.Path
+		if root == "" {
+			root = "archive"
+		}
+		return &LocalStore{root: root}, nil
+	case StoreS3:
+		return NewS3Store(cfg)
+	default:
+		return nil, fmt.Errorf("unknown archive store type %s, use local or s3", cfg.Type)
+	}
+}
+
+// LocalStore writes the archive files below a directory on the local disk
+type LocalStore struct {
+	root string
+}
+
+func (s *LocalStore) Put(_ context.Context, key string, data []byte) error {
+	target, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	//Written under a temporary name first, so a crash never leaves a partial archive behind
+	temporary := target + ".tmp"
+	if err := os.WriteFile(temporary, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, target)
+}
+
+func (s *LocalStore) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	target, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(target)
+}
+
+// path returns the file of a key, which has to resolve to a file below the root
+func (s *LocalStore) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	root := filepath.Clean(s.root)
+	target := filepath.Join(root, filepath.FromSlash(key))
+	if relative, err := filepath.Rel(root, target); err != nil || !filepath.IsLocal(relative) {
+		return "", fmt.Errorf("%w %q", errInvalidKey, key)
+	}
+	return target, nil
+}
+
+// S3Store writes the archive files to a bucket of an S3-compatible object store such as MinIO
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store connects to the object store, creating the bucket when it doesn't exist yet
+func NewS3Store(cfg StoreConfig) (*S3Store, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("the s3 archive store needs an endpoint and a bucket")
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &S3Store{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte) error {
+	object, err := s.object(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, object, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/gzip"})
+	return err
+}
+
+func (s *S3Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.object(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.client.GetObject(ctx, s.bucket, object, minio.GetObjectOptions{})
+}
+
+// object returns the object name of a key below the prefix
+func (s *S3Store) object(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return path.Join(s.prefix, key), nil
+}
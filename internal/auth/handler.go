@@ -184,6 +184,9 @@ func (h *AuthenticationHandler) RefreshAccessToken(c *gin.Context) {
 			utility.RespondWithError(c, http.StatusBadRequest, "claims could not be validated")
 			return
 		}
+		//Keep the names in the new token, tenants and super admins may be configured by username
+		userDetails.Username, _ = userMap["username"].(string)
+		userDetails.Email, _ = userMap["email"].(string)
 	}
 
 	h.generateAndSaveTokens(c, userDetails)
@@ -217,6 +220,9 @@ func (h *AuthenticationHandler) Logout(c *gin.Context) {
 		utility.RespondWithError(c, http.StatusBadRequest, "session not found")
 		return
 	}
+	if sessions != nil {
+		sessions.forget(requestBody.Token)
+	}
 
 	c.JSON(http.StatusOK, "ok")
 }
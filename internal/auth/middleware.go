@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/utility"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	userContextKey = "auth_user"
+	// sessionCacheTTL is how long a token found in the sessions is trusted before it's looked up again,
+	// a logout on another instance takes at most this long to end the token
+	sessionCacheTTL = 30 * time.Second
+)
+
+// ErrSessionEnded is returned for an access token of which the session was removed by a logout or a newer login
+var ErrSessionEnded = errors.New("session ended")
+
+// sessions checks that the access tokens still have a session, it's set by Init
+var sessions *sessionChecker
+
+type sessionChecker struct {
+	mu       sync.Mutex
+	repo     SessionRepository
+	verified map[string]time.Time
+}
+
+func newSessionChecker(repo SessionRepository) *sessionChecker {
+	return &sessionChecker{repo: repo, verified: make(map[string]time.Time)}
+}
+
+// check looks up the session of a token, the lookups share the connection of the repository so they're made one at a time
+func (s *sessionChecker) check(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if verifiedAt, exists := s.verified[token]; exists && now.Sub(verifiedAt) < sessionCacheTTL {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exists, err := s.repo.sessionExists(ctx, token)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		delete(s.verified, token)
+		return ErrSessionEnded
+	}
+
+	for cached, verifiedAt := range s.verified {
+		if now.Sub(verifiedAt) >= sessionCacheTTL {
+			delete(s.verified, cached)
+		}
+	}
+	s.verified[token] = now
+	return nil
+}
+
+func (s *sessionChecker) forget(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.verified, token)
+}
+
+// User is the account an access token was issued to
+type User struct {
+	Id       string `json:"id" bson:"id"`
+	Username string `json:"username" bson:"username"`
+}
+
+// RequireUser aborts requests without a valid access token in the Authorization header with a 401,
+// the user of the token is available to the next handlers through CurrentUser
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || tokenString == "" {
+			utility.RespondWithError(c, http.StatusUnauthorized, "missing bearer token")
+			c.Abort()
+			return
+		}
+
+		user, err := ParseAccessToken(tokenString)
+		if err != nil {
+			utility.RespondWithError(c, http.StatusUnauthorized, "invalid access token")
+			c.Abort()
+			return
+		}
+
+		c.Set(userContextKey, user)
+		c.Next()
+	}
+}
+
+// CurrentUser returns the user set by RequireUser
+func CurrentUser(c *gin.Context) (User, bool) {
+	value, exists := c.Get(userContextKey)
+	if !exists {
+		return User{}, false
+	}
+	user, ok := value.(User)
+	return user, ok
+}
+
+// ParseAccessToken verifies an access token issued by Login and returns its user. With the auth feature on the token
+// also needs a session, so it ends at logout.
+func ParseAccessToken(tokenString string) (User, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.String(constants.SecretKey)), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(config.String(constants.JWTIssuer)),
+		jwt.WithExpirationRequired())
+	if err != nil {
+		return User{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return User{}, jwt.ErrTokenInvalidClaims
+	}
+	var user User
+	if user.Id, err = claims.GetSubject(); err != nil || user.Id == "" {
+		return User{}, jwt.ErrTokenInvalidClaims
+	}
+	if userMap, ok := claims["user"].(map[string]interface{}); ok {
+		user.Username, _ = userMap["username"].(string)
+	}
+	if sessions != nil {
+		if err := sessions.check(tokenString); err != nil {
+			return User{}, err
+		}
+	}
+	return user, nil
+}
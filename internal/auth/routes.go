@@ -18,6 +18,7 @@ func Init(engine *gin.Engine, connection *pgx.Conn) {
 	userRepo := NewUserAuthService(connection)
 	sessionRepo := NewSessionService(connection)
 	authHandler := NewAuthHandler(userRepo, sessionRepo)
+	sessions = newSessionChecker(sessionRepo)
 
 	engine.POST("/auth/register", authHandler.Register)
 	engine.POST("/auth/login", authHandler.Login)
@@ -13,6 +13,7 @@ type SessionRepository interface {
 	refreshSession(ctx context.Context, refreshToken string) (string, error)
 	getSessionByRefreshToken(ctx context.Context, tokenString string) (*Session, error)
 	removeSessionByToken(ctx context.Context, token string) error
+	sessionExists(ctx context.Context, token string) (bool, error)
 }
 
 type SessionService struct {
@@ -113,3 +114,20 @@ func (s *SessionService) removeSessionByToken(ctx context.Context, token string)
 	}
 	return nil
 }
+
+// sessionExists tells whether the access token still belongs to a session, it's gone once the user logged out
+func (s *SessionService) sessionExists(ctx context.Context, token string) (bool, error) {
+	exists, err := database.ExecuteTransaction(s.conn, ctx, func(tx pgx.Tx) (interface{}, error) {
+		var exists bool
+		query := `SELECT EXISTS (SELECT 1 FROM "sessions" WHERE token=$1)`
+		if err := tx.QueryRow(ctx, query, token).Scan(&exists); err != nil {
+			return nil, err
+		}
+		return exists, nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return exists.(bool), nil
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Tokens are the tokens handed out by the authentication routes
+type Tokens struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+// ExpiresAt is when the access token expires, counted from now
+func (t Tokens) ExpiresAt() time.Time {
+	return time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+// Login signs in with a username or an email address, the client uses the access token from then on
+func (c *Client) Login(ctx context.Context, username, email, password string) (*Tokens, error) {
+	body := map[string]string{"username": username, "email": email, "password": password}
+	return c.requestTokens(ctx, "/auth/login", body)
+}
+
+// Refresh trades a refresh token for a new access token
+func (c *Client) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	return c.requestTokens(ctx, "/auth/refresh", map[string]string{"refresh_token": refreshToken})
+}
+
+// Logout ends the session of the access token
+func (c *Client) Logout(ctx context.Context) error {
+	request, err := c.newJSONRequest(ctx, http.MethodPost, "/auth/logout", map[string]string{"token": c.Token})
+	if err != nil {
+		return err
+	}
+	return c.do(request, nil)
+}
+
+func (c *Client) requestTokens(ctx context.Context, path string, body interface{}) (*Tokens, error) {
+	request, err := c.newJSONRequest(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens Tokens
+	if err := c.do(request, &tokens); err != nil {
+		return nil, err
+	}
+	c.Token = tokens.AccessToken
+	return &tokens, nil
+}
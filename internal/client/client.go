@@ -0,0 +1,172 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout  = 30 * time.Second
+	ingestKeyHeader = "X-API-Key"
+)
+
+// Client talks to the REST API of a GoFeather server
+type Client struct {
+	BaseURL string
+	APIKey  string
+	Token   string
+	// FlagsRoute is the feature_flags_route the server is configured with
+	FlagsRoute string
+	HTTP       *http.Client
+	// Headers are sent with every request, like the source metadata the server enriches ingested entries with
+	Headers map[string]string
+}
+
+// LogEntry is a log entry as posted to and returned by the logging routes
+type LogEntry struct {
+	ID          string                 `json:"id,omitempty"`
+	Domain      string                 `json:"domain"`
+	Group       string                 `json:"group"`
+	Tag         string                 `json:"tag"`
+	Level       string                 `json:"level,omitempty"`
+	Log         string                 `json:"log"`
+	Timestamp   int64                  `json:"timestamp,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Exception   json.RawMessage        `json:"exception,omitempty"`
+	RepeatCount int64                  `json:"repeat_count,omitempty"`
+	// EventTime is when the entry was logged in unix milliseconds, the server uses its receive time when it is
+	// empty or outside the allowed clock skew. ReceivedAt and ClockSkew are set by the server.
+	EventTime  int64 `json:"event_time,omitempty"`
+	ReceivedAt int64 `json:"received_at,omitempty"`
+	ClockSkew  int64 `json:"clock_skew,omitempty"`
+}
+
+// APIError is returned when the server responds with an error status
+type APIError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// Retryable tells if the request may succeed when it is sent again later
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTP: &http.Client{Timeout: defaultTimeout}}
+}
+
+// PostLogs sends a batch of entries to the batch ingest route, gzip compressed
+func (c *Client) PostLogs(ctx context.Context, entries []LogEntry) error {
+	var body bytes.Buffer
+	writer := gzip.NewWriter(&body)
+	if err := json.NewEncoder(writer).Encode(entries); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	request, err := c.newRequest(ctx, http.MethodPost, "/log/batch", nil, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Content-Encoding", "gzip")
+	return c.do(request, nil)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return http.NewRequestWithContext(ctx, method, target, body)
+}
+
+func (c *Client) newJSONRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	request, err := c.newRequest(ctx, method, path, nil, bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return request, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, result interface{}) error {
+	request, err := c.newRequest(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(request, result)
+}
+
+// authorize adds the credentials and headers of the client to the request
+func (c *Client) authorize(request *http.Request) {
+	for name, value := range c.Headers {
+		request.Header.Set(name, value)
+	}
+	if c.APIKey != "" {
+		request.Header.Set(ingestKeyHeader, c.APIKey)
+	}
+	if c.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+}
+
+// do sends the request with the credentials of the client and decodes a JSON response into result when given
+func (c *Client) do(request *http.Request, result interface{}) error {
+	c.authorize(request)
+
+	response, err := c.HTTP.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusBadRequest {
+		return apiError(response)
+	}
+	if result == nil {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+func apiError(response *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: response.StatusCode, Message: response.Status}
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	var errorBody struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &errorBody) == nil && errorBody.Error != "" {
+		apiErr.Message = errorBody.Error
+	} else if message := strings.TrimSpace(string(body)); message != "" {
+		apiErr.Message = message
+	}
+
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
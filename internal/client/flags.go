@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const defaultFlagsRoute = "featureflags"
+
+// FeatureFlag is a feature flag as stored by the feature flag routes
+type FeatureFlag struct {
+	Name    string       `json:"name"`
+	Enabled bool         `json:"enabled"`
+	Filters []FlagFilter `json:"filters"`
+}
+
+// FlagFilter is either a RoleFilter, requiring all Roles, or a TimeFilter, limiting the flag to a time window
+type FlagFilter struct {
+	Type      string     `json:"type"`
+	Roles     []string   `json:"roles,omitempty"`
+	TimeStart *time.Time `json:"time_start,omitempty"`
+	TimeStop  *time.Time `json:"time_stop,omitempty"`
+}
+
+type flagResponse struct {
+	Status string          `json:"status"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func (c *Client) flagsPath(path string) string {
+	route := c.FlagsRoute
+	if route == "" {
+		route = defaultFlagsRoute
+	}
+	return "/" + route + path
+}
+
+func (c *Client) ListFlags(ctx context.Context) ([]FeatureFlag, error) {
+	flags := make([]FeatureFlag, 0)
+	err := c.get(ctx, c.flagsPath("/flags"), nil, &flags)
+	return flags, err
+}
+
+func (c *Client) CreateFlag(ctx context.Context, flag FeatureFlag) error {
+	request, err := c.newJSONRequest(ctx, http.MethodPost, c.flagsPath("/flag"), flag)
+	if err != nil {
+		return err
+	}
+	return c.do(request, nil)
+}
+
+// ToggleFlag flips whether a flag is enabled and returns the updated flag
+func (c *Client) ToggleFlag(ctx context.Context, name string) (*FeatureFlag, error) {
+	request, err := c.newRequest(ctx, http.MethodPost, c.flagsPath("/flag/"+url.PathEscape(name)+"/toggle"), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response flagResponse
+	if err := c.do(request, &response); err != nil {
+		return nil, err
+	}
+	var flag FeatureFlag
+	if err := json.Unmarshal(response.Data, &flag); err != nil {
+		return nil, err
+	}
+	return &flag, nil
+}
+
+// EvaluateFlag tells if a flag is on for a user with the given roles
+func (c *Client) EvaluateFlag(ctx context.Context, name string, roles []string) (bool, error) {
+	var response flagResponse
+	if err := c.get(ctx, c.flagsPath("/flag/"+url.PathEscape(name)+"/check"), url.Values{"role": roles}, &response); err != nil {
+		return false, err
+	}
+
+	var result struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.Unmarshal(response.Data, &result); err != nil {
+		return false, err
+	}
+	return result.Enabled, nil
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// LogQuery filters the logs of a domain, empty fields don't filter
+type LogQuery struct {
+	Group         string
+	Tag           string
+	Level         string
+	ExceptionType string
+	Limit         int64
+	// TimeField is the time From, To and the order use, received_at or event_time. From and To are
+	// unix milliseconds or RFC 3339 times and only apply to queries.
+	TimeField string
+	From      string
+	To        string
+}
+
+func (q LogQuery) values() url.Values {
+	values := url.Values{}
+	for key, value := range map[string]string{
+		"group":          q.Group,
+		"tag":            q.Tag,
+		"level":          q.Level,
+		"exception_type": q.ExceptionType,
+		"time_field":     q.TimeField,
+		"from":           q.From,
+		"to":             q.To,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.FormatInt(q.Limit, 10))
+	}
+	return values
+}
+
+// ListDomains returns the names of the domains with logs
+func (c *Client) ListDomains(ctx context.Context) ([]string, error) {
+	var domains []struct {
+		Domain string `json:"domain"`
+	}
+	if err := c.get(ctx, "/domain/list", nil, &domains); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		names = append(names, domain.Domain)
+	}
+	return names, nil
+}
+
+// GetLogs returns the logs of a domain matching the query, newest first by the time field of the query
+func (c *Client) GetLogs(ctx context.Context, domain string, query LogQuery) ([]LogEntry, error) {
+	entries := make([]LogEntry, 0)
+	err := c.get(ctx, "/log/"+url.PathEscape(domain), query.values(), &entries)
+	return entries, err
+}
+
+// DiffQuery selects the windows of a log diff, either Deploy with the Window around it or both windows.
+// Times are unix milliseconds or RFC 3339 and Window is a duration like 1h.
+type DiffQuery struct {
+	LogQuery
+	Deploy       string
+	Window       string
+	BaselineFrom string
+	BaselineTo   string
+	CompareFrom  string
+	CompareTo    string
+	Ratio        float64
+}
+
+// DiffWindow is a window of a log diff with the amount of entries read from it
+type DiffWindow struct {
+	From      int64 `json:"from"`
+	To        int64 `json:"to"`
+	Entries   int   `json:"entries"`
+	Truncated bool  `json:"truncated"`
+}
+
+// TemplateChange is a message template that is new, gone or changed in frequency between the windows of a diff
+type TemplateChange struct {
+	Template      string  `json:"template"`
+	Example       string  `json:"example"`
+	BaselineCount int     `json:"baseline_count"`
+	CompareCount  int     `json:"compare_count"`
+	Expected      float64 `json:"expected"`
+	Ratio         float64 `json:"ratio"`
+	Score         float64 `json:"score"`
+}
+
+// LogDiff lists the templates that are new, gone or changed, each ranked by their score
+type LogDiff struct {
+	Domain   string           `json:"domain"`
+	Baseline DiffWindow       `json:"baseline"`
+	Compare  DiffWindow       `json:"compare"`
+	New      []TemplateChange `json:"new"`
+	Gone     []TemplateChange `json:"gone"`
+	Changed  []TemplateChange `json:"changed"`
+}
+
+// GetLogDiff compares the message templates of a domain between two windows
+func (c *Client) GetLogDiff(ctx context.Context, domain string, query DiffQuery) (*LogDiff, error) {
+	values := query.values()
+	for key, value := range map[string]string{
+		"deploy":        query.Deploy,
+		"window":        query.Window,
+		"baseline_from": query.BaselineFrom,
+		"baseline_to":   query.BaselineTo,
+		"compare_from":  query.CompareFrom,
+		"compare_to":    query.CompareTo,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	if query.Ratio > 0 {
+		values.Set("ratio", strconv.FormatFloat(query.Ratio, 'f', -1, 64))
+	}
+
+	var diff LogDiff
+	if err := c.get(ctx, "/log/"+url.PathEscape(domain)+"/diff", values, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// Tail streams the entries ingested into a domain to handle until the context is cancelled,
+// the server closes the stream or handle returns an error
+func (c *Client) Tail(ctx context.Context, domain string, query LogQuery, handle func(LogEntry) error) error {
+	request, err := c.newRequest(ctx, http.MethodGet, "/log/"+url.PathEscape(domain)+"/tail", query.values(), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Accept", "text/event-stream")
+	c.authorize(request)
+
+	//The stream stays open for as long as the user is tailing, so the timeout of the regular client can't be used
+	streamClient := &http.Client{Transport: c.HTTP.Transport}
+	response, err := streamClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusBadRequest {
+		return apiError(response)
+	}
+
+	var event string
+	var data strings.Builder
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event == "log" {
+				var entry LogEntry
+				if err := json.Unmarshal([]byte(data.String()), &entry); err != nil {
+					return err
+				}
+				if err := handle(entry); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return scanner.Err()
+}
+
+// ImportOptions controls how an export is imported, the format is json or csv
+type ImportOptions struct {
+	Domain         string
+	Format         string
+	KeepDuplicates bool
+	// Gzipped tells the server the body is gzip compressed, like the archive files
+	Gzipped bool
+}
+
+// ImportResult tells how many entries were read from an import and how many of them were stored
+type ImportResult struct {
+	Read       int64 `json:"read"`
+	Imported   int64 `json:"imported"`
+	Duplicates int64 `json:"duplicates"`
+}
+
+// ImportLogs streams an export to the import route, which keeps the original timestamps for a trusted ingest key.
+// The request has no timeout besides the context, since large exports take a while.
+func (c *Client) ImportLogs(ctx context.Context, body io.Reader, options ImportOptions) (*ImportResult, error) {
+	query := url.Values{}
+	if options.Domain != "" {
+		query.Set("domain", options.Domain)
+	}
+	if options.Format != "" {
+		query.Set("format", options.Format)
+	}
+	if options.KeepDuplicates {
+		query.Set("keep_duplicates", "true")
+	}
+
+	request, err := c.newRequest(ctx, http.MethodPost, "/log/import", query, body)
+	if err != nil {
+		return nil, err
+	}
+	if options.Format == "csv" {
+		request.Header.Set("Content-Type", "text/csv")
+	} else {
+		request.Header.Set("Content-Type", "application/x-ndjson")
+	}
+	if options.Gzipped {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
+
+	importer := *c
+	importer.HTTP = &http.Client{Transport: c.HTTP.Transport}
+	var result ImportResult
+	if err := importer.do(request, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
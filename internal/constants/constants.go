@@ -9,6 +9,27 @@ const (
 	LogFeature         = "logging"
 	FeatureFlagFeature = "feature_flags"
 	AuthFeature        = "auth"
+	MetricsFeature     = "metrics"
+	LogMetrics         = "log_metrics"
+	RateLimits         = "rate_limits"
+	Sampling           = "sampling"
+	Extraction         = "extraction"
+	Enrichment         = "enrichment"
+	ClientInfo         = "client_info"
+	Ingest             = "ingest"
+	GrpcFeature        = "grpc"
+	GrpcAddress        = "grpc_address"
+	Listeners          = "listeners"
+	ArchiveFeature     = "archiving"
+	Archive            = "archive"
+	Sinks              = "sinks"
+	AnomalyFeature     = "anomaly_detection"
+	Anomalies          = "anomalies"
+	AnnotationFeature  = "annotations"
+	AccessLogFeature   = "access_logging"
+	AccessLog          = "access_log"
+	TenancyFeature     = "multi_tenancy"
+	Tenancy            = "tenancy"
 	SecretKey          = "secret_key"
 	JWTIssuer          = "jwt-iss"
 )
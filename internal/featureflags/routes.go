@@ -2,12 +2,14 @@ package featureflags
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gookit/config/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"gofeather/internal/utility"
 	"log"
 	"net/http"
@@ -73,13 +75,14 @@ func Init(engine *gin.Engine, db *mongo.Database) {
 	engine.GET("/"+identifier+"/flag/:name", GetFlag)
 	engine.GET("/"+identifier+"/flag/:name/check", CheckFlag)
 	engine.POST("/"+identifier+"/flag", CreateFlag)
+	engine.POST("/"+identifier+"/flag/:name/toggle", ToggleFlag)
 }
 func GetFlags(c *gin.Context) {
 	var results []FeatureFlag
 	coll := database.Collection(CollectionName)
 
 	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
-		cur, findErr := coll.Find(ctx, bson.D{{}})
+		cur, findErr := coll.Find(ctx, bson.D{})
 		if findErr != nil {
 			return findErr
 		}
@@ -99,7 +102,7 @@ func GetFlag(c *gin.Context) {
 
 	coll := database.Collection(CollectionName)
 	flagName := c.Param("name")
-	filter := bson.D{{"name", flagName}}
+	filter := bson.D{{Key: "name", Value: flagName}}
 
 	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
 		findErr := coll.FindOne(ctx, filter).Decode(&retrievedFlag)
@@ -120,12 +123,13 @@ func GetFlag(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "success", "data": retrievedFlag})
 }
 
+// CheckFlag evaluates a flag for the roles given as role query parameters, unknown flags are disabled
 func CheckFlag(c *gin.Context) {
 	var retrievedFlag FeatureFlag
 
 	coll := database.Collection(CollectionName)
 	flagName := c.Param("name")
-	filter := bson.D{{"name", flagName}}
+	filter := bson.D{{Key: "name", Value: flagName}}
 
 	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
 		findErr := coll.FindOne(ctx, filter).Decode(&retrievedFlag)
@@ -143,17 +147,7 @@ func CheckFlag(c *gin.Context) {
 		return
 	}
 
-	var testRoles = make([]string, 0)
-	testRoles = append(testRoles, "yest")
-
-	for i := 0; i < len(retrievedFlag.Filters); i++ {
-		if !retrievedFlag.Filters[i].CanUse(FFUserData{Roles: testRoles}) {
-			c.JSON(http.StatusOK, gin.H{"status": "success", "data": gin.H{"enabled": false}})
-			return
-		}
-	}
-
-	c.JSON(http.StatusOK, gin.H{"enabled": true})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": gin.H{"enabled": retrievedFlag.evaluate(FFUserData{Roles: c.QueryArray("role")})}})
 }
 
 func CreateFlag(c *gin.Context) {
@@ -177,6 +171,46 @@ func CreateFlag(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// ToggleFlag flips whether a flag is enabled and responds with the updated flag
+func ToggleFlag(c *gin.Context) {
+	var toggledFlag FeatureFlag
+
+	coll := database.Collection(CollectionName)
+	filter := bson.D{{Key: "name", Value: c.Param("name")}}
+	//An update pipeline flips the value in a single operation, so concurrent toggles don't overwrite each other
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{{Key: "enabled", Value: bson.D{{Key: "$not", Value: "$enabled"}}}}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		return coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&toggledFlag)
+	})
+
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utility.RespondWithError(c, http.StatusNotFound, "flag not found")
+			return
+		}
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.Printf("Flag %s toggled, enabled: %v\n", toggledFlag.Name, toggledFlag.Enabled)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "data": toggledFlag})
+}
+
+// evaluate tells if the flag is on for the user, a disabled flag is off for everyone
+func (ff *FeatureFlag) evaluate(data FFUserData) bool {
+	if !ff.Enabled {
+		return false
+	}
+	for _, filter := range ff.Filters {
+		if !filter.CanUse(data) {
+			return false
+		}
+	}
+	return true
+}
+
 func (ff *FeatureFlag) UnmarshalBSON(data []byte) error {
 	var raw struct {
 		Name    string          `bson:"name"`
@@ -216,3 +250,44 @@ func (ff *FeatureFlag) UnmarshalBSON(data []byte) error {
 	}
 	return nil
 }
+
+// UnmarshalJSON picks the filter implementation from the type field of each filter, like UnmarshalBSON
+func (ff *FeatureFlag) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Name    string            `json:"name"`
+		Enabled bool              `json:"enabled"`
+		Filters []json.RawMessage `json:"filters"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	ff.Name = raw.Name
+	ff.Enabled = raw.Enabled
+	ff.Filters = make([]FeatureFilter, len(raw.Filters))
+
+	for i, rawFilter := range raw.Filters {
+		var filterType struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(rawFilter, &filterType); err != nil {
+			return err
+		}
+		switch filterType.Type {
+		case "RoleFilter":
+			var filter RoleFilter
+			if err := json.Unmarshal(rawFilter, &filter); err != nil {
+				return err
+			}
+			ff.Filters[i] = filter
+		case "TimeFilter":
+			var filter TimeFilter
+			if err := json.Unmarshal(rawFilter, &filter); err != nil {
+				return err
+			}
+			ff.Filters[i] = filter
+		default:
+			return fmt.Errorf("unknown filter type: %s", filterType.Type)
+		}
+	}
+	return nil
+}
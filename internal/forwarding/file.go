@@ -0,0 +1,99 @@
+package forwarding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gofeather/internal/logging"
+	"gofeather/internal/utility"
+	"os"
+	"path/filepath"
+)
+
+// fileSink appends the entries as NDJSON to a local file, which is rotated to path.1, path.2 and so on
+// once it reaches the maximum size
+type fileSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newFileSink(sinkConfig SinkConfig) (*fileSink, error) {
+	if sinkConfig.Path == "" {
+		return nil, errors.New("a file sink needs a path")
+	}
+	if err := os.MkdirAll(filepath.Dir(sinkConfig.Path), 0o755); err != nil {
+		return nil, err
+	}
+
+	sink := &fileSink{path: sinkConfig.Path, maxSize: sinkConfig.MaxSize, maxFiles: utility.ValueOrDefault(sinkConfig.MaxFiles, 5)}
+	if sink.maxSize <= 0 {
+		sink.maxSize = 100 << 20
+	}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) Send(entries []logging.JsonLog) error {
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrRefused, err)
+		}
+		line = append(line, '\n')
+
+		if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := s.file.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and starts a new file
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	_ = os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxFiles))
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
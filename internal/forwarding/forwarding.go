@@ -0,0 +1,236 @@
+package forwarding
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/logging"
+	"gofeather/internal/metrics"
+	"gofeather/internal/utility"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	TypeWebhook = "webhook"
+	TypeSyslog  = "syslog"
+	TypeFile    = "file"
+	TypeKafka   = "kafka"
+)
+
+// SinkConfig configures a destination the stored entries of a domain are forwarded to.
+// Only the settings of the sink's type are used, durations are written like "5s".
+type SinkConfig struct {
+	Name   string            `mapstructure:"name"`
+	Type   string            `mapstructure:"type"`
+	Domain string            `mapstructure:"domain"`
+	Filter logging.LogFilter `mapstructure:"filter"`
+
+	QueueSize        int    `mapstructure:"queue_size"`
+	BatchSize        int    `mapstructure:"batch_size"`
+	FlushInterval    string `mapstructure:"flush_interval"`
+	RetryInterval    string `mapstructure:"retry_interval"`
+	MaxRetryInterval string `mapstructure:"max_retry_interval"`
+	Timeout          string `mapstructure:"timeout"`
+
+	// webhook
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+	// syslog
+	Network  string `mapstructure:"network"`
+	Address  string `mapstructure:"address"`
+	Facility int    `mapstructure:"facility"`
+	AppName  string `mapstructure:"app_name"`
+	// file
+	Path     string `mapstructure:"path"`
+	MaxSize  int64  `mapstructure:"max_size"`
+	MaxFiles int    `mapstructure:"max_files"`
+	// kafka
+	Brokers []string `mapstructure:"brokers"`
+	Topic   string   `mapstructure:"topic"`
+}
+
+// ErrRefused marks a failure that sending the batch again won't fix, like a webhook responding 400, the batch is dropped
+var ErrRefused = errors.New("refused by the sink")
+
+// Sink delivers a batch of entries to a destination, a batch that fails is sent again later unless the error wraps
+// ErrRefused
+type Sink interface {
+	Send(entries []logging.JsonLog) error
+}
+
+// LoadConfig reads the sinks from the configuration file
+func LoadConfig() []SinkConfig {
+	var sinkConfigs []SinkConfig
+	if config.Exists(constants.Sinks) {
+		if err := config.BindStruct(constants.Sinks, &sinkConfigs); err != nil {
+			log.Printf("Unable to read sinks from config: %v", err)
+		}
+	}
+	return sinkConfigs
+}
+
+// Start creates every configured sink and forwards the entries stored by the pipeline to them,
+// sinks that can't be created are logged and skipped
+func Start(sinkConfigs []SinkConfig, pipeline *logging.Pipeline) {
+	for i, sinkConfig := range sinkConfigs {
+		if sinkConfig.Name == "" {
+			sinkConfig.Name = fmt.Sprintf("%s-%d", sinkConfig.Type, i)
+		}
+		sink, err := newSink(sinkConfig)
+		if err != nil {
+			log.Printf("Unable to start %s sink %s: %v", sinkConfig.Type, sinkConfig.Name, err)
+			continue
+		}
+		pipeline.AddForwarder(NewSinkForwarder(sinkConfig, sink))
+		log.Printf("Forwarding logs to %s sink %s", sinkConfig.Type, sinkConfig.Name)
+	}
+}
+
+func newSink(sinkConfig SinkConfig) (Sink, error) {
+	switch sinkConfig.Type {
+	case TypeWebhook:
+		return newWebhookSink(sinkConfig)
+	case TypeSyslog:
+		return newSyslogSink(sinkConfig)
+	case TypeFile:
+		return newFileSink(sinkConfig)
+	case TypeKafka:
+		return newKafkaSink(sinkConfig)
+	default:
+		return nil, fmt.Errorf("unknown sink type: %s", sinkConfig.Type)
+	}
+}
+
+// forwardMetrics are shared by all sinks, labelled with the sink name
+type forwardMetrics struct {
+	sent    *metrics.Counter
+	dropped *metrics.Counter
+	retries *metrics.Counter
+	depth   *metrics.Gauge
+}
+
+var (
+	sharedMetrics     *forwardMetrics
+	sharedMetricsOnce sync.Once
+)
+
+func loadForwardMetrics() *forwardMetrics {
+	sharedMetricsOnce.Do(func() {
+		sharedMetrics = &forwardMetrics{}
+		var err error
+		if sharedMetrics.sent, err = metrics.DefaultRegistry.NewCounter("gofeather_forwarded_entries_total",
+			"Log entries delivered to a sink", "sink"); err != nil {
+			log.Printf("Unable to register forwarding metric: %v", err)
+		}
+		if sharedMetrics.dropped, err = metrics.DefaultRegistry.NewCounter("gofeather_forward_dropped_entries_total",
+			"Log entries not forwarded because the queue of the sink was full or the sink refused them", "sink"); err != nil {
+			log.Printf("Unable to register forwarding metric: %v", err)
+		}
+		if sharedMetrics.retries, err = metrics.DefaultRegistry.NewCounter("gofeather_forward_retries_total",
+			"Failed deliveries to a sink that were retried", "sink"); err != nil {
+			log.Printf("Unable to register forwarding metric: %v", err)
+		}
+		if sharedMetrics.depth, err = metrics.DefaultRegistry.NewGauge("gofeather_forward_queue_depth",
+			"Log entries waiting to be forwarded to a sink", "sink"); err != nil {
+			log.Printf("Unable to register forwarding metric: %v", err)
+		}
+	})
+	return sharedMetrics
+}
+
+// SinkForwarder queues the entries for a single sink and delivers them in batches. A failing batch is retried with
+// a growing interval until it succeeds, meanwhile new entries wait in the queue and are dropped once it is full,
+// so a failing sink never slows down ingest or the other sinks. A batch the sink refuses is dropped right away.
+type SinkForwarder struct {
+	config           SinkConfig
+	sink             Sink
+	queue            chan logging.JsonLog
+	batchSize        int
+	flushInterval    time.Duration
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	metrics          *forwardMetrics
+}
+
+func NewSinkForwarder(sinkConfig SinkConfig, sink Sink) *SinkForwarder {
+	forwarder := &SinkForwarder{
+		config:           sinkConfig,
+		sink:             sink,
+		queue:            make(chan logging.JsonLog, utility.ValueOrDefault(sinkConfig.QueueSize, 10000)),
+		batchSize:        utility.ValueOrDefault(sinkConfig.BatchSize, 100),
+		flushInterval:    utility.DurationOrDefault(sinkConfig.FlushInterval, time.Second),
+		retryInterval:    utility.DurationOrDefault(sinkConfig.RetryInterval, time.Second),
+		maxRetryInterval: utility.DurationOrDefault(sinkConfig.MaxRetryInterval, time.Minute),
+		metrics:          loadForwardMetrics(),
+	}
+	go forwarder.work()
+	return forwarder
+}
+
+// Forward queues the entries of the sink's domain that pass its filter, without blocking
+func (f *SinkForwarder) Forward(entries []logging.JsonLog) {
+	for _, entry := range entries {
+		if f.config.Domain != "" && entry.Domain != f.config.Domain {
+			continue
+		}
+		if !f.config.Filter.Matches(entry) {
+			continue
+		}
+		select {
+		case f.queue <- entry:
+		default:
+			f.metrics.dropped.Inc(f.config.Name)
+		}
+	}
+	f.metrics.depth.Set(float64(len(f.queue)), f.config.Name)
+}
+
+// work collects entries from the queue into batches, delivering them when full or when the flush interval passed
+func (f *SinkForwarder) work() {
+	ticker := time.NewTicker(f.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]logging.JsonLog, 0, f.batchSize)
+	for {
+		select {
+		case entry := <-f.queue:
+			batch = append(batch, entry)
+			if len(batch) < f.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		f.deliver(batch)
+		batch = make([]logging.JsonLog, 0, f.batchSize)
+	}
+}
+
+// deliver sends the batch, retrying until the sink accepts or refuses it
+func (f *SinkForwarder) deliver(batch []logging.JsonLog) {
+	wait := f.retryInterval
+	for {
+		err := f.sink.Send(batch)
+		if err == nil {
+			f.metrics.sent.Add(float64(len(batch)), f.config.Name)
+			f.metrics.depth.Set(float64(len(f.queue)), f.config.Name)
+			return
+		}
+		if errors.Is(err, ErrRefused) {
+			log.Printf("Dropping %d log entries refused by sink %s: %v", len(batch), f.config.Name, err)
+			f.metrics.dropped.Add(float64(len(batch)), f.config.Name)
+			f.metrics.depth.Set(float64(len(f.queue)), f.config.Name)
+			return
+		}
+
+		log.Printf("Failed to forward %d log entries to sink %s, retrying in %s: %v", len(batch), f.config.Name, wait, err)
+		f.metrics.retries.Inc(f.config.Name)
+		time.Sleep(wait)
+		wait = min(wait*2, f.maxRetryInterval)
+	}
+}
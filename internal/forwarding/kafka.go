@@ -0,0 +1,86 @@
+package forwarding
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"gofeather/internal/logging"
+	"gofeather/internal/utility"
+	"slices"
+	"time"
+)
+
+// refusedMessageErrors are the errors of messages kafka will never accept
+var refusedMessageErrors = []kafka.Error{kafka.InvalidMessage, kafka.InvalidMessageSize, kafka.MessageSizeTooLarge,
+	kafka.RecordListTooLarge, kafka.InvalidRecord}
+
+// kafkaSink produces every entry as a JSON message to a topic, keyed by domain so a domain keeps its order
+type kafkaSink struct {
+	writer  *kafka.Writer
+	timeout time.Duration
+}
+
+func newKafkaSink(sinkConfig SinkConfig) (*kafkaSink, error) {
+	if len(sinkConfig.Brokers) == 0 || sinkConfig.Topic == "" {
+		return nil, errors.New("a kafka sink needs brokers and a topic")
+	}
+
+	return &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(sinkConfig.Brokers...),
+			Topic:                  sinkConfig.Topic,
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			AllowAutoTopicCreation: true,
+			BatchSize:              utility.ValueOrDefault(sinkConfig.BatchSize, 100),
+			BatchTimeout:           10 * time.Millisecond,
+			//Failed batches are retried by the forwarder, which keeps the entries in order
+			MaxAttempts: 1,
+		},
+		timeout: utility.DurationOrDefault(sinkConfig.Timeout, 10*time.Second),
+	}, nil
+}
+
+func (s *kafkaSink) Send(entries []logging.JsonLog) error {
+	messages := make([]kafka.Message, 0, len(entries))
+	for _, entry := range entries {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrRefused, err)
+		}
+		messages = append(messages, kafka.Message{Key: []byte(entry.Domain), Value: value})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	err := s.writer.WriteMessages(ctx, messages...)
+	if refusedMessage(err) {
+		//Sending the batch again would fail on the same message forever
+		return fmt.Errorf("%w: %v", ErrRefused, err)
+	}
+	return err
+}
+
+// refusedMessage tells whether a message of the batch is one kafka will never accept, like one that is too large
+func refusedMessage(err error) bool {
+	if err == nil {
+		return false
+	}
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, writeErr := range writeErrs {
+			if writeErr != nil && refusedMessage(writeErr) {
+				return true
+			}
+		}
+		return false
+	}
+	var tooLarge kafka.MessageTooLargeError
+	if errors.As(err, &tooLarge) {
+		return true
+	}
+	var kafkaErr kafka.Error
+	return errors.As(err, &kafkaErr) && slices.Contains(refusedMessageErrors, kafkaErr)
+}
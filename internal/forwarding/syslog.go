@@ -0,0 +1,119 @@
+package forwarding
+
+import (
+	"errors"
+	"fmt"
+	"gofeather/internal/logging"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	syslogUserFacility = 1
+	syslogInfo         = 6
+)
+
+// syslogSeverities maps the log levels to syslog severities, other levels are sent as informational
+var syslogSeverities = map[string]int{
+	"emergency": 0,
+	"panic":     0,
+	"alert":     1,
+	"fatal":     2,
+	"critical":  2,
+	"error":     3,
+	"warn":      4,
+	"warning":   4,
+	"notice":    5,
+	"info":      6,
+	"debug":     7,
+	"trace":     7,
+}
+
+// syslogSink sends every entry as an RFC 5424 message, one datagram per message over UDP and
+// octet-counted frames (RFC 6587) over TCP
+type syslogSink struct {
+	network  string
+	address  string
+	facility int
+	appName  string
+	hostname string
+	conn     net.Conn
+}
+
+func newSyslogSink(sinkConfig SinkConfig) (*syslogSink, error) {
+	if sinkConfig.Address == "" {
+		return nil, errors.New("a syslog sink needs an address")
+	}
+	network := strings.ToLower(sinkConfig.Network)
+	if network == "" {
+		network = "udp"
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, fmt.Errorf("unknown syslog network: %s", sinkConfig.Network)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	sink := &syslogSink{
+		network:  network,
+		address:  sinkConfig.Address,
+		facility: sinkConfig.Facility,
+		appName:  sinkConfig.AppName,
+		hostname: hostname,
+	}
+	if sink.facility <= 0 || sink.facility > 23 {
+		sink.facility = syslogUserFacility
+	}
+	if sink.appName == "" {
+		sink.appName = "gofeather"
+	}
+	return sink, nil
+}
+
+func (s *syslogSink) Send(entries []logging.JsonLog) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	for _, entry := range entries {
+		message := s.format(entry)
+		if s.network == "tcp" {
+			message = fmt.Sprintf("%d %s", len(message), message)
+		}
+		_ = s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := s.conn.Write([]byte(message)); err != nil {
+			//The connection is opened again on the next attempt, which resends the whole batch
+			_ = s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// format builds an RFC 5424 message, the domain, group and tag are passed as structured data
+func (s *syslogSink) format(entry logging.JsonLog) string {
+	severity, exists := syslogSeverities[strings.ToLower(entry.Level)]
+	if !exists {
+		severity = syslogInfo
+	}
+	timestamp := time.UnixMilli(entry.Timestamp).UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	structuredData := fmt.Sprintf(`[gofeather domain="%s" group="%s" tag="%s"]`,
+		escapeParam(entry.Domain), escapeParam(entry.Group), escapeParam(entry.Tag))
+
+	return fmt.Sprintf("<%d>1 %s %s %s - - %s %s",
+		s.facility*8+severity, timestamp, s.hostname, s.appName, structuredData, entry.Log)
+}
+
+// escapeParam escapes the characters RFC 5424 doesn't allow in a structured data value
+func escapeParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
@@ -0,0 +1,64 @@
+package forwarding
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gofeather/internal/logging"
+	"gofeather/internal/utility"
+	"io"
+	"net/http"
+	"time"
+)
+
+// webhookSink posts every batch as a JSON array to a URL, any 2xx response accepts the batch
+type webhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newWebhookSink(sinkConfig SinkConfig) (*webhookSink, error) {
+	if sinkConfig.URL == "" {
+		return nil, errors.New("a webhook sink needs a url")
+	}
+	return &webhookSink{
+		url:     sinkConfig.URL,
+		headers: sinkConfig.Headers,
+		client:  &http.Client{Timeout: utility.DurationOrDefault(sinkConfig.Timeout, 10*time.Second)},
+	}, nil
+}
+
+func (s *webhookSink) Send(entries []logging.JsonLog) error {
+	body, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRefused, err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range s.headers {
+		request.Header.Set(name, value)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return nil
+	}
+	//Client errors won't go away by sending the batch again, except for a timeout or too many requests
+	if response.StatusCode >= 400 && response.StatusCode <= 499 &&
+		response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: webhook responded with %s", ErrRefused, response.Status)
+	}
+	return fmt.Errorf("webhook responded with %s", response.Status)
+}
@@ -0,0 +1,176 @@
+package listeners
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"gofeather/internal/logging"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+	gelfChunkTimeout    = 5 * time.Second
+)
+
+var (
+	gelfChunkMagic = []byte{0x1e, 0x0f}
+	gzipMagic      = []byte{0x1f, 0x8b}
+
+	// gelfLevels maps the syslog severities used by GELF to log levels
+	gelfLevels = []string{"fatal", "fatal", "critical", "error", "warning", "notice", "info", "debug"}
+)
+
+type gelfMessage struct {
+	Version      string      `json:"version"`
+	Host         string      `json:"host"`
+	ShortMessage string      `json:"short_message"`
+	FullMessage  string      `json:"full_message"`
+	Timestamp    float64     `json:"timestamp"`
+	Level        *int        `json:"level"`
+	Facility     interface{} `json:"facility"`
+}
+
+// parseGELF normalizes a GELF message, the additional fields are stored without their underscore prefix.
+// The _domain, _group and _tag fields override the defaults of the listener.
+func (c ListenerConfig) parseGELF(data []byte) (*logging.JsonLog, error) {
+	var message gelfMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	if message.ShortMessage == "" && message.FullMessage == "" {
+		return nil, errors.New("GELF message without short_message")
+	}
+
+	entry := logging.JsonLog{Log: message.ShortMessage, Fields: make(map[string]interface{})}
+	if message.FullMessage != "" {
+		entry.Log = message.FullMessage
+	}
+	if message.Level != nil && *message.Level >= 0 && *message.Level < len(gelfLevels) {
+		entry.Level = gelfLevels[*message.Level]
+	}
+	if message.Host != "" {
+		entry.Fields["host"] = message.Host
+	}
+	if message.Timestamp > 0 {
+		//GELF timestamps are in seconds with an optional fraction
+		entry.EventTime = int64(message.Timestamp * 1000)
+	}
+
+	for key, value := range raw {
+		if !strings.HasPrefix(key, "_") || key == "_id" {
+			continue
+		}
+		name := strings.TrimPrefix(key, "_")
+		text, isString := value.(string)
+		switch {
+		case name == "domain" && isString:
+			entry.Domain = text
+		case name == "group" && isString:
+			entry.Group = text
+		case name == "tag" && isString:
+			entry.Tag = text
+		default:
+			entry.Fields[name] = value
+		}
+	}
+
+	c.applyDefaults(&entry)
+	return &entry, nil
+}
+
+// decompress unpacks gzip and zlib compressed GELF payloads, uncompressed payloads are returned as is
+func decompress(payload []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(payload, gzipMagic):
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(io.LimitReader(reader, maxLineSize))
+	case len(payload) > 1 && payload[0] == 0x78:
+		reader, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(io.LimitReader(reader, maxLineSize))
+	}
+	return payload, nil
+}
+
+type pendingMessage struct {
+	chunks   [][]byte
+	received int
+	started  time.Time
+}
+
+// chunkAssembler reassembles chunked GELF UDP messages, incomplete messages are dropped after a timeout
+type chunkAssembler struct {
+	mu      sync.Mutex
+	pending map[string]*pendingMessage
+	now     func() time.Time
+}
+
+func newChunkAssembler() *chunkAssembler {
+	return &chunkAssembler{pending: make(map[string]*pendingMessage), now: time.Now}
+}
+
+// add returns the complete message once all of its chunks arrived, datagrams that aren't chunked are complete on their own
+func (a *chunkAssembler) add(datagram []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(datagram, gelfChunkMagic) {
+		return datagram, true
+	}
+	if len(datagram) < gelfChunkHeaderSize {
+		return nil, false
+	}
+
+	id := string(datagram[2:10])
+	sequence := int(datagram[10])
+	count := int(datagram[11])
+	if count == 0 || count > gelfMaxChunks || sequence >= count {
+		return nil, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	a.expire(now)
+
+	message, exists := a.pending[id]
+	if !exists {
+		message = &pendingMessage{chunks: make([][]byte, count), started: now}
+		a.pending[id] = message
+	}
+	if len(message.chunks) != count || message.chunks[sequence] != nil {
+		return nil, false
+	}
+	message.chunks[sequence] = datagram[gelfChunkHeaderSize:]
+	message.received++
+	if message.received < count {
+		return nil, false
+	}
+
+	delete(a.pending, id)
+	return bytes.Join(message.chunks, nil), true
+}
+
+func (a *chunkAssembler) expire(now time.Time) {
+	for id, message := range a.pending {
+		if now.Sub(message.started) > gelfChunkTimeout {
+			delete(a.pending, id)
+		}
+	}
+}
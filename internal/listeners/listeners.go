@@ -0,0 +1,232 @@
+package listeners
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/logging"
+	"log"
+	"net"
+	"strings"
+)
+
+const (
+	TypeLine = "line"
+	TypeGELF = "gelf"
+
+	FormatJSON = "json"
+	FormatText = "text"
+
+	maxDatagramSize = 65536
+	maxLineSize     = 1 << 20
+)
+
+// ListenerConfig configures a socket that receives logs, the domain, group and tag are
+// used for entries that don't specify their own. The ingest key applies rate limits and tenancy to the received entries.
+type ListenerConfig struct {
+	Type     string `mapstructure:"type"`
+	Protocol string `mapstructure:"protocol"`
+	Address  string `mapstructure:"address"`
+	Format   string `mapstructure:"format"`
+	Domain   string `mapstructure:"domain"`
+	Group    string `mapstructure:"group"`
+	Tag      string `mapstructure:"tag"`
+	APIKey   string `mapstructure:"api_key"`
+}
+
+// LoadConfig reads the listeners from the configuration file
+func LoadConfig() []ListenerConfig {
+	var listenerConfigs []ListenerConfig
+	if config.Exists(constants.Listeners) {
+		if err := config.BindStruct(constants.Listeners, &listenerConfigs); err != nil {
+			log.Printf("Unable to read listeners from config: %v", err)
+		}
+	}
+	return listenerConfigs
+}
+
+// Start opens every configured listener in the background, listeners that fail to open are logged and skipped
+func Start(listenerConfigs []ListenerConfig, pipeline *logging.Pipeline) {
+	for _, listenerConfig := range listenerConfigs {
+		if err := start(listenerConfig, pipeline); err != nil {
+			log.Printf("Unable to start %s %s listener on %s: %v", listenerConfig.Type, listenerConfig.Protocol,
+				listenerConfig.Address, err)
+			continue
+		}
+		log.Printf("Listening for %s logs over %s on %s", listenerConfig.Type, listenerConfig.Protocol, listenerConfig.Address)
+	}
+}
+
+func start(listenerConfig ListenerConfig, pipeline *logging.Pipeline) error {
+	var parse func(data []byte) (*logging.JsonLog, error)
+	switch listenerConfig.Type {
+	case TypeLine, "":
+		switch listenerConfig.Format {
+		case FormatJSON, "":
+			parse = listenerConfig.parseJSONLine
+		case FormatText:
+			parse = listenerConfig.parseTextLine
+		default:
+			return fmt.Errorf("unknown line format: %s", listenerConfig.Format)
+		}
+	case TypeGELF:
+		parse = listenerConfig.parseGELF
+	default:
+		return fmt.Errorf("unknown listener type: %s", listenerConfig.Type)
+	}
+
+	receiver := &receiver{config: listenerConfig, pipeline: pipeline, parse: parse}
+	switch listenerConfig.Protocol {
+	case "tcp":
+		listener, err := net.Listen("tcp", listenerConfig.Address)
+		if err != nil {
+			return err
+		}
+		go receiver.acceptTCP(listener)
+	case "udp":
+		conn, err := net.ListenPacket("udp", listenerConfig.Address)
+		if err != nil {
+			return err
+		}
+		go receiver.readUDP(conn)
+	default:
+		return fmt.Errorf("unknown protocol: %s", listenerConfig.Protocol)
+	}
+	return nil
+}
+
+// receiver turns the messages of a listener into log entries and passes them to the ingest pipeline
+type receiver struct {
+	config   ListenerConfig
+	pipeline *logging.Pipeline
+	parse    func(data []byte) (*logging.JsonLog, error)
+}
+
+func (r *receiver) acceptTCP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Stopped accepting connections on %s: %v", r.config.Address, err)
+			return
+		}
+		go r.readTCP(conn)
+	}
+}
+
+// readTCP reads newline delimited messages, or null byte delimited ones for GELF, until the connection closes
+func (r *receiver) readTCP(conn net.Conn) {
+	defer conn.Close()
+
+	delimiter := byte('\n')
+	if r.config.Type == TypeGELF {
+		delimiter = 0
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	scanner.Split(splitOn(delimiter))
+	for scanner.Scan() {
+		r.receive(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Error reading logs from %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+func (r *receiver) readUDP(conn net.PacketConn) {
+	defer conn.Close()
+
+	var assembler *chunkAssembler
+	if r.config.Type == TypeGELF {
+		assembler = newChunkAssembler()
+	}
+
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			log.Printf("Stopped reading logs on %s: %v", r.config.Address, err)
+			return
+		}
+		datagram := append([]byte(nil), buffer[:n]...)
+
+		if assembler != nil {
+			message, complete := assembler.add(datagram)
+			if !complete {
+				continue
+			}
+			payload, err := decompress(message)
+			if err != nil {
+				log.Printf("Dropping GELF message on %s: %v", r.config.Address, err)
+				continue
+			}
+			r.receive(payload)
+			continue
+		}
+
+		for _, line := range bytes.Split(datagram, []byte("\n")) {
+			r.receive(line)
+		}
+	}
+}
+
+func (r *receiver) receive(data []byte) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return
+	}
+
+	entry, err := r.parse(data)
+	if err != nil {
+		log.Printf("Dropping invalid message on %s: %v", r.config.Address, err)
+		return
+	}
+	if _, err := r.pipeline.Ingest(r.config.APIKey, []logging.JsonLog{*entry}); err != nil {
+		log.Printf("Dropping log received on %s: %v", r.config.Address, err)
+	}
+}
+
+func (c ListenerConfig) parseJSONLine(data []byte) (*logging.JsonLog, error) {
+	var entry logging.JsonLog
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	c.applyDefaults(&entry)
+	return &entry, nil
+}
+
+func (c ListenerConfig) parseTextLine(data []byte) (*logging.JsonLog, error) {
+	entry := logging.JsonLog{Log: strings.TrimRight(string(data), "\r")}
+	c.applyDefaults(&entry)
+	return &entry, nil
+}
+
+func (c ListenerConfig) applyDefaults(entry *logging.JsonLog) {
+	if entry.Domain == "" {
+		entry.Domain = c.Domain
+	}
+	if entry.Group == "" {
+		entry.Group = c.Group
+	}
+	if entry.Tag == "" {
+		entry.Tag = c.Tag
+	}
+}
+
+// splitOn returns a split function for a scanner that splits on the delimiter, keeping a trailing message without one
+func splitOn(delimiter byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data, delimiter); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
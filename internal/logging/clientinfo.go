@@ -0,0 +1,185 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/gookit/config/v2"
+	"github.com/mssola/useragent"
+	"github.com/oschwald/geoip2-golang"
+	"gofeather/internal/constants"
+	"log"
+	"net"
+	"slices"
+	"strings"
+)
+
+const (
+	defaultIPField        = "client_ip"
+	defaultUserAgentField = "user_agent"
+	// geoNameLanguage is the language of the country and city names read from the database
+	geoNameLanguage = "en"
+
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+	DeviceOther   = "other"
+)
+
+// ClientInfoConfig turns the client IP and user agent fields of entries into usable fields. The city database is
+// a MaxMind-format City or Country database and the ASN database a MaxMind-format ASN database, either may be left
+// out. Domains limits the processor to those domains, without domains every entry with the fields is processed.
+type ClientInfoConfig struct {
+	Domains        []string `mapstructure:"domains"`
+	IPField        string   `mapstructure:"ip_field"`
+	CityDatabase   string   `mapstructure:"city_database"`
+	ASNDatabase    string   `mapstructure:"asn_database"`
+	UserAgentField string   `mapstructure:"user_agent_field"`
+	ParseUserAgent bool     `mapstructure:"parse_user_agent"`
+}
+
+// ClientInfo adds the location and network of the client IP and the browser, OS and device of the user agent
+// to the fields of entries
+type ClientInfo struct {
+	domains        []string
+	ipField        string
+	userAgentField string
+	parseUserAgent bool
+	city           *geoip2.Reader
+	asn            *geoip2.Reader
+}
+
+// NewClientInfo opens the databases of the processor, it returns nil when there is nothing to do.
+// A database that can't be opened is logged and left out.
+func NewClientInfo(clientInfoConfig ClientInfoConfig) *ClientInfo {
+	clientInfo := &ClientInfo{
+		domains:        clientInfoConfig.Domains,
+		ipField:        clientInfoConfig.IPField,
+		userAgentField: clientInfoConfig.UserAgentField,
+		parseUserAgent: clientInfoConfig.ParseUserAgent,
+	}
+	if clientInfo.ipField == "" {
+		clientInfo.ipField = defaultIPField
+	}
+	if clientInfo.userAgentField == "" {
+		clientInfo.userAgentField = defaultUserAgentField
+	}
+
+	var err error
+	if clientInfoConfig.CityDatabase != "" {
+		if clientInfo.city, err = geoip2.Open(clientInfoConfig.CityDatabase); err != nil {
+			log.Printf("Unable to open the GeoIP city database %s: %v", clientInfoConfig.CityDatabase, err)
+		}
+	}
+	if clientInfoConfig.ASNDatabase != "" {
+		if clientInfo.asn, err = geoip2.Open(clientInfoConfig.ASNDatabase); err != nil {
+			log.Printf("Unable to open the GeoIP ASN database %s: %v", clientInfoConfig.ASNDatabase, err)
+		}
+	}
+
+	if clientInfo.city == nil && clientInfo.asn == nil && !clientInfo.parseUserAgent {
+		return nil
+	}
+	return clientInfo
+}
+
+// loadClientInfoConfig reads the client info processor from the configuration file
+func loadClientInfoConfig() ClientInfoConfig {
+	var clientInfoConfig ClientInfoConfig
+	if config.Exists(constants.ClientInfo) {
+		if err := config.BindStruct(constants.ClientInfo, &clientInfoConfig); err != nil {
+			log.Printf("Unable to read client info from config: %v", err)
+		}
+	}
+	return clientInfoConfig
+}
+
+// process adds the client info to the entry, fields the entry already has are kept
+func (c *ClientInfo) process(entry *JsonLog) {
+	if c == nil || entry.Fields == nil || len(c.domains) > 0 && !slices.Contains(c.domains, entry.Domain) {
+		return
+	}
+
+	added := make(map[string]interface{})
+	if value, exists := entry.Fields[c.ipField]; exists && (c.city != nil || c.asn != nil) {
+		if ip := parseClientIP(fmt.Sprint(value)); ip != nil {
+			c.locate(ip, added)
+		}
+	}
+	if value, exists := entry.Fields[c.userAgentField]; exists && c.parseUserAgent {
+		describeUserAgent(strings.Trim(fmt.Sprint(value), `"`), added)
+	}
+
+	for field, value := range added {
+		if _, exists := entry.Fields[field]; !exists {
+			entry.Fields[field] = value
+		}
+	}
+}
+
+// locate looks the IP up in the databases, private and unknown addresses add nothing
+func (c *ClientInfo) locate(ip net.IP, fields map[string]interface{}) {
+	if c.city != nil {
+		if city, err := c.city.City(ip); err == nil {
+			setNonEmpty(fields, "geo_country", city.Country.IsoCode)
+			setNonEmpty(fields, "geo_country_name", city.Country.Names[geoNameLanguage])
+			setNonEmpty(fields, "geo_city", city.City.Names[geoNameLanguage])
+			if city.Location.Latitude != 0 || city.Location.Longitude != 0 {
+				fields["geo_latitude"] = city.Location.Latitude
+				fields["geo_longitude"] = city.Location.Longitude
+			}
+		}
+	}
+	if c.asn != nil {
+		if asn, err := c.asn.ASN(ip); err == nil && asn.AutonomousSystemNumber != 0 {
+			fields["geo_asn"] = int64(asn.AutonomousSystemNumber)
+			setNonEmpty(fields, "geo_as_org", asn.AutonomousSystemOrganization)
+		}
+	}
+}
+
+// describeUserAgent adds the browser, OS and device of a user agent
+func describeUserAgent(value string, fields map[string]interface{}) {
+	if value == "" || value == "-" {
+		return
+	}
+	agent := useragent.New(value)
+	browser, version := agent.Browser()
+	setNonEmpty(fields, "ua_browser", browser)
+	setNonEmpty(fields, "ua_browser_version", version)
+	osInfo := agent.OSInfo()
+	setNonEmpty(fields, "ua_os", osInfo.Name)
+	setNonEmpty(fields, "ua_os_version", osInfo.Version)
+	setNonEmpty(fields, "ua_device_model", agent.Model())
+
+	switch {
+	case agent.Bot():
+		fields["ua_device"] = DeviceBot
+	case strings.Contains(value, "iPad") || strings.Contains(value, "Tablet") ||
+		osInfo.Name == "Android" && !strings.Contains(value, "Mobile"):
+		fields["ua_device"] = DeviceTablet
+	case agent.Mobile():
+		fields["ua_device"] = DeviceMobile
+	case osInfo.Name == "":
+		//Tools like curl name no OS
+		fields["ua_device"] = DeviceOther
+	default:
+		fields["ua_device"] = DeviceDesktop
+	}
+}
+
+// parseClientIP reads the client from an address that may hold a port or be a forwarded-for list, the first
+// address of which is the client
+func parseClientIP(value string) net.IP {
+	value, _, _ = strings.Cut(value, ",")
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	return net.ParseIP(strings.Trim(value, "[]"))
+}
+
+func setNonEmpty(fields map[string]interface{}, field string, value string) {
+	if value != "" {
+		fields[field] = value
+	}
+}
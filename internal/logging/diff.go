@@ -0,0 +1,245 @@
+package logging
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gofeather/internal/templates"
+	"gofeather/internal/utility"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxDiffEntries bounds the entries read per window, the newest entries of a window are used when it holds more
+	maxDiffEntries   = 100000
+	defaultDiffLimit = 50
+	maxDiffLimit     = 1000
+	// defaultDiffRatio is how much the frequency of a template has to change to be listed as changed
+	defaultDiffRatio  = 2.0
+	defaultDiffWindow = time.Hour
+)
+
+// DiffWindow is a time range of a diff with the amount of entries read from it
+type DiffWindow struct {
+	From    int64 `json:"from"`
+	To      int64 `json:"to"`
+	Entries int   `json:"entries"`
+	// Truncated is set when the window held more entries than are read, From is then moved up to the oldest entry read
+	Truncated bool `json:"truncated"`
+}
+
+// TemplateChange is a message template of which the frequency differs between the windows of a diff.
+// Expected is the baseline count scaled to the length of the compare window and Score ranks the change,
+// it is the difference between the count and the expected count in standard deviations.
+type TemplateChange struct {
+	Template      string  `json:"template"`
+	Example       string  `json:"example"`
+	BaselineCount int     `json:"baseline_count"`
+	CompareCount  int     `json:"compare_count"`
+	Expected      float64 `json:"expected"`
+	Ratio         float64 `json:"ratio"`
+	Score         float64 `json:"score"`
+}
+
+// LogDiff lists the message templates that are new in the compare window, gone from it or changed in frequency,
+// each ranked by their score
+type LogDiff struct {
+	Domain   string           `json:"domain"`
+	Baseline DiffWindow       `json:"baseline"`
+	Compare  DiffWindow       `json:"compare"`
+	New      []TemplateChange `json:"new"`
+	Gone     []TemplateChange `json:"gone"`
+	Changed  []TemplateChange `json:"changed"`
+}
+
+// templateCount counts the messages of a template in a window, keeping the first one as an example
+type templateCount struct {
+	count   int
+	example string
+}
+
+// countTemplates clusters the messages of the entries into templates, an entry collapsing duplicates counts as
+// its repeat count
+func countTemplates(entries []JsonLog) map[string]*templateCount {
+	counts := make(map[string]*templateCount)
+	for _, entry := range entries {
+		template := templates.Of(entry.Log)
+		if template == "" {
+			continue
+		}
+		repeats := max(int(entry.RepeatCount), 1)
+		if counted, exists := counts[template]; exists {
+			counted.count += repeats
+		} else {
+			counts[template] = &templateCount{count: repeats, example: entry.Log}
+		}
+	}
+	return counts
+}
+
+// diffTemplates compares the template counts of the windows, the baseline counts are multiplied by the scale,
+// the length of the compare window over that of the baseline window, so windows of different lengths can be compared
+func diffTemplates(baseline map[string]*templateCount, compare map[string]*templateCount, scale float64, minRatio float64) ([]TemplateChange, []TemplateChange, []TemplateChange) {
+	newTemplates := make([]TemplateChange, 0)
+	gone := make([]TemplateChange, 0)
+	changed := make([]TemplateChange, 0)
+
+	for template, counted := range compare {
+		change := TemplateChange{Template: template, Example: counted.example, CompareCount: counted.count}
+		before, existed := baseline[template]
+		if existed {
+			change.BaselineCount = before.count
+		}
+		change.Expected = float64(change.BaselineCount) * scale
+		//A Poisson count varies by the square root of its mean, the one keeps templates that were never seen comparable
+		change.Score = (float64(change.CompareCount) - change.Expected) / math.Sqrt(change.Expected+1)
+
+		if !existed {
+			newTemplates = append(newTemplates, change)
+			continue
+		}
+		change.Ratio = float64(change.CompareCount) / change.Expected
+		if change.Ratio >= minRatio || change.Ratio <= 1/minRatio {
+			changed = append(changed, change)
+		}
+	}
+
+	for template, counted := range baseline {
+		if _, exists := compare[template]; exists {
+			continue
+		}
+		change := TemplateChange{Template: template, Example: counted.example, BaselineCount: counted.count}
+		change.Expected = float64(change.BaselineCount) * scale
+		change.Score = -change.Expected / math.Sqrt(change.Expected+1)
+		gone = append(gone, change)
+	}
+
+	for _, changes := range [][]TemplateChange{newTemplates, gone, changed} {
+		sort.Slice(changes, func(i, j int) bool {
+			if math.Abs(changes[i].Score) != math.Abs(changes[j].Score) {
+				return math.Abs(changes[i].Score) > math.Abs(changes[j].Score)
+			}
+			return changes[i].Template < changes[j].Template
+		})
+	}
+	return newTemplates, gone, changed
+}
+
+// diffWindows reads the windows of a diff from the query, either both windows or a deploy time with the length of
+// the windows before and after it
+func diffWindows(c *gin.Context) (DiffWindow, DiffWindow, error) {
+	var baseline, compare DiffWindow
+	if deploy := c.Query("deploy"); deploy != "" {
+		at, err := utility.ParseTime(deploy, 0)
+		if err != nil {
+			return baseline, compare, errors.New("deploy " + err.Error())
+		}
+		window := defaultDiffWindow
+		if value := c.Query("window"); value != "" {
+			if window, err = time.ParseDuration(value); err != nil || window <= 0 {
+				return baseline, compare, errors.New("window must be a positive duration like 1h")
+			}
+		}
+		baseline = DiffWindow{From: at - window.Milliseconds(), To: at - 1}
+		compare = DiffWindow{From: at, To: at + window.Milliseconds() - 1}
+		return baseline, compare, nil
+	}
+
+	times := make(map[string]int64, 4)
+	for _, name := range []string{"baseline_from", "baseline_to", "compare_from", "compare_to"} {
+		value, err := utility.ParseTime(c.Query(name), 0)
+		if err != nil {
+			return baseline, compare, errors.New(name + " " + err.Error())
+		}
+		if value == 0 {
+			return baseline, compare, errors.New("either deploy or baseline_from, baseline_to, compare_from and compare_to are required")
+		}
+		times[name] = value
+	}
+	baseline = DiffWindow{From: times["baseline_from"], To: times["baseline_to"]}
+	compare = DiffWindow{From: times["compare_from"], To: times["compare_to"]}
+	if baseline.From > baseline.To || compare.From > compare.To {
+		return baseline, compare, errors.New("a window can't end before it starts")
+	}
+	return baseline, compare, nil
+}
+
+// GetLogDiff godoc
+//
+//	@Summary		Diff the logs of two time windows
+//	@Description	Clusters the messages of a domain in a baseline and a compare window into templates and lists the templates
+//	@Description	that are new, gone or changed in frequency by at least ratio, ranked by how unexpected their count is.
+//	@Description	Pass both windows or a deploy time, which compares the window after it with the window before it.
+//	@Tags			Logging
+//	@Produce		json
+//	@Param			domain			path		string	true	"Domain name"
+//	@Param			baseline_from	query		string	false	"Start of the baseline window, unix milliseconds or RFC 3339"
+//	@Param			baseline_to		query		string	false	"End of the baseline window"
+//	@Param			compare_from	query		string	false	"Start of the compare window"
+//	@Param			compare_to		query		string	false	"End of the compare window"
+//	@Param			deploy			query		string	false	"Time of a deployment, instead of the windows"
+//	@Param			window			query		string	false	"Length of the windows around the deployment (default 1h)"
+//	@Param			group			query		string	false	"Only logs of this group"
+//	@Param			tag				query		string	false	"Only logs with this tag"
+//	@Param			level			query		string	false	"Only logs of this level"
+//	@Param			time_field		query		string	false	"The time the windows use"	Enums(received_at, event_time)
+//	@Param			ratio			query		number	false	"Minimum change in frequency of a changed template (default 2)"
+//	@Param			limit			query		int		false	"Maximum amount of templates per list (default 50, max 1000)"
+//	@Success		200				{object}	LogDiff
+//	@Failure		400				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/log/{domain}/diff [get]
+func (h *LogHandler) GetLogDiff(c *gin.Context) {
+	baseline, compare, err := diffWindows(c)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := FilterFromQuery(c)
+	if !ValidTimeField(filter.TimeField) {
+		utility.RespondWithError(c, http.StatusBadRequest, ErrInvalidTimeField.Error())
+		return
+	}
+	ratio, err := strconv.ParseFloat(c.DefaultQuery("ratio", strconv.FormatFloat(defaultDiffRatio, 'f', -1, 64)), 64)
+	if err != nil || ratio <= 1 {
+		utility.RespondWithError(c, http.StatusBadRequest, "ratio must be a number above 1")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDiffLimit)))
+	if err != nil || limit <= 0 {
+		utility.RespondWithError(c, http.StatusBadRequest, "limit must be a positive number")
+		return
+	}
+	limit = min(limit, maxDiffLimit)
+
+	domain := c.Param("domain")
+	counts := make([]map[string]*templateCount, 0, 2)
+	for _, window := range []*DiffWindow{&baseline, &compare} {
+		filter.From, filter.To = window.From, window.To
+		entries, err := h.logRepo.GetMessages(domain, filter, maxDiffEntries+1)
+		if err != nil {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(entries) > maxDiffEntries {
+			//The entries are read newest first, so the entries read cover the end of the window
+			entries = entries[:maxDiffEntries]
+			window.Truncated = true
+			window.From = EntryTime(entries[len(entries)-1], filter.TimeField)
+		}
+		window.Entries = len(entries)
+		counts = append(counts, countTemplates(entries))
+	}
+
+	scale := float64(compare.To-compare.From+1) / float64(baseline.To-baseline.From+1)
+	diff := LogDiff{Domain: domain, Baseline: baseline, Compare: compare}
+	diff.New, diff.Gone, diff.Changed = diffTemplates(counts[0], counts[1], scale, ratio)
+	diff.New = diff.New[:min(limit, len(diff.New))]
+	diff.Gone = diff.Gone[:min(limit, len(diff.Gone))]
+	diff.Changed = diff.Changed[:min(limit, len(diff.Changed))]
+
+	c.JSON(http.StatusOK, diff)
+}
@@ -0,0 +1,212 @@
+package logging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gofeather/internal/logpb"
+	"gofeather/internal/utility"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultMaxBodySize = 5 << 20
+
+	contentTypeJSON     = "application/json"
+	contentTypeMsgpack  = "application/msgpack"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+var (
+	errBodyTooLarge        = errors.New("request body is too large")
+	errUnsupportedEncoding = errors.New("unsupported content encoding, use gzip or zstd")
+	errStackedEncodings    = errors.New("only a single content encoding is supported")
+)
+
+// contentTypeAliases maps the binary content types to the body format they are decoded as,
+// any other content type is decoded as JSON like the handlers always did
+var contentTypeAliases = map[string]string{
+	contentTypeMsgpack:                contentTypeMsgpack,
+	"application/x-msgpack":           contentTypeMsgpack,
+	"application/vnd.msgpack":         contentTypeMsgpack,
+	contentTypeProtobuf:               contentTypeProtobuf,
+	"application/protobuf":            contentTypeProtobuf,
+	"application/vnd.google.protobuf": contentTypeProtobuf,
+}
+
+// readBody reads the request body, decompressing it according to the Content-Encoding header.
+// The size limit applies to the decompressed body, so small compressed payloads can't expand without bounds.
+func readBody(c *gin.Context, maxBodySize int64) ([]byte, error) {
+	reader, closeBody, err := decompressBody(c, maxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody()
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxBodySize+1))
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return nil, errBodyTooLarge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read body: %w", err)
+	}
+	if int64(len(body)) > maxBodySize {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
+
+// decompressBody wraps the request body in the decompressor of its Content-Encoding header, the returned function
+// releases it. A single encoding is accepted, and the zstd window is bounded by the size limit of the body.
+func decompressBody(c *gin.Context, maxBodySize int64) (io.Reader, func(), error) {
+	var reader io.Reader = c.Request.Body
+	closeReader := func() {}
+
+	encodings := make([]string, 0, 1)
+	for _, encoding := range strings.Split(c.GetHeader("Content-Encoding"), ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding != "" && encoding != "identity" {
+			encodings = append(encodings, encoding)
+		}
+	}
+	if len(encodings) == 0 {
+		return reader, closeReader, nil
+	}
+	if len(encodings) > 1 {
+		return nil, nil, errStackedEncodings
+	}
+
+	switch encodings[0] {
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return gzipReader, func() { _ = gzipReader.Close() }, nil
+	case "zstd":
+		window := uint64(min(max(maxBodySize, zstd.MinWindowSize), zstd.MaxWindowSize))
+		zstdReader, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(window), zstd.WithDecoderMaxMemory(window))
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+		return zstdReader, zstdReader.Close, nil
+	default:
+		return nil, nil, errUnsupportedEncoding
+	}
+}
+
+// bodyFormat returns the format of the body based on the Content-Type header
+func bodyFormat(c *gin.Context) string {
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil {
+		return contentTypeJSON
+	}
+	if format, exists := contentTypeAliases[mediaType]; exists {
+		return format
+	}
+	return contentTypeJSON
+}
+
+// decodeEntries decodes a single log entry, or a batch of them, from a request body in any supported encoding
+func decodeEntries(c *gin.Context, maxBodySize int64, batch bool) ([]JsonLog, error) {
+	body, err := readBody(c, maxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	switch bodyFormat(c) {
+	case contentTypeProtobuf:
+		if batch {
+			var logBatch logpb.LogBatch
+			if err := proto.Unmarshal(body, &logBatch); err != nil {
+				return nil, fmt.Errorf("invalid protobuf body: %w", err)
+			}
+			entries := make([]JsonLog, 0, len(logBatch.Entries))
+			for _, entry := range logBatch.Entries {
+				entries = append(entries, entryFromProto(entry))
+			}
+			return entries, nil
+		}
+		var entry logpb.LogEntry
+		if err := proto.Unmarshal(body, &entry); err != nil {
+			return nil, fmt.Errorf("invalid protobuf body: %w", err)
+		}
+		return []JsonLog{entryFromProto(&entry)}, nil
+
+	case contentTypeMsgpack:
+		decoder := msgpack.NewDecoder(bytes.NewReader(body))
+		decoder.SetCustomStructTag("json")
+		return decodeWith(decoder.Decode, batch)
+
+	default:
+		return decodeWith(json.NewDecoder(bytes.NewReader(body)).Decode, batch)
+	}
+}
+
+func decodeWith(decode func(v interface{}) error, batch bool) ([]JsonLog, error) {
+	if batch {
+		var entries []JsonLog
+		if err := decode(&entries); err != nil {
+			return nil, fmt.Errorf("invalid body: %w", err)
+		}
+		return entries, nil
+	}
+	var entry JsonLog
+	if err := decode(&entry); err != nil {
+		return nil, fmt.Errorf("invalid body: %w", err)
+	}
+	return []JsonLog{entry}, nil
+}
+
+// respondDecodeError maps the errors of decoding a request body to a response
+func respondDecodeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		utility.RespondWithError(c, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, errUnsupportedEncoding), errors.Is(err, errStackedEncodings):
+		utility.RespondWithError(c, http.StatusUnsupportedMediaType, err.Error())
+	default:
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+	}
+}
+
+func entryFromProto(entry *logpb.LogEntry) JsonLog {
+	logEntry := JsonLog{
+		Domain: entry.GetDomain(),
+		Group:  entry.GetGroup(),
+		Tag:    entry.GetTag(),
+		Level:  entry.GetLevel(),
+		Log:    entry.GetLog(),
+		//The server sets the timestamp to the receive time, so a timestamp given by the client is its event time
+		Timestamp: entry.GetTimestamp(),
+		EventTime: entry.GetEventTime(),
+	}
+	if entry.GetFields() != nil {
+		logEntry.Fields = entry.GetFields().AsMap()
+	}
+	if exception := entry.GetException(); exception != nil {
+		logEntry.Exception = &Exception{Type: exception.GetType(), Message: exception.GetMessage(), Frames: make([]StackFrame, 0)}
+		for _, frame := range exception.GetFrames() {
+			logEntry.Exception.Frames = append(logEntry.Exception.Frames, StackFrame{
+				File:     frame.GetFile(),
+				Line:     int(frame.GetLine()),
+				Function: frame.GetFunction(),
+			})
+		}
+	}
+	if id, err := primitive.ObjectIDFromHex(entry.GetId()); err == nil {
+		logEntry.ID = id
+	}
+	return logEntry
+}
@@ -0,0 +1,165 @@
+package logging
+
+import (
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/utility"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	// labelValuePlaceholder is replaced by the value of the label in the domain and group of a label rule
+	labelValuePlaceholder = "$label"
+	defaultLabelsField    = "labels"
+)
+
+// LabelRule sets the domain and group of the entries of which the source has the label, with the value when one
+// is given. Label is a label of the labels header or a field set from a header, like k8s_namespace.
+// $label in the domain or group is replaced by the value of the label. Without Override only entries
+// without a domain or group get one.
+type LabelRule struct {
+	Label    string `json:"label" mapstructure:"label"`
+	Value    string `json:"value" mapstructure:"value"`
+	Domain   string `json:"domain" mapstructure:"domain"`
+	Group    string `json:"group" mapstructure:"group"`
+	Override bool   `json:"override" mapstructure:"override"`
+}
+
+// EnrichmentConfig describes the metadata of the source of ingested entries, sent along in request headers or gRPC
+// metadata by log shippers running next to containers. Headers maps headers to the fields they fill and the labels
+// header holds comma separated key=value labels, which are stored in the labels field.
+type EnrichmentConfig struct {
+	Headers      map[string]string `mapstructure:"headers"`
+	LabelsHeader string            `mapstructure:"labels_header"`
+	LabelsField  string            `mapstructure:"labels_field"`
+	Rules        []LabelRule       `mapstructure:"rules"`
+}
+
+// Enricher adds the metadata of the source of a request to its entries
+type Enricher struct {
+	headers      map[string]string
+	labelsHeader string
+	labelsField  string
+	rules        []LabelRule
+}
+
+// NewEnricher creates an enricher, it returns nil when no headers are configured, which leaves entries as they are
+func NewEnricher(enrichmentConfig EnrichmentConfig) *Enricher {
+	if len(enrichmentConfig.Headers) == 0 && enrichmentConfig.LabelsHeader == "" {
+		return nil
+	}
+
+	enricher := &Enricher{
+		headers:      make(map[string]string, len(enrichmentConfig.Headers)),
+		labelsHeader: enrichmentConfig.LabelsHeader,
+		labelsField:  enrichmentConfig.LabelsField,
+		rules:        enrichmentConfig.Rules,
+	}
+	if enricher.labelsField == "" {
+		enricher.labelsField = defaultLabelsField
+	}
+	for header, field := range enrichmentConfig.Headers {
+		if field == "" {
+			log.Printf("Enrichment header %s has no field", header)
+			continue
+		}
+		enricher.headers[http.CanonicalHeaderKey(header)] = field
+	}
+	return enricher
+}
+
+// loadEnrichmentConfig reads the enrichment headers and label rules from the configuration file
+func loadEnrichmentConfig() EnrichmentConfig {
+	var enrichmentConfig EnrichmentConfig
+	if config.Exists(constants.Enrichment) {
+		if err := config.BindStruct(constants.Enrichment, &enrichmentConfig); err != nil {
+			log.Printf("Unable to read enrichment from config: %v", err)
+		}
+	}
+	return enrichmentConfig
+}
+
+// enrich adds the fields read from the headers to the entries and applies the label rules. Fields the entries
+// already have are kept.
+func (e *Enricher) enrich(header http.Header, entries []JsonLog) {
+	if e == nil {
+		return
+	}
+
+	fields := make(map[string]string, len(e.headers))
+	for name, field := range e.headers {
+		if value := strings.TrimSpace(header.Get(name)); value != "" {
+			fields[field] = value
+		}
+	}
+	var labels map[string]string
+	if e.labelsHeader != "" {
+		labels = parseLabels(header.Get(e.labelsHeader))
+	}
+	if len(fields) == 0 && len(labels) == 0 {
+		return
+	}
+
+	//Label keys like app.kubernetes.io/name hold dots, which MongoDB would read as nested fields in queries
+	stored := make(map[string]interface{}, len(labels))
+	for key, value := range labels {
+		stored[strings.ReplaceAll(key, ".", "_")] = value
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Fields == nil {
+			entry.Fields = make(map[string]interface{}, len(fields)+1)
+		}
+		for field, value := range fields {
+			if _, exists := entry.Fields[field]; !exists {
+				entry.Fields[field] = value
+			}
+		}
+		if _, exists := entry.Fields[e.labelsField]; !exists && len(stored) > 0 {
+			entry.Fields[e.labelsField] = stored
+		}
+		e.applyRules(entry, fields, labels)
+	}
+}
+
+// applyRules sets the domain and group of the entry from the first matching rules that set them
+func (e *Enricher) applyRules(entry *JsonLog, fields map[string]string, labels map[string]string) {
+	domainSet, groupSet := false, false
+	for _, rule := range e.rules {
+		value, exists := labels[rule.Label]
+		if !exists {
+			value, exists = fields[rule.Label]
+		}
+		if !exists || rule.Value != "" && rule.Value != value {
+			continue
+		}
+		if rule.Domain != "" && !domainSet && (entry.Domain == "" || rule.Override) {
+			//A label value can't turn the domain into an internal collection or a path, the rule is skipped instead
+			domain := strings.ReplaceAll(rule.Domain, labelValuePlaceholder, value)
+			if utility.ValidateDomain(domain) != nil {
+				continue
+			}
+			entry.Domain = domain
+			domainSet = true
+		}
+		if rule.Group != "" && !groupSet && (entry.Group == "" || rule.Override) {
+			entry.Group = strings.ReplaceAll(rule.Group, labelValuePlaceholder, value)
+			groupSet = true
+		}
+	}
+}
+
+// parseLabels reads labels like app=api,tier=web, pairs without a key are skipped
+func parseLabels(header string) map[string]string {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(pair, "=")
+		if key = strings.TrimSpace(key); key != "" {
+			labels[key] = strings.TrimSpace(value)
+		}
+	}
+	return labels
+}
@@ -0,0 +1,162 @@
+package logging
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fingerprintFrames is the amount of innermost frames used to fingerprint an exception
+const fingerprintFrames = 5
+
+type StackFrame struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function"`
+}
+
+// Exception is the structured form of a stack trace, frames are ordered innermost call first
+type Exception struct {
+	Type        string       `json:"type"`
+	Message     string       `json:"message"`
+	Frames      []StackFrame `json:"frames"`
+	Fingerprint string       `json:"fingerprint"`
+}
+
+type stackTraceParser func(lines []string) *Exception
+
+var stackTraceParsers = []stackTraceParser{parseJavaStackTrace, parsePythonStackTrace, parseGoStackTrace}
+
+var (
+	javaHeaderRegex   = regexp.MustCompile(`^(?:Exception in thread "[^"]*" )?([a-zA-Z_$][\w$]*(?:\.[a-zA-Z_$][\w$]*)*(?:Exception|Error|Throwable))(?::\s*(.*))?$`)
+	javaFrameRegex    = regexp.MustCompile(`^\s+at\s+([^\s(]+)\(([^:)]*)(?::(\d+))?\)`)
+	pythonFrameRegex  = regexp.MustCompile(`^\s+File "([^"]+)", line (\d+), in (.+)$`)
+	pythonErrorRegex  = regexp.MustCompile(`^([A-Za-z_][\w.]*)(?::\s?(.*))?$`)
+	goPanicRegex      = regexp.MustCompile(`^(panic|fatal error): (.*)$`)
+	goGoroutineRegex  = regexp.MustCompile(`^goroutine \d+ \[.*\]:$`)
+	goFileRegex       = regexp.MustCompile(`^\s+(\S+\.go):(\d+)`)
+	goFunctionRegex   = regexp.MustCompile(`^(\S+)\(.*\)$`)
+	pythonTraceHeader = "Traceback (most recent call last):"
+)
+
+// parseException detects a Java, Python or Go stack trace in a log message
+// and returns it in structured form, nil is returned when no stack trace is found
+func parseException(message string) *Exception {
+	lines := strings.Split(strings.ReplaceAll(message, "\r\n", "\n"), "\n")
+	for _, parser := range stackTraceParsers {
+		if exception := parser(lines); exception != nil {
+			exception.Fingerprint = exception.fingerprint()
+			return exception
+		}
+	}
+	return nil
+}
+
+// fingerprint identifies an exception by its type and innermost frames, line numbers are left out
+// so the fingerprint stays the same when unrelated code in the same file changes
+func (e *Exception) fingerprint() string {
+	hash := sha1.New()
+	hash.Write([]byte(e.Type))
+	for i := 0; i < len(e.Frames) && i < fingerprintFrames; i++ {
+		hash.Write([]byte("|" + e.Frames[i].File + ":" + e.Frames[i].Function))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+func parseJavaStackTrace(lines []string) *Exception {
+	for i, line := range lines {
+		match := javaHeaderRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil || i+1 >= len(lines) || !javaFrameRegex.MatchString(lines[i+1]) {
+			continue
+		}
+
+		exception := &Exception{Type: match[1], Message: match[2], Frames: make([]StackFrame, 0)}
+		for _, frameLine := range lines[i+1:] {
+			frame := javaFrameRegex.FindStringSubmatch(frameLine)
+			if frame == nil {
+				//The frames of the exception end at a "Caused by" or "... n more" line
+				break
+			}
+			lineNumber, _ := strconv.Atoi(frame[3])
+			exception.Frames = append(exception.Frames, StackFrame{File: frame[2], Line: lineNumber, Function: frame[1]})
+		}
+		return exception
+	}
+	return nil
+}
+
+func parsePythonStackTrace(lines []string) *Exception {
+	for i, line := range lines {
+		if strings.TrimSpace(line) != pythonTraceHeader {
+			continue
+		}
+
+		frames := make([]StackFrame, 0)
+		for _, traceLine := range lines[i+1:] {
+			if frame := pythonFrameRegex.FindStringSubmatch(traceLine); frame != nil {
+				lineNumber, _ := strconv.Atoi(frame[2])
+				frames = append(frames, StackFrame{File: frame[1], Line: lineNumber, Function: frame[3]})
+				continue
+			}
+			//Indented lines hold the source code of the previous frame
+			if strings.HasPrefix(traceLine, " ") || strings.HasPrefix(traceLine, "\t") || len(frames) == 0 {
+				continue
+			}
+
+			match := pythonErrorRegex.FindStringSubmatch(strings.TrimSpace(traceLine))
+			if match == nil {
+				return nil
+			}
+			//Python prints the innermost call last
+			for l, r := 0, len(frames)-1; l < r; l, r = l+1, r-1 {
+				frames[l], frames[r] = frames[r], frames[l]
+			}
+			return &Exception{Type: match[1], Message: match[2], Frames: frames}
+		}
+		return nil
+	}
+	return nil
+}
+
+func parseGoStackTrace(lines []string) *Exception {
+	for i, line := range lines {
+		match := goPanicRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		exception := &Exception{Type: match[1], Message: match[2], Frames: make([]StackFrame, 0)}
+		inGoroutine := false
+		function := ""
+		for _, traceLine := range lines[i+1:] {
+			if goGoroutineRegex.MatchString(traceLine) {
+				if inGoroutine {
+					//Only the frames of the panicking goroutine are kept
+					break
+				}
+				inGoroutine = true
+				continue
+			}
+			if !inGoroutine {
+				continue
+			}
+			if file := goFileRegex.FindStringSubmatch(traceLine); file != nil && function != "" {
+				lineNumber, _ := strconv.Atoi(file[2])
+				exception.Frames = append(exception.Frames, StackFrame{File: file[1], Line: lineNumber, Function: function})
+				function = ""
+				continue
+			}
+			if fn := goFunctionRegex.FindStringSubmatch(strings.TrimSpace(traceLine)); fn != nil {
+				function = fn[1]
+			}
+		}
+
+		if !inGoroutine {
+			continue
+		}
+		return exception
+	}
+	return nil
+}
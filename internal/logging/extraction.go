@@ -0,0 +1,369 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/metrics"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ExtractionGrok   = "grok"
+	ExtractionRegex  = "regex"
+	ExtractionLogfmt = "logfmt"
+	ExtractionJSON   = "json"
+
+	FieldTypeInt      = "int"
+	FieldTypeFloat    = "float"
+	FieldTypeDuration = "duration"
+	FieldTypeBool     = "bool"
+	FieldTypeString   = "string"
+)
+
+// ExtractionRule extracts structured fields from the messages of the entries matching its domain, group and tag,
+// empty values match anything. Type is grok or regex with a Pattern, logfmt for key=value pairs or json for a
+// JSON object embedded in the message. Types sets the type of extracted fields to int, float, duration (in seconds),
+// bool or string, values of other fields become numbers or durations when they look like one.
+type ExtractionRule struct {
+	Domain  string            `json:"domain" mapstructure:"domain"`
+	Group   string            `json:"group" mapstructure:"group"`
+	Tag     string            `json:"tag" mapstructure:"tag"`
+	Type    string            `json:"type" mapstructure:"type"`
+	Pattern string            `json:"pattern" mapstructure:"pattern"`
+	Types   map[string]string `json:"types" mapstructure:"types"`
+}
+
+// ExtractionConfig holds the extraction rules and custom grok patterns, which can be used by the rules
+// and each other next to the built-in ones
+type ExtractionConfig struct {
+	Patterns map[string]string `mapstructure:"patterns"`
+	Rules    []ExtractionRule  `mapstructure:"rules"`
+}
+
+type extractionRule struct {
+	ExtractionRule
+	grok  *grokPattern
+	regex *regexp.Regexp
+}
+
+// Extractor fills the fields of ingested entries from their messages
+type Extractor struct {
+	rules  []*extractionRule
+	missed *metrics.Counter
+}
+
+// NewExtractor compiles the extraction rules, invalid rules are logged and skipped
+func NewExtractor(extractionConfig ExtractionConfig) *Extractor {
+	custom := make(map[string]string, len(extractionConfig.Patterns))
+	for name, pattern := range extractionConfig.Patterns {
+		custom[strings.ToUpper(name)] = pattern
+	}
+
+	extractor := &Extractor{rules: make([]*extractionRule, 0, len(extractionConfig.Rules))}
+	for _, rule := range extractionConfig.Rules {
+		compiled, err := compileExtractionRule(rule, custom)
+		if err != nil {
+			log.Printf("Invalid extraction rule for domain %q: %v", rule.Domain, err)
+			continue
+		}
+		extractor.rules = append(extractor.rules, compiled)
+	}
+
+	missed, err := metrics.DefaultRegistry.NewCounter("gofeather_extraction_misses_total",
+		"Log entries of which the message didn't match the pattern of an extraction rule", "domain", "type")
+	if err != nil {
+		log.Printf("Unable to register the extraction metric: %v", err)
+	}
+	extractor.missed = missed
+	return extractor
+}
+
+// loadExtractionConfig reads the extraction rules from the configuration file
+func loadExtractionConfig() ExtractionConfig {
+	var extractionConfig ExtractionConfig
+	if config.Exists(constants.Extraction) {
+		if err := config.BindStruct(constants.Extraction, &extractionConfig); err != nil {
+			log.Printf("Unable to read extraction rules from config: %v", err)
+		}
+	}
+	return extractionConfig
+}
+
+func compileExtractionRule(rule ExtractionRule, custom map[string]string) (*extractionRule, error) {
+	for field, fieldType := range rule.Types {
+		if !validFieldType(fieldType) {
+			return nil, fmt.Errorf("unknown type %s of field %s", fieldType, field)
+		}
+	}
+
+	compiled := &extractionRule{ExtractionRule: rule}
+	var err error
+	switch rule.Type {
+	case ExtractionGrok:
+		compiled.grok, err = compileGrok(rule.Pattern, custom)
+	case ExtractionRegex:
+		compiled.regex, err = regexp.Compile(rule.Pattern)
+	case ExtractionLogfmt, ExtractionJSON:
+	default:
+		err = fmt.Errorf("unknown extraction type %q, use grok, regex, logfmt or json", rule.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+// extract applies every matching rule to the message of the entry in order. Fields the entry already has,
+// either sent by the client or extracted by an earlier rule, are never overwritten.
+func (e *Extractor) extract(entry *JsonLog) {
+	if e == nil || entry.Log == "" {
+		return
+	}
+	for _, rule := range e.rules {
+		if !rule.matches(*entry) {
+			continue
+		}
+		fields, ok := rule.extract(entry.Log)
+		if !ok {
+			e.missed.Inc(entry.Domain, rule.Type)
+			continue
+		}
+		for field, value := range fields {
+			if entry.Fields == nil {
+				entry.Fields = make(map[string]interface{}, len(fields))
+			}
+			if _, exists := entry.Fields[field]; !exists {
+				entry.Fields[field] = value
+			}
+		}
+	}
+}
+
+func (r *extractionRule) matches(entry JsonLog) bool {
+	return (r.Domain == "" || r.Domain == entry.Domain) &&
+		(r.Group == "" || r.Group == entry.Group) &&
+		(r.Tag == "" || r.Tag == entry.Tag)
+}
+
+// extract returns the typed fields of the message, or false when the message doesn't hold what the rule looks for
+func (r *extractionRule) extract(message string) (map[string]interface{}, bool) {
+	var values map[string]string
+	types := r.Types
+	switch r.Type {
+	case ExtractionGrok:
+		var grokTypes map[string]string
+		var ok bool
+		if values, grokTypes, ok = r.grok.match(message); !ok {
+			return nil, false
+		}
+		//Types of the rule override the types written in the pattern
+		for field, fieldType := range r.Types {
+			grokTypes[field] = fieldType
+		}
+		types = grokTypes
+	case ExtractionRegex:
+		match := r.regex.FindStringSubmatch(message)
+		if match == nil {
+			return nil, false
+		}
+		values = make(map[string]string)
+		for i, name := range r.regex.SubexpNames() {
+			if name != "" && match[i] != "" {
+				values[name] = match[i]
+			}
+		}
+	case ExtractionLogfmt:
+		values = parseLogfmt(message)
+	case ExtractionJSON:
+		return extractJSON(message, r.Types)
+	}
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	fields := make(map[string]interface{}, len(values))
+	for field, value := range values {
+		if typed, ok := coerceValue(value, types[field]); ok {
+			fields[field] = typed
+		}
+	}
+	return fields, true
+}
+
+// parseLogfmt reads the key=value pairs of a message, values may be quoted. Words without a value are skipped.
+func parseLogfmt(message string) map[string]string {
+	values := make(map[string]string)
+	for i := 0; i < len(message); {
+		if message[i] == ' ' || message[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(message) && message[i] != '=' && message[i] != ' ' && message[i] != '\t' {
+			i++
+		}
+		key := message[start:i]
+		if i >= len(message) || message[i] != '=' {
+			continue
+		}
+		i++
+
+		var value string
+		if i < len(message) && message[i] == '"' {
+			end := i + 1
+			for end < len(message) && message[end] != '"' {
+				if message[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(message) {
+				//An unterminated quote runs to the end of the message
+				value = message[i+1:]
+				i = len(message)
+			} else {
+				if unquoted, err := strconv.Unquote(message[i : end+1]); err == nil {
+					value = unquoted
+				} else {
+					value = message[i+1 : end]
+				}
+				i = end + 1
+			}
+		} else {
+			start := i
+			for i < len(message) && message[i] != ' ' && message[i] != '\t' {
+				i++
+			}
+			value = message[start:i]
+		}
+		if key != "" && !strings.ContainsAny(key, `"'`) {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// extractJSON decodes the JSON object starting at the first brace of the message, the text around it is ignored.
+// Only the fields in types are converted, the other values keep the type they have in the JSON.
+func extractJSON(message string, types map[string]string) (map[string]interface{}, bool) {
+	start := strings.IndexByte(message, '{')
+	if start < 0 {
+		return nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(message[start:])))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil || len(object) == 0 {
+		return nil, false
+	}
+
+	fields := make(map[string]interface{}, len(object))
+	for field, value := range object {
+		value = jsonNumbers(value)
+		if fieldType, exists := types[field]; exists {
+			typed, ok := coerceValue(fmt.Sprint(value), fieldType)
+			if !ok {
+				continue
+			}
+			value = typed
+		}
+		fields[field] = value
+	}
+	return fields, true
+}
+
+// jsonNumbers turns the numbers of a decoded JSON value into integers where they are whole and floats otherwise
+func jsonNumbers(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case json.Number:
+		if number, err := typed.Int64(); err == nil {
+			return number
+		}
+		number, _ := typed.Float64()
+		return number
+	case map[string]interface{}:
+		for key, inner := range typed {
+			typed[key] = jsonNumbers(inner)
+		}
+	case []interface{}:
+		for i, inner := range typed {
+			typed[i] = jsonNumbers(inner)
+		}
+	}
+	return value
+}
+
+func validFieldType(fieldType string) bool {
+	switch fieldType {
+	case FieldTypeInt, FieldTypeFloat, FieldTypeDuration, FieldTypeBool, FieldTypeString:
+		return true
+	}
+	return false
+}
+
+// coerceValue converts an extracted value to its type. Without a type integers, floats and durations like 13ms
+// are converted and anything else stays a string. Durations are stored in seconds, so they can be aggregated.
+// Values that don't fit their type are dropped, so a field always holds values of a single type.
+func coerceValue(value string, fieldType string) (interface{}, bool) {
+	switch fieldType {
+	case FieldTypeString:
+		return value, true
+	case FieldTypeInt:
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return number, true
+		}
+		if number, ok := parseNumber(value); ok {
+			return int64(number), true
+		}
+		return nil, false
+	case FieldTypeFloat:
+		return parseNumber(value)
+	case FieldTypeDuration:
+		//A bare number is taken as seconds already
+		if number, ok := parseNumber(value); ok {
+			return number, true
+		}
+		return parseDuration(value)
+	case FieldTypeBool:
+		if flag, err := strconv.ParseBool(value); err == nil {
+			return flag, true
+		}
+		return nil, false
+	}
+
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return number, true
+	}
+	if number, ok := parseNumber(value); ok {
+		return number, true
+	}
+	if seconds, ok := parseDuration(value); ok {
+		return seconds, true
+	}
+	return value, true
+}
+
+// parseNumber parses a float, unlike strconv.ParseFloat it refuses words like inf and nan
+func parseNumber(value string) (float64, bool) {
+	if value == "" || !strings.ContainsAny(value, "0123456789") || strings.ContainsAny(value, "nN") {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	return number, err == nil
+}
+
+// parseDuration parses a duration like 13ms or 1m30s to seconds
+func parseDuration(value string) (float64, bool) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, false
+	}
+	return duration.Seconds(), true
+}
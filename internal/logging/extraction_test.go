@@ -0,0 +1,198 @@
+package logging
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		values  map[string]string
+	}{
+		{
+			name:    "plain and quoted values",
+			message: `level=info msg="user logged in" user=42`,
+			values:  map[string]string{"level": "info", "msg": "user logged in", "user": "42"},
+		},
+		{
+			name:    "escaped quotes",
+			message: `msg="say \"hi\"" done=true`,
+			values:  map[string]string{"msg": `say "hi"`, "done": "true"},
+		},
+		{
+			name:    "invalid escape keeps the raw value",
+			message: `path="C:\q" n=1`,
+			values:  map[string]string{"path": `C:\q`, "n": "1"},
+		},
+		{
+			name:    "unterminated quote runs to the end",
+			message: `id=3 msg="open ended id=4`,
+			values:  map[string]string{"id": "3", "msg": "open ended id=4"},
+		},
+		{
+			name:    "words without a value are skipped",
+			message: "starting worker id=7 now",
+			values:  map[string]string{"id": "7"},
+		},
+		{
+			name:    "empty values",
+			message: "key= other=1",
+			values:  map[string]string{"key": "", "other": "1"},
+		},
+		{
+			name:    "tabs separate pairs",
+			message: "a=1\tb=2",
+			values:  map[string]string{"a": "1", "b": "2"},
+		},
+		{
+			name:    "quoted keys are skipped",
+			message: `"key"=1 =2 valid=3`,
+			values:  map[string]string{"valid": "3"},
+		},
+		{
+			name:    "later pairs win",
+			message: "a=1 a=2",
+			values:  map[string]string{"a": "2"},
+		},
+		{
+			name:    "no pairs",
+			message: "just a message",
+			values:  map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := parseLogfmt(test.message)
+			if !reflect.DeepEqual(values, test.values) {
+				t.Errorf("parseLogfmt(%q) = %v, want %v", test.message, values, test.values)
+			}
+		})
+	}
+}
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		value     string
+		fieldType string
+		want      interface{}
+		ok        bool
+	}{
+		{value: "42", want: int64(42), ok: true},
+		{value: "-7", want: int64(-7), ok: true},
+		{value: "1.5", want: 1.5, ok: true},
+		{value: "13ms", want: 0.013, ok: true},
+		{value: "1m30s", want: 90.0, ok: true},
+		{value: "inf", want: "inf", ok: true},
+		{value: "NaN", want: "NaN", ok: true},
+		{value: "", want: "", ok: true},
+		{value: "hello", want: "hello", ok: true},
+		{value: "42", fieldType: FieldTypeString, want: "42", ok: true},
+		{value: "13ms", fieldType: FieldTypeString, want: "13ms", ok: true},
+		{value: "200", fieldType: FieldTypeInt, want: int64(200), ok: true},
+		{value: "2.7", fieldType: FieldTypeInt, want: int64(2), ok: true},
+		{value: "many", fieldType: FieldTypeInt},
+		{value: "3", fieldType: FieldTypeFloat, want: 3.0, ok: true},
+		{value: "nan", fieldType: FieldTypeFloat},
+		{value: "+Inf", fieldType: FieldTypeFloat},
+		{value: "2", fieldType: FieldTypeDuration, want: 2.0, ok: true},
+		{value: "250ms", fieldType: FieldTypeDuration, want: 0.25, ok: true},
+		{value: "soon", fieldType: FieldTypeDuration},
+		{value: "true", fieldType: FieldTypeBool, want: true, ok: true},
+		{value: "0", fieldType: FieldTypeBool, want: false, ok: true},
+		{value: "yes", fieldType: FieldTypeBool},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fieldType+"/"+test.value, func(t *testing.T) {
+			got, ok := coerceValue(test.value, test.fieldType)
+			if ok != test.ok {
+				t.Fatalf("coerceValue(%q, %q) ok = %t, want %t", test.value, test.fieldType, ok, test.ok)
+			}
+			if ok && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("coerceValue(%q, %q) = %#v, want %#v", test.value, test.fieldType, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		types   map[string]string
+		fields  map[string]interface{}
+		ok      bool
+	}{
+		{
+			name:    "object embedded in text",
+			message: `request done {"status":200,"took":0.5,"path":"/orders"} trailing`,
+			fields:  map[string]interface{}{"status": int64(200), "took": 0.5, "path": "/orders"},
+			ok:      true,
+		},
+		{
+			name:    "nested objects and arrays",
+			message: `{"user":{"id":7,"roles":["admin"]},"scores":[1,2.5]}`,
+			fields: map[string]interface{}{
+				"user":   map[string]interface{}{"id": int64(7), "roles": []interface{}{"admin"}},
+				"scores": []interface{}{int64(1), 2.5},
+			},
+			ok: true,
+		},
+		{
+			name:    "numbers too large for an integer",
+			message: `{"big":12345678901234567890}`,
+			fields:  map[string]interface{}{"big": 12345678901234567890.0},
+			ok:      true,
+		},
+		{
+			name:    "typed fields",
+			message: `{"status":200,"took":"13ms","flag":"maybe","user":"42"}`,
+			types:   map[string]string{"status": FieldTypeString, "took": FieldTypeDuration, "flag": FieldTypeBool},
+			fields:  map[string]interface{}{"status": "200", "took": 0.013, "user": "42"},
+			ok:      true,
+		},
+		{
+			name:    "braces in strings",
+			message: `{"msg":"a } b {"}`,
+			fields:  map[string]interface{}{"msg": "a } b {"},
+			ok:      true,
+		},
+		{
+			name:    "no object",
+			message: "nothing to see",
+		},
+		{
+			name:    "invalid json",
+			message: `broken {"status":}`,
+		},
+		{
+			name:    "unterminated object",
+			message: `{"status":200`,
+		},
+		{
+			name:    "empty object",
+			message: "{}",
+		},
+		{
+			name:    "first object of an array",
+			message: `[{"status":200},{"status":500}]`,
+			fields:  map[string]interface{}{"status": int64(200)},
+			ok:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, ok := extractJSON(test.message, test.types)
+			if ok != test.ok {
+				t.Fatalf("extractJSON(%q) ok = %t, want %t", test.message, ok, test.ok)
+			}
+			if ok && !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("extractJSON(%q) = %#v, want %#v", test.message, fields, test.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package logging
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gofeather/internal/utility"
+)
+
+const (
+	// TimeFieldReceived selects the time the server received an entry, which every entry has
+	TimeFieldReceived = "received_at"
+	// TimeFieldEvent selects the time the client logged an entry, falling back to the receive time for older entries
+	TimeFieldEvent = "event_time"
+
+	// sortTimeKey holds the time an entry is sorted on within TimeSortPipeline
+	sortTimeKey = "_sort_time"
+)
+
+// ErrInvalidTimeField is returned for a time field other than received_at or event_time
+var ErrInvalidTimeField = errors.New("time_field must be either received_at or event_time")
+
+// LogFilter narrows down the log entries of a domain, empty fields are not filtered on
+type LogFilter struct {
+	Group         string `json:"group" mapstructure:"group"`
+	Tag           string `json:"tag" mapstructure:"tag"`
+	Level         string `json:"level" mapstructure:"level"`
+	ExceptionType string `json:"exception_type" mapstructure:"exception_type"`
+	// Invalid only passes the entries that didn't match the schema of their domain
+	Invalid bool `json:"invalid" mapstructure:"invalid"`
+	// TimeField is the time From, To and the order of a query use, empty means received_at.
+	// From and To are in unix milliseconds, both ends are included and zero leaves that end open.
+	TimeField string `json:"time_field" mapstructure:"time_field"`
+	From      int64  `json:"from" mapstructure:"from"`
+	To        int64  `json:"to" mapstructure:"to"`
+}
+
+// FilterFromQuery reads the log filter from the query parameters of a request
+func FilterFromQuery(c *gin.Context) LogFilter {
+	return LogFilter{
+		Group:         c.Query("group"),
+		Tag:           c.Query("tag"),
+		Level:         c.Query("level"),
+		ExceptionType: c.Query("exception_type"),
+		Invalid:       c.Query("invalid") == "true",
+		TimeField:     c.Query("time_field"),
+	}
+}
+
+// TimeRangeFromQuery reads the time field and the from and to query parameters into the filter,
+// the times are unix milliseconds or RFC 3339
+func TimeRangeFromQuery(c *gin.Context, filter *LogFilter) error {
+	if !ValidTimeField(filter.TimeField) {
+		return ErrInvalidTimeField
+	}
+	var err error
+	if filter.From, err = utility.ParseTime(c.Query("from"), 0); err != nil {
+		return errors.New("from " + err.Error())
+	}
+	if filter.To, err = utility.ParseTime(c.Query("to"), 0); err != nil {
+		return errors.New("to " + err.Error())
+	}
+	return nil
+}
+
+// ValidTimeField checks a time field, empty selects the receive time
+func ValidTimeField(field string) bool {
+	return field == "" || field == TimeFieldReceived || field == TimeFieldEvent
+}
+
+// TimeSortPipeline returns an aggregation of the entries matching the filter sorted on a time field in the order,
+// 1 for oldest first and -1 for newest first, followed by the stages. Entries without an event time are sorted on
+// their receive time, like TimeCondition matches them. A limit of zero returns all of them.
+func TimeSortPipeline(field string, filter bson.M, order int, limit int64, stages ...bson.D) mongo.Pipeline {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+	if field == TimeFieldEvent {
+		sortTime := bson.M{"$ifNull": bson.A{"$" + TimeFieldEvent, "$timestamp"}}
+		pipeline = append(pipeline,
+			bson.D{{Key: "$addFields", Value: bson.M{sortTimeKey: sortTime}}},
+			bson.D{{Key: "$sort", Value: bson.D{{Key: sortTimeKey, Value: order}, {Key: "_id", Value: order}}}})
+	} else {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: order}, {Key: "_id", Value: order}}}})
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+	}
+	if field == TimeFieldEvent {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: bson.M{sortTimeKey: 0}}})
+	}
+	return append(pipeline, stages...)
+}
+
+// TimeCondition applies the condition to a time field of the entries. Entries without an event time
+// are matched on their receive time instead.
+func TimeCondition(field string, condition bson.M) bson.M {
+	if field != TimeFieldEvent {
+		return bson.M{"timestamp": condition}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{TimeFieldEvent: condition},
+		bson.M{TimeFieldEvent: bson.M{"$exists": false}, "timestamp": condition},
+	}}
+}
+
+// EntryTime returns a time field of an entry, mirroring TimeCondition for entries without an event time
+func EntryTime(entry JsonLog, field string) int64 {
+	if field == TimeFieldEvent && entry.EventTime != 0 {
+		return entry.EventTime
+	}
+	return entry.Timestamp
+}
+
+// toBSON converts the filter to a MongoDB query filter
+func (f LogFilter) toBSON() bson.M {
+	filter := bson.M{}
+	if f.Group != "" {
+		filter["group"] = f.Group
+	}
+	if f.Tag != "" {
+		filter["tag"] = f.Tag
+	}
+	if f.Level != "" {
+		filter["level"] = f.Level
+	}
+	if f.ExceptionType != "" {
+		filter["exception.type"] = f.ExceptionType
+	}
+	if f.Invalid {
+		filter["schema_errors.0"] = bson.M{"$exists": true}
+	}
+	if f.From != 0 || f.To != 0 {
+		condition := bson.M{}
+		if f.From != 0 {
+			condition["$gte"] = f.From
+		}
+		if f.To != 0 {
+			condition["$lte"] = f.To
+		}
+		for key, value := range TimeCondition(f.TimeField, condition) {
+			filter[key] = value
+		}
+	}
+	return filter
+}
+
+// Matches checks if an entry passes the filter, mirroring the MongoDB filter of toBSON
+func (f LogFilter) Matches(entry JsonLog) bool {
+	if f.Group != "" && f.Group != entry.Group {
+		return false
+	}
+	if f.Tag != "" && f.Tag != entry.Tag {
+		return false
+	}
+	if f.Level != "" && f.Level != entry.Level {
+		return false
+	}
+	if f.ExceptionType != "" && (entry.Exception == nil || f.ExceptionType != entry.Exception.Type) {
+		return false
+	}
+	if f.Invalid && len(entry.SchemaErrors) == 0 {
+		return false
+	}
+	entryTime := EntryTime(entry, f.TimeField)
+	if (f.From != 0 && entryTime < f.From) || (f.To != 0 && entryTime > f.To) {
+		return false
+	}
+	return true
+}
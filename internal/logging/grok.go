@@ -0,0 +1,158 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxGrokDepth bounds the nesting of grok patterns, so patterns referring to themselves fail instead of looping
+const maxGrokDepth = 20
+
+// grokReference matches %{SYNTAX}, %{SYNTAX:field} and %{SYNTAX:field:type} in a grok pattern
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.@-]+))?(?::(\w+))?\}`)
+
+// grokPatterns are the built-in grok patterns, written for the RE2 syntax of Go instead of the Oniguruma syntax
+// of the original grok library, so a few of them are simplified
+var grokPatterns = map[string]string{
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"EMAILLOCALPART":    `[a-zA-Z0-9!#$%&'*+/=?^_{|}~-]+(?:\.[a-zA-Z0-9!#$%&'*+/=?^_{|}~-]+)*`,
+	"EMAILADDRESS":      `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"INT":               `[+-]?\d+`,
+	"BASE10NUM":         `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"NUMBER":            `%{BASE10NUM}`,
+	"BASE16NUM":         `(?:0[xX])?[0-9a-fA-F]+`,
+	"POSINT":            `\b[1-9]\d*\b`,
+	"NONNEGINT":         `\b\d+\b`,
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":                `%{QUOTEDSTRING}`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)`,
+	"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`,
+	"IP":                `%{IPV6}|%{IPV4}`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":          `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"PATH":              `(?:/[^\s?#]*)+`,
+	"URIPROTO":          `[A-Za-z][A-Za-z0-9+.-]*`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":               `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{IPORHOST}(?::%{POSINT})?)?(?:%{URIPATHPARAM})?`,
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]une?|[Jj]uly?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12]\d|3[01]|[1-9]`,
+	"YEAR":              `\d{4}|\d{2}`,
+	"HOUR":              `2[0-3]|[01]?\d`,
+	"MINUTE":            `[0-5]\d`,
+	"SECOND":            `(?:[0-5]?\d|60)(?:[:.,]\d+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}:%{SECOND}`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} [+-]\d{4}`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?|alert)`,
+	"DURATION":          `%{BASE10NUM}(?:ns|us|µs|ms|s|m|h)`,
+	"COMMONAPACHELOG":   `%{IPORHOST:client_ip} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:http_version})?|%{DATA:raw_request})" %{NUMBER:response:int} (?:%{NUMBER:bytes:int}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+}
+
+// grokCapture maps a capture group of a compiled grok pattern to the field it fills
+type grokCapture struct {
+	group     string
+	field     string
+	fieldType string
+}
+
+// grokPattern is a grok pattern compiled to a regular expression
+type grokPattern struct {
+	expression *regexp.Regexp
+	captures   []grokCapture
+}
+
+// compileGrok expands the references of a grok pattern into a regular expression. The custom patterns are
+// looked up before the built-in ones, pattern names are case-insensitive.
+func compileGrok(pattern string, custom map[string]string) (*grokPattern, error) {
+	compiled := &grokPattern{}
+	expanded, err := compiled.expand(pattern, custom, 0)
+	if err != nil {
+		return nil, err
+	}
+	if compiled.expression, err = regexp.Compile(expanded); err != nil {
+		return nil, err
+	}
+	return compiled, nil
+}
+
+func (g *grokPattern) expand(pattern string, custom map[string]string, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", fmt.Errorf("grok patterns are nested more than %d levels deep", maxGrokDepth)
+	}
+
+	var expandErr error
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if expandErr != nil {
+			return ""
+		}
+		parts := grokReference.FindStringSubmatch(reference)
+		name, field, fieldType := strings.ToUpper(parts[1]), parts[2], parts[3]
+
+		definition, exists := custom[name]
+		if !exists {
+			definition, exists = grokPatterns[name]
+		}
+		if !exists {
+			expandErr = fmt.Errorf("unknown grok pattern %s", parts[1])
+			return ""
+		}
+		if fieldType != "" && !validFieldType(fieldType) {
+			expandErr = fmt.Errorf("unknown type %s of grok field %s", fieldType, field)
+			return ""
+		}
+
+		inner, err := g.expand(definition, custom, depth+1)
+		if err != nil {
+			expandErr = err
+			return ""
+		}
+		if field == "" {
+			return "(?:" + inner + ")"
+		}
+		//Field names may hold dots, which group names can't, so the groups are numbered instead
+		group := "g" + strconv.Itoa(len(g.captures))
+		g.captures = append(g.captures, grokCapture{group: group, field: field, fieldType: fieldType})
+		return "(?P<" + group + ">" + inner + ")"
+	})
+	return expanded, expandErr
+}
+
+// match returns the captured fields with their declared type, or false when the message doesn't match
+func (g *grokPattern) match(message string) (map[string]string, map[string]string, bool) {
+	match := g.expression.FindStringSubmatch(message)
+	if match == nil {
+		return nil, nil, false
+	}
+
+	values := make(map[string]string)
+	types := make(map[string]string)
+	for i, group := range g.expression.SubexpNames() {
+		if group == "" || match[i] == "" {
+			continue
+		}
+		for _, capture := range g.captures {
+			if capture.group == group {
+				values[capture.field] = match[i]
+				if capture.fieldType != "" {
+					types[capture.field] = capture.fieldType
+				}
+			}
+		}
+	}
+	return values, types, true
+}
@@ -0,0 +1,164 @@
+package logging
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileGrok(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		custom  map[string]string
+		message string
+		values  map[string]string
+		types   map[string]string
+		matches bool
+	}{
+		{
+			name:    "fields with types",
+			pattern: "%{WORD:method} %{URIPATHPARAM:path} took %{DURATION:took} status=%{INT:status:int}",
+			message: "GET /orders?id=7 took 13ms status=200",
+			values:  map[string]string{"method": "GET", "path": "/orders?id=7", "took": "13ms", "status": "200"},
+			types:   map[string]string{"status": FieldTypeInt},
+			matches: true,
+		},
+		{
+			name:    "custom pattern nesting a built-in one",
+			pattern: "%{REQUEST} done",
+			custom:  map[string]string{"REQUEST": "%{WORD:method} %{NOTSPACE:path}"},
+			message: "POST /login done",
+			values:  map[string]string{"method": "POST", "path": "/login"},
+			types:   map[string]string{},
+			matches: true,
+		},
+		{
+			name:    "custom pattern overrides a built-in one",
+			pattern: "%{WORD:word}",
+			custom:  map[string]string{"WORD": "[0-9]+"},
+			message: "abc 123",
+			values:  map[string]string{"word": "123"},
+			types:   map[string]string{},
+			matches: true,
+		},
+		{
+			name:    "pattern names are case-insensitive",
+			pattern: "%{int:count}",
+			message: "count 42",
+			values:  map[string]string{"count": "42"},
+			types:   map[string]string{},
+			matches: true,
+		},
+		{
+			name:    "field names may hold dots",
+			pattern: "%{IP:client.ip}",
+			message: "from 10.0.0.1",
+			values:  map[string]string{"client.ip": "10.0.0.1"},
+			types:   map[string]string{},
+			matches: true,
+		},
+		{
+			name:    "quoted strings keep their quotes",
+			pattern: `%{QS:agent}`,
+			message: `"curl/8.0 \"beta\""`,
+			values:  map[string]string{"agent": `"curl/8.0 \"beta\""`},
+			types:   map[string]string{},
+			matches: true,
+		},
+		{
+			name:    "combined apache log",
+			pattern: "%{COMBINEDAPACHELOG}",
+			message: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/5.0"`,
+			values: map[string]string{
+				"client_ip": "127.0.0.1", "ident": "-", "auth": "frank", "timestamp": "10/Oct/2000:13:55:36 -0700",
+				"verb": "GET", "request": "/a.gif", "http_version": "1.0", "response": "200", "bytes": "2326",
+				"referrer": `"http://example.com/"`, "agent": `"Mozilla/5.0"`,
+			},
+			types:   map[string]string{"response": FieldTypeInt, "bytes": FieldTypeInt},
+			matches: true,
+		},
+		{
+			name:    "message that doesn't match",
+			pattern: "status=%{INT:status}",
+			message: "status=ok",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grok, err := compileGrok(test.pattern, test.custom)
+			if err != nil {
+				t.Fatalf("compileGrok(%q) returned %v", test.pattern, err)
+			}
+			values, types, matches := grok.match(test.message)
+			if matches != test.matches {
+				t.Fatalf("match(%q) matched %t, want %t", test.message, matches, test.matches)
+			}
+			if !matches {
+				return
+			}
+			if !reflect.DeepEqual(values, test.values) {
+				t.Errorf("match(%q) values = %v, want %v", test.message, values, test.values)
+			}
+			if !reflect.DeepEqual(types, test.types) {
+				t.Errorf("match(%q) types = %v, want %v", test.message, types, test.types)
+			}
+		})
+	}
+}
+
+func TestCompileGrokErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		custom  map[string]string
+		err     string
+	}{
+		{
+			name:    "unknown pattern",
+			pattern: "%{NOPE:field}",
+			err:     "unknown grok pattern NOPE",
+		},
+		{
+			name:    "unknown pattern in a custom one",
+			pattern: "%{OUTER}",
+			custom:  map[string]string{"OUTER": "%{INNER:field}"},
+			err:     "unknown grok pattern INNER",
+		},
+		{
+			name:    "unknown type",
+			pattern: "%{INT:status:number}",
+			err:     "unknown type number of grok field status",
+		},
+		{
+			name:    "pattern referring to itself",
+			pattern: "%{LOOP}",
+			custom:  map[string]string{"LOOP": "a%{LOOP}"},
+			err:     "nested more than 20 levels deep",
+		},
+		{
+			name:    "patterns referring to each other",
+			pattern: "%{PING}",
+			custom:  map[string]string{"PING": "%{PONG}", "PONG": "%{PING}"},
+			err:     "nested more than 20 levels deep",
+		},
+		{
+			name:    "invalid regular expression",
+			pattern: "%{INT:count} (",
+			err:     "missing closing )",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := compileGrok(test.pattern, test.custom)
+			if err == nil {
+				t.Fatalf("compileGrok(%q) returned no error", test.pattern)
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("compileGrok(%q) returned %q, want it to contain %q", test.pattern, err, test.err)
+			}
+		})
+	}
+}
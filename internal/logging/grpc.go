@@ -0,0 +1,327 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gofeather/internal/logpb"
+	"gofeather/internal/tenancy"
+	"gofeather/internal/utility"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// grpcIngestChunk is the amount of streamed entries passed to the pipeline at once
+const grpcIngestChunk = 100
+
+// GRPCServer serves the logs over gRPC using the same pipeline, repository and tail hub as the REST routes
+type GRPCServer struct {
+	logpb.UnimplementedLogServiceServer
+	logRepo  LogRepository
+	pipeline *Pipeline
+	hub      *TailHub
+	tenants  *tenancy.Registry
+}
+
+func NewGRPCServer(components *Components) *GRPCServer {
+	return &GRPCServer{logRepo: components.LogRepo, pipeline: components.Pipeline, hub: components.Hub, tenants: components.Tenants}
+}
+
+// StartGRPCServer listens on the address and serves the log service in the background,
+// the program is fatally closed when the address can't be listened on
+func StartGRPCServer(address string, components *Components) *grpc.Server {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("Failed to listen for gRPC on %s: %v", address, err)
+	}
+
+	server := grpc.NewServer()
+	logpb.RegisterLogServiceServer(server, NewGRPCServer(components))
+
+	go func() {
+		log.Printf("Starting gRPC API on %s", address)
+		if err := server.Serve(listener); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+	return server
+}
+
+func (s *GRPCServer) Ingest(stream logpb.LogService_IngestServer) error {
+	key := ingestKeyFromMetadata(stream)
+	header := headerFromMetadata(stream.Context())
+	response := &logpb.IngestResponse{Queued: make([]string, 0)}
+
+	chunk := make([]JsonLog, 0, grpcIngestChunk)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		s.pipeline.enrich(header, chunk)
+		result, err := s.pipeline.Ingest(key, chunk)
+		if err != nil {
+			return partialIngestStatus(ingestStatus(err), response)
+		}
+		for _, id := range result.Queued {
+			response.Queued = append(response.Queued, id.Hex())
+		}
+		response.Dropped += int64(result.Dropped)
+		chunk = chunk[:0]
+		return nil
+	}
+
+	for {
+		entry, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if err := flush(); err != nil {
+				return err
+			}
+			return stream.SendAndClose(response)
+		}
+		if err != nil {
+			return err
+		}
+
+		chunk = append(chunk, entryFromProto(entry))
+		if len(chunk) >= grpcIngestChunk {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *GRPCServer) Query(ctx context.Context, request *logpb.QueryRequest) (*logpb.QueryResponse, error) {
+	if request.GetDomain() == "" {
+		return nil, status.Error(codes.InvalidArgument, ErrMissingDomain.Error())
+	}
+	if err := s.authorizeDomain(ctx, request.GetDomain()); err != nil {
+		return nil, err
+	}
+	if request.GetLimit() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must be a positive number")
+	}
+	filter := filterFromProto(request.GetFilter())
+	if !ValidTimeField(filter.TimeField) {
+		return nil, status.Error(codes.InvalidArgument, ErrInvalidTimeField.Error())
+	}
+
+	entries, err := s.logRepo.GetLogs(request.GetDomain(), filter, request.GetLimit())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response := &logpb.QueryResponse{Entries: make([]*logpb.LogEntry, 0, len(entries))}
+	for _, entry := range entries {
+		response.Entries = append(response.Entries, entryToProto(entry))
+	}
+	return response, nil
+}
+
+func (s *GRPCServer) Tail(request *logpb.TailRequest, stream logpb.LogService_TailServer) error {
+	if request.GetDomain() == "" {
+		return status.Error(codes.InvalidArgument, ErrMissingDomain.Error())
+	}
+	if err := s.authorizeDomain(stream.Context(), request.GetDomain()); err != nil {
+		return err
+	}
+
+	subscription := s.hub.Subscribe(request.GetDomain(), filterFromProto(request.GetFilter()))
+	defer s.hub.Unsubscribe(subscription)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case entry := <-subscription.Entries:
+			if err := stream.Send(entryToProto(entry)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// partialIngestStatus adds the response of the chunks that were queued before a chunk failed to the status as a
+// detail, so the client knows which entries not to send again
+func partialIngestStatus(err error, response *logpb.IngestResponse) error {
+	if len(response.Queued) == 0 && response.Dropped == 0 {
+		return err
+	}
+	withResponse, detailErr := status.Convert(err).WithDetails(response)
+	if detailErr != nil {
+		log.Printf("Unable to add the queued entries to the gRPC status: %v", detailErr)
+		return err
+	}
+	return withResponse.Err()
+}
+
+// ingestStatus maps the errors of the ingest pipeline to a gRPC status
+func ingestStatus(err error) error {
+	var rateLimitErr *RateLimitError
+	var schemaErr *SchemaError
+	switch {
+	case errors.As(err, &rateLimitErr) && rateLimitErr.Decision.TooLarge:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &rateLimitErr):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.As(err, &schemaErr):
+		described := make([]string, 0, len(schemaErr.Violations))
+		for _, violation := range schemaErr.Violations {
+			described = append(described, fmt.Sprintf("entry %d: %s", violation.Index, strings.Join(violation.Errors, ", ")))
+		}
+		return status.Error(codes.InvalidArgument, err.Error()+": "+strings.Join(described, "; "))
+	case errors.Is(err, ErrQueueFull):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, ErrMissingDomain), errors.Is(err, utility.ErrInvalidDomain):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, tenancy.ErrUnknownKey):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, tenancy.ErrDomainNotAllowed):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// authorizeDomain checks that the domain is valid and the caller may read it when tenancy is on, the caller is identified by
+// a bearer token in the authorization metadata. Ingest keys only write logs.
+func (s *GRPCServer) authorizeDomain(ctx context.Context, domain string) error {
+	if err := utility.ValidateDomain(domain); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if s.tenants == nil {
+		return nil
+	}
+
+	bearerToken, _ := strings.CutPrefix(metadataValue(ctx, "authorization"), "Bearer ")
+	caller, ok := s.tenants.Caller(bearerToken, "")
+	if !ok {
+		return status.Error(codes.Unauthenticated, "a tenant member's access token is required")
+	}
+	if !s.tenants.CanAccess(caller, domain) {
+		return status.Error(codes.NotFound, "domain not found")
+	}
+	return nil
+}
+
+func ingestKeyFromMetadata(stream grpc.ServerStream) string {
+	return metadataValue(stream.Context(), strings.ToLower(IngestKeyHeader))
+}
+
+// headerFromMetadata returns the incoming metadata as request headers, so it is enriched like HTTP requests
+func headerFromMetadata(ctx context.Context) http.Header {
+	header := make(http.Header)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	return header
+}
+
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func filterFromProto(filter *logpb.LogFilter) LogFilter {
+	return LogFilter{
+		Group:         filter.GetGroup(),
+		Tag:           filter.GetTag(),
+		Level:         filter.GetLevel(),
+		ExceptionType: filter.GetExceptionType(),
+		Invalid:       filter.GetInvalid(),
+		TimeField:     filter.GetTimeField(),
+		From:          filter.GetFrom(),
+		To:            filter.GetTo(),
+	}
+}
+
+func entryToProto(entry JsonLog) *logpb.LogEntry {
+	protoEntry := &logpb.LogEntry{
+		Domain:       entry.Domain,
+		Group:        entry.Group,
+		Tag:          entry.Tag,
+		Level:        entry.Level,
+		Log:          entry.Log,
+		Id:           entry.ID.Hex(),
+		Timestamp:    entry.Timestamp,
+		RepeatCount:  entry.RepeatCount,
+		SchemaErrors: entry.SchemaErrors,
+		EventTime:    entry.EventTime,
+		ReceivedAt:   entry.ReceivedAt,
+		ClockSkew:    entry.ClockSkew,
+	}
+	if len(entry.Fields) > 0 {
+		fields, err := structpb.NewStruct(normalizeFields(entry.Fields))
+		if err != nil {
+			log.Printf("Unable to convert fields of log entry %s: %v", entry.ID.Hex(), err)
+		} else {
+			protoEntry.Fields = fields
+		}
+	}
+	if entry.Exception != nil {
+		protoEntry.Exception = &logpb.Exception{
+			Type:        entry.Exception.Type,
+			Message:     entry.Exception.Message,
+			Fingerprint: entry.Exception.Fingerprint,
+		}
+		for _, frame := range entry.Exception.Frames {
+			protoEntry.Exception.Frames = append(protoEntry.Exception.Frames, &logpb.StackFrame{
+				File:     frame.File,
+				Line:     int32(frame.Line),
+				Function: frame.Function,
+			})
+		}
+	}
+	return protoEntry
+}
+
+// normalizeFields converts the BSON types of fields read from the database to plain Go types
+func normalizeFields(fields map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		normalized[key] = normalizeValue(value)
+	}
+	return normalized
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.M:
+		return normalizeFields(v)
+	case map[string]interface{}:
+		return normalizeFields(v)
+	case primitive.D:
+		return normalizeFields(v.Map())
+	case primitive.A:
+		return normalizeValue([]interface{}(v))
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = normalizeValue(item)
+		}
+		return values
+	case primitive.DateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case primitive.ObjectID:
+		return v.Hex()
+	}
+	return value
+}
@@ -0,0 +1,453 @@
+package logging
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gofeather/internal/tenancy"
+	"gofeather/internal/utility"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// IngestKeyHeader identifies the ingest key of a client posting logs
+	IngestKeyHeader = "X-API-Key"
+
+	defaultContextSize = 10
+	maxContextSize     = 100
+
+	// tailKeepAlive is how often an idle tail stream is pinged, so proxies don't close it
+	tailKeepAlive = 15 * time.Second
+)
+
+type LogHandler struct {
+	logRepo       LogRepository
+	pipeline      *Pipeline
+	sampler       *Sampler
+	hub           *TailHub
+	schemas       *SchemaRegistry
+	tenants       *tenancy.Registry
+	maxBodySize   int64
+	maxImportSize int64
+	trustedKeys   []string
+}
+
+func NewLogHandler(logRepo LogRepository, pipeline *Pipeline, sampler *Sampler, hub *TailHub, schemas *SchemaRegistry, tenants *tenancy.Registry, ingestConfig IngestConfig) *LogHandler {
+	maxBodySize := ingestConfig.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+	maxImportSize := ingestConfig.MaxImportSize
+	if maxImportSize <= 0 {
+		maxImportSize = defaultMaxImportSize
+	}
+	return &LogHandler{logRepo: logRepo, pipeline: pipeline, sampler: sampler, hub: hub, schemas: schemas, tenants: tenants,
+		maxBodySize: maxBodySize, maxImportSize: maxImportSize, trustedKeys: ingestConfig.TrustedKeys}
+}
+
+// GetLogs godoc
+//
+//	@Summary		Get logs of a domain
+//	@Description	Retrieves all logs for the specified domain, newest first by their receive or event time
+//	@Tags			Logging
+//	@Produce		json
+//	@Param			domain			path		string	true	"Domain name"
+//	@Param			group			query		string	false	"Only logs of this group"
+//	@Param			tag				query		string	false	"Only logs with this tag"
+//	@Param			level			query		string	false	"Only logs of this level"
+//	@Param			exception_type	query		string	false	"Only logs with an exception of this type"
+//	@Param			time_field		query		string	false	"The time from, to and the order use"	Enums(received_at, event_time)
+//	@Param			from			query		string	false	"Only logs at or after this time, unix milliseconds or RFC 3339"
+//	@Param			to				query		string	false	"Only logs at or before this time, unix milliseconds or RFC 3339"
+//	@Param			limit			query		int		false	"Maximum amount of logs, all logs when empty"
+//	@Success		200				{array}		JsonLog
+//	@Failure		400				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/log/{domain} [get]
+func (h *LogHandler) GetLogs(c *gin.Context) {
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "0"), 10, 64)
+	if err != nil || limit < 0 {
+		utility.RespondWithError(c, http.StatusBadRequest, "limit must be a positive number")
+		return
+	}
+	filter := FilterFromQuery(c)
+	if err := TimeRangeFromQuery(c, &filter); err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	results, err := h.logRepo.GetLogs(c.Param("domain"), filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// GetErrorGroups godoc
+//
+//	@Summary		Get the error groups of a domain
+//	@Description	Groups the exceptions logged in a domain by their type and innermost stack frames
+//	@Tags			Logging
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Success		200		{array}		ErrorGroup
+//	@Failure		500		{object}	error
+//	@Router			/log/{domain}/errors [get]
+func (h *LogHandler) GetErrorGroups(c *gin.Context) {
+	groups, err := h.logRepo.GetErrorGroups(c.Param("domain"))
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, groups)
+}
+
+// GetLogContext godoc
+//
+//	@Summary		Get the context of a log entry
+//	@Description	Retrieves the entries logged just before and after an entry in the same domain, in timestamp order
+//	@Tags			Logging
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Param			id		path		string	true	"Log entry id"
+//	@Param			before	query		int		false	"Amount of entries before the entry (default 10, max 100)"
+//	@Param			after	query		int		false	"Amount of entries after the entry (default 10, max 100)"
+//	@Param			scope	query		string	false	"Limit the context to the entry's group or tag"	Enums(group, tag)
+//	@Success		200		{object}	LogContext
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/log/{domain}/{id}/context [get]
+func (h *LogHandler) GetLogContext(c *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, "invalid log entry id")
+		return
+	}
+
+	before, err := parseContextSize(c.Query("before"))
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, "before "+err.Error())
+		return
+	}
+	after, err := parseContextSize(c.Query("after"))
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, "after "+err.Error())
+		return
+	}
+
+	anchor, err := h.logRepo.GetLog(c.Param("domain"), id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utility.RespondWithError(c, http.StatusNotFound, "log entry not found")
+		} else {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	anchor.Domain = c.Param("domain")
+
+	scope := bson.M{}
+	switch c.Query("scope") {
+	case "":
+	case "group":
+		scope["group"] = anchor.Group
+	case "tag":
+		scope["tag"] = anchor.Tag
+	default:
+		utility.RespondWithError(c, http.StatusBadRequest, "scope must be either group or tag")
+		return
+	}
+
+	previous, next, err := h.logRepo.GetSurrounding(*anchor, scope, before, after)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, LogContext{Before: previous, Entry: *anchor, After: next})
+}
+
+// TailLogs godoc
+//
+//	@Summary		Tail the logs of a domain
+//	@Description	Streams the entries ingested into a domain from now on as server-sent "log" events, idle streams receive a "ping" event
+//	@Tags			Logging
+//	@Produce		text/event-stream
+//	@Param			domain			path		string	true	"Domain name"
+//	@Param			group			query		string	false	"Only logs of this group"
+//	@Param			tag				query		string	false	"Only logs with this tag"
+//	@Param			level			query		string	false	"Only logs of this level"
+//	@Param			exception_type	query		string	false	"Only logs with an exception of this type"
+//	@Success		200				{object}	JsonLog
+//	@Router			/log/{domain}/tail [get]
+func (h *LogHandler) TailLogs(c *gin.Context) {
+	subscription := h.hub.Subscribe(c.Param("domain"), FilterFromQuery(c))
+	defer h.hub.Unsubscribe(subscription)
+
+	keepAlive := time.NewTicker(tailKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("ping", "")
+	c.Writer.Flush()
+
+	c.Stream(func(_ io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case entry := <-subscription.Entries:
+			c.SSEvent("log", entry)
+		case <-keepAlive.C:
+			c.SSEvent("ping", "")
+		}
+		return true
+	})
+}
+
+// GetSamplingStats godoc
+//
+//	@Summary		Get the sampling statistics
+//	@Description	Retrieves how many entries per domain were kept, sampled out or collapsed as duplicates since startup
+//	@Tags			Logging
+//	@Produce		json
+//	@Success		200	{array}	SamplingStats
+//	@Router			/admin/sampling [get]
+func (h *LogHandler) GetSamplingStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.sampler.Stats())
+}
+
+// ListDomains godoc
+//
+//	@Summary		List domains
+//	@Description	Retrieves a list of all domains with logs
+//	@Tags			Logging
+//	@Produce		json
+//	@Success		200	{array}		Domain
+//	@Failure		500	{object}	error
+//	@Router			/domain/list [get]
+func (h *LogHandler) ListDomains(c *gin.Context) {
+	collections, err := h.logRepo.ListDomains()
+	if err != nil {
+		log.Println("Error: ", err)
+		c.JSON(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	domains := make([]Domain, 0)
+	for _, collection := range h.tenants.Visible(tenancy.CallerFrom(c), collections) {
+		domains = append(domains, Domain{Domain: collection})
+	}
+
+	c.JSON(http.StatusOK, domains)
+}
+
+// CreateLog godoc
+//
+//	@Summary		Post a log
+//	@Description	Queues a new log entry for the domain in the body, entries may be dropped by sampling.
+//	@Description	The body may be JSON, MessagePack or protobuf (featherlog.v1.LogEntry), optionally gzip or zstd compressed.
+//	@Tags			Logging
+//	@Accept			json,application/msgpack,application/x-protobuf
+//	@Produce		json
+//	@Param			log					body		JsonLog	true	"Log entry"
+//	@Param			X-API-Key			header		string	false	"Ingest key"
+//	@Param			Content-Encoding	header		string	false	"gzip or zstd"
+//	@Success		202					{object}	IngestResult
+//	@Failure		400					{object}	error
+//	@Failure		413					{object}	error
+//	@Failure		415					{object}	error
+//	@Failure		422					{object}	error
+//	@Failure		429					{object}	error
+//	@Failure		503					{object}	error
+//	@Router			/log [post]
+func (h *LogHandler) CreateLog(c *gin.Context) {
+	h.ingest(c, false)
+}
+
+// CreateLogs godoc
+//
+//	@Summary		Post a batch of logs
+//	@Description	Queues a batch of log entries, which may belong to different domains, entries may be dropped by sampling.
+//	@Description	The body may be JSON, MessagePack or protobuf (featherlog.v1.LogBatch), optionally gzip or zstd compressed.
+//	@Tags			Logging
+//	@Accept			json,application/msgpack,application/x-protobuf
+//	@Produce		json
+//	@Param			logs				body		[]JsonLog	true	"Log entries"
+//	@Param			X-API-Key			header		string		false	"Ingest key"
+//	@Param			Content-Encoding	header		string		false	"gzip or zstd"
+//	@Success		202					{object}	IngestResult
+//	@Failure		400					{object}	error
+//	@Failure		413					{object}	error
+//	@Failure		415					{object}	error
+//	@Failure		422					{object}	error
+//	@Failure		429					{object}	error
+//	@Failure		503					{object}	error
+//	@Router			/log/batch [post]
+func (h *LogHandler) CreateLogs(c *gin.Context) {
+	h.ingest(c, true)
+}
+
+// ingest decodes the posted entries and passes them to the ingest pipeline
+func (h *LogHandler) ingest(c *gin.Context, batch bool) {
+	entries, err := decodeEntries(c, h.maxBodySize, batch)
+	if err != nil {
+		respondDecodeError(c, err)
+		return
+	}
+	if len(entries) == 0 {
+		utility.RespondWithError(c, http.StatusBadRequest, "no log entries in body")
+		return
+	}
+
+	h.pipeline.enrich(c.Request.Header, entries)
+	result, err := h.pipeline.Ingest(c.GetHeader(IngestKeyHeader), entries)
+	if err != nil {
+		respondIngestError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, result)
+}
+
+// ListSchemas godoc
+//
+//	@Summary		List the domain schemas
+//	@Description	Retrieves the schemas registered for the domains
+//	@Tags			Schemas
+//	@Produce		json
+//	@Success		200	{array}	DomainSchema
+//	@Router			/schema [get]
+func (h *LogHandler) ListSchemas(c *gin.Context) {
+	caller := tenancy.CallerFrom(c)
+	schemas := make([]DomainSchema, 0)
+	for _, schema := range h.schemas.List() {
+		if h.tenants.CanAccess(caller, schema.Domain) {
+			schemas = append(schemas, schema)
+		}
+	}
+	c.JSON(http.StatusOK, schemas)
+}
+
+// GetSchema godoc
+//
+//	@Summary		Get the schema of a domain
+//	@Description	Retrieves the schema registered for a domain
+//	@Tags			Schemas
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Success		200		{object}	DomainSchema
+//	@Failure		404		{object}	error
+//	@Router			/schema/{domain} [get]
+func (h *LogHandler) GetSchema(c *gin.Context) {
+	schema, exists := h.schemas.Get(c.Param("domain"))
+	if !exists {
+		utility.RespondWithError(c, http.StatusNotFound, "domain has no schema")
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// GetFieldsSchema godoc
+//
+//	@Summary		Get the fields JSON Schema of a domain
+//	@Description	Retrieves only the JSON Schema for the fields payload of a domain, so clients can validate locally
+//	@Tags			Schemas
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Success		200		{object}	object
+//	@Failure		404		{object}	error
+//	@Router			/schema/{domain}/fields [get]
+func (h *LogHandler) GetFieldsSchema(c *gin.Context) {
+	schema, exists := h.schemas.Get(c.Param("domain"))
+	if !exists || len(schema.Fields) == 0 {
+		utility.RespondWithError(c, http.StatusNotFound, "domain has no fields schema")
+		return
+	}
+
+	c.Data(http.StatusOK, "application/schema+json", schema.Fields)
+}
+
+// RegisterSchema godoc
+//
+//	@Summary		Register the schema of a domain
+//	@Description	Registers or replaces the schema entries posted to a domain are validated against. In reject mode
+//	@Description	requests with entries that don't match are refused with 422, in tag mode the entries are stored with schema_errors.
+//	@Tags			Schemas
+//	@Accept			json
+//	@Produce		json
+//	@Param			domain	path		string			true	"Domain name"
+//	@Param			schema	body		DomainSchema	true	"Schema of the domain"
+//	@Success		200		{object}	DomainSchema
+//	@Failure		400		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/schema/{domain} [put]
+func (h *LogHandler) RegisterSchema(c *gin.Context) {
+	var definition DomainSchema
+	if err := c.BindJSON(&definition); err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	definition.Domain = c.Param("domain")
+
+	schema, err := h.schemas.Register(definition)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSchema) {
+			utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		} else {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// RemoveSchema godoc
+//
+//	@Summary		Remove the schema of a domain
+//	@Description	Stops validating the entries posted to a domain
+//	@Tags			Schemas
+//	@Param			domain	path		string	true	"Domain name"
+//	@Success		204
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/schema/{domain} [delete]
+func (h *LogHandler) RemoveSchema(c *gin.Context) {
+	if err := h.schemas.Remove(c.Param("domain")); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utility.RespondWithError(c, http.StatusNotFound, "domain has no schema")
+		} else {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// parseContextSize parses the amount of context entries requested, falling back to the default when empty
+func parseContextSize(value string) (int64, error) {
+	if value == "" {
+		return defaultContextSize, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New("must be a positive number")
+	}
+	if size > maxContextSize {
+		return maxContextSize, nil
+	}
+	return size, nil
+}
@@ -0,0 +1,412 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gofeather/internal/tenancy"
+	"gofeather/internal/utility"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultMaxImportSize = 1 << 30
+	importBatchSize      = 1000
+
+	ImportFormatJSON = "json"
+	ImportFormatCSV  = "csv"
+)
+
+// errUntrustedKey is returned when an import is made without a trusted ingest key
+var errUntrustedKey = errors.New("only trusted ingest keys can import logs")
+
+// importEntryError is returned when an entry of an import can't be decoded, Index counts from the first entry
+type importEntryError struct {
+	Index int64
+	Err   error
+}
+
+func (e *importEntryError) Error() string {
+	return fmt.Sprintf("entry %d: %v", e.Index, e.Err)
+}
+
+func (e *importEntryError) Unwrap() error {
+	return e.Err
+}
+
+// ImportResult tells how many entries were read from an import and how many of them were stored,
+// entries whose id was already stored are counted as duplicates
+type ImportResult struct {
+	Read       int64 `json:"read"`
+	Imported   int64 `json:"imported"`
+	Duplicates int64 `json:"duplicates"`
+}
+
+// ImportOptions changes how imported entries are stored
+type ImportOptions struct {
+	// Domain replays the entries into this domain instead of their own
+	Domain string
+	// KeepDuplicates gives every entry a new id, so entries that were stored before are stored again
+	KeepDuplicates bool
+}
+
+// entryReader reads the entries of an import one at a time, returning io.EOF after the last one
+type entryReader func() (JsonLog, error)
+
+// newEntryReader reads JSON, either an array or one document per line like the archive files, or CSV with a header row
+func newEntryReader(body io.Reader, format string) (entryReader, error) {
+	switch format {
+	case ImportFormatCSV:
+		return newCSVReader(body)
+	case ImportFormatJSON:
+		return newJSONReader(body)
+	}
+	return nil, fmt.Errorf("unsupported import format %s, use json or csv", format)
+}
+
+func newJSONReader(body io.Reader) (entryReader, error) {
+	buffered := bufio.NewReader(body)
+	first, err := firstNonSpace(buffered)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	decoder := json.NewDecoder(buffered)
+
+	if first != '[' {
+		return func() (JsonLog, error) {
+			var entry JsonLog
+			err := decoder.Decode(&entry)
+			return entry, err
+		}, nil
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return func() (JsonLog, error) {
+		var entry JsonLog
+		if !decoder.More() {
+			if _, err := decoder.Token(); err != nil {
+				return entry, err
+			}
+			return entry, io.EOF
+		}
+		err := decoder.Decode(&entry)
+		return entry, err
+	}, nil
+}
+
+// firstNonSpace returns the first byte of the body that isn't whitespace without consuming it
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+// csvColumns maps the CSV header names to the entry fields, other columns are stored as string fields
+var csvColumns = []string{"id", "domain", "group", "tag", "level", "log", "timestamp", "event_time", "received_at", "fields", "repeat_count"}
+
+func newCSVReader(body io.Reader) (entryReader, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return func() (JsonLog, error) { return JsonLog{}, io.EOF }, nil
+		}
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\uFEFF")))
+	}
+	if !slices.Contains(header, "log") {
+		return nil, errors.New("CSV header needs a log column")
+	}
+
+	return func() (JsonLog, error) {
+		var entry JsonLog
+		record, err := reader.Read()
+		if err != nil {
+			return entry, err
+		}
+		for i, value := range record {
+			if i >= len(header) || value == "" {
+				continue
+			}
+			if err := setCSVColumn(&entry, header[i], value); err != nil {
+				return entry, err
+			}
+		}
+		return entry, nil
+	}, nil
+}
+
+func setCSVColumn(entry *JsonLog, column string, value string) error {
+	var err error
+	switch column {
+	case "id":
+		entry.ID, err = primitive.ObjectIDFromHex(value)
+	case "domain":
+		entry.Domain = value
+	case "group":
+		entry.Group = value
+	case "tag":
+		entry.Tag = value
+	case "level":
+		entry.Level = value
+	case "log":
+		entry.Log = value
+	case "timestamp":
+		entry.Timestamp, err = utility.ParseTime(value, 0)
+	case "event_time":
+		entry.EventTime, err = utility.ParseTime(value, 0)
+	case "received_at":
+		entry.ReceivedAt, err = utility.ParseTime(value, 0)
+	case "fields":
+		var fields map[string]interface{}
+		err = json.Unmarshal([]byte(value), &fields)
+		for key, fieldValue := range fields {
+			setField(entry, key, fieldValue)
+		}
+	case "repeat_count":
+		entry.RepeatCount, err = strconv.ParseInt(value, 10, 64)
+	default:
+		setField(entry, column, value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", column, err)
+	}
+	return nil
+}
+
+func setField(entry *JsonLog, key string, value interface{}) {
+	if entry.Fields == nil {
+		entry.Fields = make(map[string]interface{})
+	}
+	entry.Fields[key] = value
+}
+
+// importFormat returns the format of an import from the format query parameter or else the Content-Type header
+func importFormat(c *gin.Context) string {
+	if format := c.Query("format"); format != "" {
+		return strings.ToLower(format)
+	}
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == "text/csv" {
+		return ImportFormatCSV
+	}
+	return ImportFormatJSON
+}
+
+// ImportLogs godoc
+//
+//	@Summary		Import exported logs
+//	@Description	Stores exported entries with their original timestamps, only for the ingest keys listed in ingest.trusted_keys.
+//	@Description	The body is a JSON array, newline delimited JSON like the archive files or CSV with a header row, optionally gzip or zstd compressed.
+//	@Description	Entries keep their id, so entries that are already stored are skipped and a failed import can be retried.
+//	@Description	Imported entries are not rate limited, sampled, tailed or forwarded. The import stops at the first invalid entry.
+//	@Tags			Logging
+//	@Accept			json
+//	@Accept			text/csv
+//	@Produce		json
+//	@Param			X-API-Key		header		string	true	"Trusted ingest key"
+//	@Param			domain			query		string	false	"Replay the entries into this domain instead of their own"
+//	@Param			format			query		string	false	"json or csv, defaults to csv for text/csv bodies and json otherwise"
+//	@Param			keep_duplicates	query		bool	false	"Give the entries new ids, storing them again when they were imported before"
+//	@Success		200				{object}	ImportResult
+//	@Failure		400				{object}	error
+//	@Failure		403				{object}	error
+//	@Failure		413				{object}	error
+//	@Failure		422				{object}	error
+//	@Failure		500				{object}	error
+//	@Router			/log/import [post]
+func (h *LogHandler) ImportLogs(c *gin.Context) {
+	key := c.GetHeader(IngestKeyHeader)
+	if key == "" || !slices.Contains(h.trustedKeys, key) {
+		utility.RespondWithError(c, http.StatusForbidden, errUntrustedKey.Error())
+		return
+	}
+
+	body, closeBody, err := decompressBody(c, h.maxImportSize)
+	if err != nil {
+		respondDecodeError(c, err)
+		return
+	}
+	defer closeBody()
+	limited := &limitedReader{reader: body, remaining: h.maxImportSize}
+
+	next, err := newEntryReader(limited, importFormat(c))
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	options := ImportOptions{Domain: c.Query("domain"), KeepDuplicates: c.Query("keep_duplicates") == "true"}
+	result := &ImportResult{}
+	batch := make([]JsonLog, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := h.importBatch(key, batch, options, result)
+		batch = batch[:0]
+		return err
+	}
+
+	for {
+		entry, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if limited.exceeded() {
+				respondImportError(c, errBodyTooLarge, result)
+			} else {
+				respondImportError(c, &importEntryError{Index: result.Read + int64(len(batch)), Err: err}, result)
+			}
+			return
+		}
+
+		batch = append(batch, entry)
+		if len(batch) >= importBatchSize {
+			if err := flush(); err != nil {
+				respondImportError(c, err, result)
+				return
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		respondImportError(c, err, result)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// importBatch stores a batch of imported entries per domain, adding the counts to the result
+func (h *LogHandler) importBatch(key string, batch []JsonLog, options ImportOptions, result *ImportResult) error {
+	offset := result.Read
+	for i := range batch {
+		if options.Domain != "" {
+			batch[i].Domain = options.Domain
+		}
+		if options.KeepDuplicates {
+			batch[i].ID = primitive.NilObjectID
+		}
+	}
+
+	if err := h.pipeline.authorizeImport(key, batch); err != nil {
+		var schemaErr *SchemaError
+		if errors.As(err, &schemaErr) {
+			for i := range schemaErr.Violations {
+				schemaErr.Violations[i].Index += int(offset)
+			}
+		}
+		return err
+	}
+	result.Read += int64(len(batch))
+
+	perDomain := make(map[string][]JsonLog)
+	for _, entry := range batch {
+		prepareImportedEntry(&entry)
+		perDomain[entry.Domain] = append(perDomain[entry.Domain], entry)
+	}
+	for domain, entries := range perDomain {
+		inserted, err := h.logRepo.ImportLogs(domain, entries)
+		result.Imported += inserted
+		if err != nil {
+			return err
+		}
+		result.Duplicates += int64(len(entries)) - inserted
+	}
+	return nil
+}
+
+// prepareImportedEntry assigns an id to an entry without one and parses its stack trace like prepareEntry,
+// but keeps the original timestamp as the receive time. Entries without a timestamp or received_at are
+// timestamped now, entries without an event time get their receive time.
+func prepareImportedEntry(entry *JsonLog) {
+	if entry.ID.IsZero() {
+		entry.ID = primitive.NewObjectID()
+	}
+	if entry.Timestamp == 0 {
+		entry.Timestamp = entry.ReceivedAt
+	}
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().UTC().UnixMilli()
+	}
+	entry.ReceivedAt = entry.Timestamp
+	if entry.EventTime == 0 {
+		entry.EventTime = entry.Timestamp
+	}
+	if entry.Exception == nil {
+		entry.Exception = parseException(entry.Log)
+	} else {
+		entry.Exception.Fingerprint = entry.Exception.fingerprint()
+	}
+}
+
+// respondImportError responds with the error and the counts of the entries imported before it
+func respondImportError(c *gin.Context, err error, result *ImportResult) {
+	response := gin.H{"error": err.Error(), "read": result.Read, "imported": result.Imported, "duplicates": result.Duplicates}
+
+	var schemaErr *SchemaError
+	var entryErr *importEntryError
+	status := http.StatusInternalServerError
+	switch {
+	case errors.As(err, &schemaErr):
+		response["violations"] = schemaErr.Violations
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, errBodyTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.As(err, &entryErr), errors.Is(err, ErrMissingDomain), errors.Is(err, utility.ErrInvalidDomain):
+		status = http.StatusBadRequest
+	case errors.Is(err, tenancy.ErrUnknownKey):
+		status = http.StatusUnauthorized
+	case errors.Is(err, tenancy.ErrDomainNotAllowed):
+		status = http.StatusForbidden
+	}
+	c.JSON(status, response)
+}
+
+// limitedReader fails reads past the remaining amount of bytes, unlike io.LimitReader which ends the body silently
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+func (r *limitedReader) exceeded() bool {
+	return r.remaining < 0
+}
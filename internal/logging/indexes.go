@@ -0,0 +1,422 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gofeather/internal/utility"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// structuredFieldPrefix is the key the structured fields of an entry are stored under
+	structuredFieldPrefix = "fields."
+
+	// indexBuildTimeout bounds building the default indexes, which takes long on a large existing domain
+	indexBuildTimeout = time.Hour
+	// indexRetryInterval is how long a domain of which the default indexes failed to build waits for another attempt
+	indexRetryInterval = 10 * time.Minute
+	// indexQueueSize is the amount of domains waiting for their default indexes, others are picked up on a later write
+	indexQueueSize = 1000
+)
+
+var (
+	// ErrIndexNotFound is returned when a domain has no index with the given name
+	ErrIndexNotFound = errors.New("index not found")
+	// ErrInvalidIndex is returned for an index that can't be created or dropped through the admin routes
+	ErrInvalidIndex = errors.New("invalid index")
+)
+
+// defaultIndexes are created on every domain, covering the filters and orders of the log queries
+var defaultIndexes = []bson.D{
+	{{Key: "timestamp", Value: -1}},
+	{{Key: "group", Value: 1}, {Key: "timestamp", Value: -1}},
+	{{Key: "tag", Value: 1}, {Key: "timestamp", Value: -1}},
+	{{Key: "level", Value: 1}},
+	{{Key: TimeFieldEvent, Value: -1}},
+}
+
+// DomainIndex describes an index of a domain with its usage since the MongoDB server started
+type DomainIndex struct {
+	Name string     `json:"name"`
+	Keys []IndexKey `json:"keys"`
+	// Default is set for the _id index and the indexes GoFeather creates on every domain, which can't be dropped
+	Default bool `json:"default"`
+	// Ops counts the queries that used the index since Since, in unix milliseconds
+	Ops   int64 `json:"ops"`
+	Since int64 `json:"since"`
+	// Size is the size of the index in bytes
+	Size int64 `json:"size"`
+}
+
+// IndexKey is a field of an index, Order is 1 for ascending and -1 for descending
+type IndexKey struct {
+	Field string `json:"field"`
+	Order int    `json:"order"`
+}
+
+// IndexRequest adds an index on structured fields to a domain. The fields are named without the fields. prefix,
+// the receive time is appended to the index so the matching entries can be sorted by it.
+type IndexRequest struct {
+	Fields []string `json:"fields" binding:"required"`
+}
+
+type IndexRepository interface {
+	EnsureDefaultIndexes(domain string) error
+	GetIndexes(domain string) ([]DomainIndex, error)
+	CreateIndex(domain string, keys bson.D) (string, error)
+	DropIndex(domain string, name string) error
+}
+
+// EnsureDefaultIndexes creates the default indexes of a domain, indexes that exist already are left alone.
+// Building them may take long on a large domain, so it's bounded by indexBuildTimeout instead of the query timeout.
+func (r *MongoLogRepository) EnsureDefaultIndexes(domain string) error {
+	models := make([]mongo.IndexModel, 0, len(defaultIndexes))
+	for _, keys := range defaultIndexes {
+		models = append(models, mongo.IndexModel{Keys: keys})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), indexBuildTimeout)
+	defer cancel()
+	_, err := r.database.Collection(domain).Indexes().CreateMany(ctx, models)
+	return err
+}
+
+// ensureIndexes has the default indexes built in the background the first time this repository writes to a domain,
+// so writes never wait for a build. A domain of which the build failed is tried again after indexRetryInterval.
+func (r *MongoLogRepository) ensureIndexes(domain string) {
+	if _, done := r.indexed.Load(domain); done {
+		return
+	}
+	now := time.Now()
+	if attempted, exists := r.indexing.Load(domain); exists && now.Sub(attempted.(time.Time)) < indexRetryInterval {
+		return
+	}
+	r.indexing.Store(domain, now)
+	select {
+	case r.indexQueue <- domain:
+	default:
+		r.indexing.Delete(domain)
+	}
+}
+
+// buildIndexes builds the default indexes of the queued domains one at a time
+func (r *MongoLogRepository) buildIndexes() {
+	for domain := range r.indexQueue {
+		if _, done := r.indexed.Load(domain); done {
+			continue
+		}
+		if err := r.EnsureDefaultIndexes(domain); err != nil {
+			log.Printf("Unable to create the indexes of %s: %v", domain, err)
+			r.indexing.Store(domain, time.Now())
+			continue
+		}
+		r.indexed.Store(domain, struct{}{})
+		r.indexing.Delete(domain)
+	}
+}
+
+// indexExistingDomains queues the default indexes of the domains stored before they were introduced
+func (r *MongoLogRepository) indexExistingDomains() {
+	domains, err := r.ListDomains()
+	if err != nil {
+		log.Printf("Unable to list the domains to index: %v", err)
+		return
+	}
+	for _, domain := range domains {
+		r.ensureIndexes(domain)
+	}
+}
+
+// GetIndexes returns the indexes of a domain with their usage statistics and size
+func (r *MongoLogRepository) GetIndexes(domain string) ([]DomainIndex, error) {
+	coll := r.database.Collection(domain)
+	var specifications []struct {
+		Name string `bson:"name"`
+		Key  bson.D `bson:"key"`
+	}
+	var stats []struct {
+		Name     string `bson:"name"`
+		Accesses struct {
+			Ops   int64     `bson:"ops"`
+			Since time.Time `bson:"since"`
+		} `bson:"accesses"`
+	}
+	var storage []struct {
+		StorageStats struct {
+			IndexSizes map[string]int64 `bson:"indexSizes"`
+		} `bson:"storageStats"`
+	}
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		cur, err := coll.Indexes().List(ctx)
+		if err != nil {
+			return err
+		}
+		if err = cur.All(ctx, &specifications); err != nil {
+			return err
+		}
+
+		cur, err = coll.Aggregate(ctx, mongo.Pipeline{{{Key: "$indexStats", Value: bson.M{}}}})
+		if err != nil {
+			return err
+		}
+		if err = cur.All(ctx, &stats); err != nil {
+			return err
+		}
+
+		cur, err = coll.Aggregate(ctx, mongo.Pipeline{{{Key: "$collStats", Value: bson.M{"storageStats": bson.M{}}}}})
+		if err != nil {
+			return err
+		}
+		return cur.All(ctx, &storage)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]DomainIndex, 0, len(specifications))
+	for _, specification := range specifications {
+		index := DomainIndex{Name: specification.Name, Keys: make([]IndexKey, 0, len(specification.Key))}
+		for _, key := range specification.Key {
+			index.Keys = append(index.Keys, IndexKey{Field: key.Key, Order: indexOrder(key.Value)})
+		}
+		index.Default = index.Name == "_id_" || isDefaultIndex(specification.Key)
+		for _, stat := range stats {
+			if stat.Name == index.Name {
+				index.Ops = stat.Accesses.Ops
+				index.Since = stat.Accesses.Since.UnixMilli()
+			}
+		}
+		if len(storage) > 0 {
+			index.Size = storage[0].StorageStats.IndexSizes[index.Name]
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// CreateIndex adds an index to a domain and returns its name
+func (r *MongoLogRepository) CreateIndex(domain string, keys bson.D) (string, error) {
+	var name string
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		var createErr error
+		name, createErr = r.database.Collection(domain).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys})
+		return createErr
+	})
+	return name, err
+}
+
+// DropIndex removes an index from a domain
+func (r *MongoLogRepository) DropIndex(domain string, name string) error {
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := r.database.Collection(domain).Indexes().DropOne(ctx, name, options.DropIndexes())
+		return err
+	})
+}
+
+// indexOrder converts the direction of an index key, special indexes such as text indexes are reported as 0
+func indexOrder(value interface{}) int {
+	switch order := value.(type) {
+	case int:
+		return order
+	case int32:
+		return int(order)
+	case int64:
+		return int(order)
+	case float64:
+		return int(order)
+	}
+	return 0
+}
+
+func isDefaultIndex(keys bson.D) bool {
+	return slices.ContainsFunc(defaultIndexes, func(defaultKeys bson.D) bool {
+		if len(defaultKeys) != len(keys) {
+			return false
+		}
+		for i, key := range defaultKeys {
+			if key.Key != keys[i].Key || indexOrder(key.Value) != indexOrder(keys[i].Value) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// structuredIndexKeys converts the fields of an index request to the keys of the index
+func structuredIndexKeys(fields []string) (bson.D, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%w: an index needs at least one field", ErrInvalidIndex)
+	}
+	keys := make(bson.D, 0, len(fields)+1)
+	for _, field := range fields {
+		field = strings.TrimPrefix(field, structuredFieldPrefix)
+		if field == "" || strings.HasPrefix(field, "$") || strings.HasPrefix(field, ".") || strings.HasSuffix(field, ".") {
+			return nil, fmt.Errorf("%w: %q is not a valid field name", ErrInvalidIndex, field)
+		}
+		keys = append(keys, bson.E{Key: structuredFieldPrefix + field, Value: 1})
+	}
+	return append(keys, bson.E{Key: "timestamp", Value: -1}), nil
+}
+
+// isStructuredIndex checks that an index was created on structured fields, so dropping it leaves the default indexes alone
+func isStructuredIndex(index DomainIndex) bool {
+	if index.Default {
+		return false
+	}
+	for _, key := range index.Keys {
+		if key.Field != "timestamp" && !strings.HasPrefix(key.Field, structuredFieldPrefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// domainExists checks if a domain holds logs, internal collections don't count as domains
+func (h *LogHandler) domainExists(domain string) (bool, error) {
+	domains, err := h.logRepo.ListDomains()
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(domains, domain), nil
+}
+
+// GetIndexes godoc
+//
+//	@Summary		Get the indexes of a domain
+//	@Description	Lists the indexes of a domain with the queries that used them since the MongoDB server started and their size
+//	@Tags			Indexes
+//	@Produce		json
+//	@Param			domain	path		string	true	"Domain name"
+//	@Success		200		{array}		DomainIndex
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/admin/indexes/{domain} [get]
+func (h *LogHandler) GetIndexes(c *gin.Context) {
+	domain := c.Param("domain")
+	exists, err := h.domainExists(domain)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		utility.RespondWithError(c, http.StatusNotFound, "domain not found")
+		return
+	}
+
+	indexes, err := h.logRepo.GetIndexes(domain)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, indexes)
+}
+
+// CreateIndex godoc
+//
+//	@Summary		Add an index to a domain
+//	@Description	Indexes structured fields of a domain, followed by the receive time so the matching entries are sorted by it
+//	@Tags			Indexes
+//	@Accept			json
+//	@Produce		json
+//	@Param			domain	path		string			true	"Domain name"
+//	@Param			index	body		IndexRequest	true	"Structured fields to index"
+//	@Success		201		{object}	DomainIndex
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/admin/indexes/{domain} [post]
+func (h *LogHandler) CreateIndex(c *gin.Context) {
+	var request IndexRequest
+	if err := c.BindJSON(&request); err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	keys, err := structuredIndexKeys(request.Fields)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	domain := c.Param("domain")
+	exists, err := h.domainExists(domain)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		utility.RespondWithError(c, http.StatusNotFound, "domain not found")
+		return
+	}
+
+	name, err := h.logRepo.CreateIndex(domain, keys)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	index := DomainIndex{Name: name, Keys: make([]IndexKey, 0, len(keys))}
+	for _, key := range keys {
+		index.Keys = append(index.Keys, IndexKey{Field: key.Key, Order: indexOrder(key.Value)})
+	}
+	c.JSON(http.StatusCreated, index)
+}
+
+// DropIndex godoc
+//
+//	@Summary		Drop an index of a domain
+//	@Description	Drops an index on structured fields, the default indexes of a domain can't be dropped
+//	@Tags			Indexes
+//	@Param			domain	path		string	true	"Domain name"
+//	@Param			name	path		string	true	"Index name"
+//	@Success		204
+//	@Failure		400	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/admin/indexes/{domain}/{name} [delete]
+func (h *LogHandler) DropIndex(c *gin.Context) {
+	domain := c.Param("domain")
+	exists, err := h.domainExists(domain)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !exists {
+		utility.RespondWithError(c, http.StatusNotFound, "domain not found")
+		return
+	}
+
+	indexes, err := h.logRepo.GetIndexes(domain)
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	position := slices.IndexFunc(indexes, func(index DomainIndex) bool {
+		return index.Name == c.Param("name")
+	})
+	if position < 0 {
+		utility.RespondWithError(c, http.StatusNotFound, ErrIndexNotFound.Error())
+		return
+	}
+	if !isStructuredIndex(indexes[position]) {
+		utility.RespondWithError(c, http.StatusBadRequest, ErrInvalidIndex.Error()+": only indexes on structured fields can be dropped")
+		return
+	}
+
+	if err := h.logRepo.DropIndex(domain, indexes[position].Name); err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,211 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/metrics"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	counterMetric   = "counter"
+	histogramMetric = "histogram"
+
+	defaultMaxSeries = 1000
+	// maxLabelValueLength truncates long field values used as labels
+	maxLabelValueLength = 128
+	// overflowLabelValue replaces the values of field labels once a metric reached its maximum series
+	overflowLabelValue = "other"
+)
+
+// LogMetricDefinition describes a metric derived from the logs of a domain.
+// Counters count the entries matching the filter, histograms observe a numeric structured field of them.
+// Labels read from fields can take any value, so once MaxSeries label combinations are seen the values of new
+// combinations are replaced by "other".
+type LogMetricDefinition struct {
+	Name      string    `mapstructure:"name"`
+	Help      string    `mapstructure:"help"`
+	Type      string    `mapstructure:"type"`
+	Domain    string    `mapstructure:"domain"`
+	Filter    LogFilter `mapstructure:"filter"`
+	Field     string    `mapstructure:"field"`
+	Buckets   []float64 `mapstructure:"buckets"`
+	Labels    []string  `mapstructure:"labels"`
+	MaxSeries int       `mapstructure:"max_series"`
+}
+
+type logMetric struct {
+	definition LogMetricDefinition
+	counter    *metrics.Counter
+	histogram  *metrics.Histogram
+
+	mu     sync.Mutex
+	series map[string]struct{}
+}
+
+// LogMetrics keeps the log derived metrics up to date as entries are ingested
+type LogMetrics struct {
+	metrics []*logMetric
+}
+
+// NewLogMetrics registers the metrics of the definitions in the registry
+func NewLogMetrics(registry *metrics.Registry, definitions []LogMetricDefinition) (*LogMetrics, error) {
+	logMetrics := &LogMetrics{metrics: make([]*logMetric, 0, len(definitions))}
+
+	for _, definition := range definitions {
+		metric := &logMetric{definition: definition, series: make(map[string]struct{})}
+		if metric.definition.MaxSeries <= 0 {
+			metric.definition.MaxSeries = defaultMaxSeries
+		}
+		var err error
+		switch definition.Type {
+		case counterMetric:
+			metric.counter, err = registry.NewCounter(definition.Name, definition.Help, definition.Labels...)
+		case histogramMetric:
+			if definition.Field == "" {
+				return nil, fmt.Errorf("histogram %s needs a field to observe", definition.Name)
+			}
+			metric.histogram, err = registry.NewHistogram(definition.Name, definition.Help, definition.Buckets, definition.Labels...)
+		default:
+			return nil, fmt.Errorf("unknown metric type for %s: %s", definition.Name, definition.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		logMetrics.metrics = append(logMetrics.metrics, metric)
+	}
+
+	return logMetrics, nil
+}
+
+// loadLogMetrics reads the log metric definitions from the configuration file
+func loadLogMetrics() *LogMetrics {
+	var definitions []LogMetricDefinition
+	if config.Exists(constants.LogMetrics) {
+		if err := config.BindStruct(constants.LogMetrics, &definitions); err != nil {
+			log.Printf("Unable to read log metrics from config: %v", err)
+		}
+	}
+
+	logMetrics, err := NewLogMetrics(metrics.DefaultRegistry, definitions)
+	if err != nil {
+		log.Printf("Unable to create log metrics: %v", err)
+		return &LogMetrics{}
+	}
+	return logMetrics
+}
+
+// observe updates every metric whose domain and filter match the entry
+func (m *LogMetrics) observe(entry JsonLog) {
+	for _, metric := range m.metrics {
+		if metric.definition.Domain != "" && metric.definition.Domain != entry.Domain {
+			continue
+		}
+		if !metric.definition.Filter.Matches(entry) {
+			continue
+		}
+
+		labelValues := metric.labelValues(entry)
+
+		if metric.counter != nil {
+			metric.counter.Inc(labelValues...)
+			continue
+		}
+		if value, ok := numericValue(entry.Fields[metric.definition.Field]); ok {
+			metric.histogram.Observe(value, labelValues...)
+		}
+	}
+}
+
+// labelValues returns the label values of an entry, the values of field labels are replaced once the metric
+// has its maximum of series
+func (m *logMetric) labelValues(entry JsonLog) []string {
+	labelValues := make([]string, len(m.definition.Labels))
+	fieldLabels := make([]int, 0, len(m.definition.Labels))
+	for i, label := range m.definition.Labels {
+		var fromField bool
+		if labelValues[i], fromField = labelValue(entry, label); fromField {
+			fieldLabels = append(fieldLabels, i)
+		}
+	}
+	if len(fieldLabels) == 0 {
+		return labelValues
+	}
+
+	key := strings.Join(labelValues, "\x00")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, seen := m.series[key]; seen {
+		return labelValues
+	}
+	if len(m.series) < m.definition.MaxSeries {
+		m.series[key] = struct{}{}
+		return labelValues
+	}
+	for _, i := range fieldLabels {
+		labelValues[i] = overflowLabelValue
+	}
+	return labelValues
+}
+
+// labelValue returns the value of a label for an entry, labels other than domain, group and tag are read from the
+// fields, which is told by the second value. Long field values are truncated.
+func labelValue(entry JsonLog, label string) (string, bool) {
+	switch label {
+	case "domain":
+		return entry.Domain, false
+	case "group":
+		return entry.Group, false
+	case "tag":
+		return entry.Tag, false
+	}
+	if value, exists := entry.Fields[label]; exists && value != nil {
+		text := fmt.Sprint(value)
+		if len(text) > maxLabelValueLength {
+			text = strings.ToValidUTF8(text[:maxLabelValueLength], "")
+		}
+		return text, true
+	}
+	return "", true
+}
+
+// numericValue converts a structured field to a number, durations like "13ms" are converted to seconds
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case string:
+		if number, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return number, true
+		}
+		if duration, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+			return duration.Seconds(), true
+		}
+	}
+	return 0, false
+}
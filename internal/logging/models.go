@@ -0,0 +1,46 @@
+package logging
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type JsonLog struct {
+	ID          primitive.ObjectID     `json:"id" bson:"_id,omitempty"`
+	Domain      string                 `json:"domain"`
+	Group       string                 `json:"group"`
+	Tag         string                 `json:"tag"`
+	Level       string                 `json:"level,omitempty" bson:"level,omitempty"`
+	Log         string                 `json:"log"`
+	Timestamp   int64                  `json:"timestamp"`
+	Exception   *Exception             `json:"exception,omitempty" bson:"exception,omitempty"`
+	Fields      map[string]interface{} `json:"fields,omitempty" bson:"fields,omitempty"`
+	RepeatCount int64                  `json:"repeat_count,omitempty" bson:"repeat_count,omitempty"`
+	// SchemaErrors lists why the entry doesn't match the schema of its domain, when that schema is in tag mode
+	SchemaErrors []string `json:"schema_errors,omitempty" bson:"schema_errors,omitempty"`
+	// EventTime is when the client logged the entry and ReceivedAt when the server received it, Timestamp holds
+	// the receive time as well for the clients and queries using it. Entries stored before these were kept lack them.
+	EventTime  int64 `json:"event_time,omitempty" bson:"event_time,omitempty"`
+	ReceivedAt int64 `json:"received_at,omitempty" bson:"received_at,omitempty"`
+	// ClockSkew is how far the event time given by the client was off when it was replaced by the receive time
+	ClockSkew int64 `json:"clock_skew,omitempty" bson:"clock_skew,omitempty"`
+}
+
+type Domain struct {
+	Domain string `json:"domain"`
+}
+
+// LogContext holds the entries surrounding an anchor entry, all in ascending timestamp order
+type LogContext struct {
+	Before []JsonLog `json:"before"`
+	Entry  JsonLog   `json:"entry"`
+	After  []JsonLog `json:"after"`
+}
+
+// ErrorGroup groups the exceptions of a domain sharing the same fingerprint
+type ErrorGroup struct {
+	Fingerprint string       `json:"fingerprint" bson:"_id"`
+	Type        string       `json:"type" bson:"type"`
+	Message     string       `json:"message" bson:"message"`
+	Frames      []StackFrame `json:"frames" bson:"frames"`
+	Count       int64        `json:"count" bson:"count"`
+	FirstSeen   int64        `json:"first_seen" bson:"first_seen"`
+	LastSeen    int64        `json:"last_seen" bson:"last_seen"`
+}
@@ -0,0 +1,315 @@
+package logging
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gofeather/internal/metrics"
+	"gofeather/internal/ratelimit"
+	"gofeather/internal/tenancy"
+	"gofeather/internal/utility"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxPastSkew   = 24 * time.Hour
+	defaultMaxFutureSkew = 5 * time.Minute
+)
+
+// ErrMissingDomain is returned when an ingested entry has no domain to be stored in
+var ErrMissingDomain = errors.New("log entry needs a domain")
+
+// RateLimitError is returned when ingesting entries would exceed the limits of their domain or ingest key
+type RateLimitError struct {
+	Decision ratelimit.Decision
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Decision.Reason
+}
+
+// IngestResult tells which entries were queued for storage and how many were dropped by sampling
+type IngestResult struct {
+	Queued  []primitive.ObjectID `json:"queued"`
+	Dropped int                  `json:"dropped"`
+}
+
+// Forwarder passes stored entries on to another system, Forward is called by the ingest queue once the entries
+// are inserted and must not block
+type Forwarder interface {
+	Forward(entries []JsonLog)
+}
+
+// Observer sees every accepted entry before sampling, like the log metrics do. Observe is called during ingest
+// and must not block.
+type Observer interface {
+	Observe(entries []JsonLog)
+}
+
+// Pipeline is the single ingest path for log entries, whatever the protocol they were received with
+type Pipeline struct {
+	limiter    *ratelimit.Limiter
+	logMetrics *LogMetrics
+	sampler    *Sampler
+	queue      *IngestQueue
+	hub        *TailHub
+	schemas    *SchemaRegistry
+	tenants    *tenancy.Registry
+	extractor  *Extractor
+	enricher   *Enricher
+	clientInfo *ClientInfo
+	// maxPastSkew and maxFutureSkew bound how far an event time may lie before or after the receive time
+	maxPastSkew   time.Duration
+	maxFutureSkew time.Duration
+	skewed        *metrics.Counter
+
+	forwardersMu sync.RWMutex
+	forwarders   []Forwarder
+	observers    []Observer
+}
+
+func NewPipeline(limiter *ratelimit.Limiter, logMetrics *LogMetrics, sampler *Sampler, queue *IngestQueue, hub *TailHub, schemas *SchemaRegistry, tenants *tenancy.Registry, extractor *Extractor, enricher *Enricher, clientInfo *ClientInfo, ingestConfig IngestConfig) *Pipeline {
+	pipeline := &Pipeline{limiter: limiter, logMetrics: logMetrics, sampler: sampler, queue: queue, hub: hub, schemas: schemas, tenants: tenants, extractor: extractor, enricher: enricher, clientInfo: clientInfo,
+		maxPastSkew:   utility.DurationOrDefault(ingestConfig.MaxPastSkew, defaultMaxPastSkew),
+		maxFutureSkew: utility.DurationOrDefault(ingestConfig.MaxFutureSkew, defaultMaxFutureSkew),
+	}
+
+	skewed, err := metrics.DefaultRegistry.NewCounter("gofeather_ingest_clock_skewed_entries_total",
+		"Log entries of which the event time was replaced by the receive time for being outside the allowed clock skew", "domain")
+	if err != nil {
+		log.Printf("Unable to register ingest metric: %v", err)
+	}
+	pipeline.skewed = skewed
+	queue.onStored(pipeline.forward)
+	return pipeline
+}
+
+// Ingest extracts fields from the messages of the entries and adds the client info, validates the entries,
+// checks the rate limits, prepares and samples the entries and queues the ones to be stored.
+// The key is the ingest key of the client and may be empty, unless tenancy is on and the key must belong to
+// the tenant owning the domains of the entries.
+func (p *Pipeline) Ingest(key string, entries []JsonLog) (*IngestResult, error) {
+	perDomain := make(map[string]int)
+	for _, entry := range entries {
+		if err := checkDomain(entry.Domain); err != nil {
+			return nil, err
+		}
+		perDomain[entry.Domain]++
+	}
+
+	domains := make([]string, 0, len(perDomain))
+	for domain := range perDomain {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	if err := p.tenants.AuthorizeIngest(key, domains); err != nil {
+		return nil, err
+	}
+	//Fields are extracted before validation, so the schemas see them
+	for i := range entries {
+		p.extractor.extract(&entries[i])
+		p.clientInfo.process(&entries[i])
+	}
+	if err := p.validate(entries); err != nil {
+		return nil, err
+	}
+
+	//Room in the queue is reserved before anything is counted, so a request refused for a full queue
+	//leaves no trace in the rate limits, metrics and sampler and is taken as a new request when retried
+	if err := p.queue.reserve(len(entries)); err != nil {
+		return nil, err
+	}
+	if decision := p.limiter.Allow(key, perDomain); !decision.Allowed {
+		p.queue.release(len(entries))
+		return nil, &RateLimitError{Decision: decision}
+	}
+	//New domains are only claimed by requests that are accepted
+	if err := p.tenants.ClaimDomains(key, domains); err != nil {
+		p.queue.release(len(entries))
+		return nil, err
+	}
+
+	result := &IngestResult{Queued: make([]primitive.ObjectID, 0, len(entries))}
+	store := make([]JsonLog, 0, len(entries))
+	observed := make([]JsonLog, 0, len(entries))
+	var rescued []JsonLog
+	received := time.Now().UTC().UnixMilli()
+	for _, entry := range entries {
+		prepareEntry(&entry, received)
+		if !p.checkEventTime(&entry) {
+			p.skewed.Inc(entry.Domain)
+		}
+
+		//Metrics are derived from every received entry, so sampling doesn't skew them
+		p.logMetrics.observe(entry)
+		observed = append(observed, entry)
+
+		keep, held := p.sampler.sample(&entry)
+		rescued = append(rescued, held...)
+		if !keep {
+			result.Dropped++
+			continue
+		}
+		store = append(store, entry)
+		result.Queued = append(result.Queued, entry.ID)
+	}
+
+	//The entries of the request always fit in the reserved room, entries rescued from a tail window come last
+	//and are dropped when the queue has no room left for them
+	store = append(store, rescued...)
+	if lost := p.queue.enqueueReserved(len(entries), store); lost > 0 {
+		p.sampler.dropRescued(store[len(store)-lost:])
+		store = store[:len(store)-lost]
+	}
+	p.hub.Publish(store)
+	p.observe(observed)
+	return result, nil
+}
+
+// observe passes the accepted entries to the observers
+func (p *Pipeline) observe(entries []JsonLog) {
+	p.forwardersMu.RLock()
+	defer p.forwardersMu.RUnlock()
+	for _, observer := range p.observers {
+		observer.Observe(entries)
+	}
+}
+
+// forward passes stored entries to the forwarders
+func (p *Pipeline) forward(entries []JsonLog) {
+	p.forwardersMu.RLock()
+	defer p.forwardersMu.RUnlock()
+	for _, forwarder := range p.forwarders {
+		forwarder.Forward(entries)
+	}
+}
+
+// enrich adds the metadata of the source of the entries, read from the headers of the request, before they are
+// ingested. It runs before Ingest since the label rules may set the domain of the entries.
+func (p *Pipeline) enrich(header http.Header, entries []JsonLog) {
+	p.enricher.enrich(header, entries)
+}
+
+// authorizeImport checks imported entries like Ingest does, without applying rate limits
+func (p *Pipeline) authorizeImport(key string, entries []JsonLog) error {
+	domains := make([]string, 0)
+	for _, entry := range entries {
+		if err := checkDomain(entry.Domain); err != nil {
+			return err
+		}
+		if !slices.Contains(domains, entry.Domain) {
+			domains = append(domains, entry.Domain)
+		}
+	}
+	if err := p.tenants.AuthorizeIngest(key, domains); err != nil {
+		return err
+	}
+	if err := p.validate(entries); err != nil {
+		return err
+	}
+	return p.tenants.ClaimDomains(key, domains)
+}
+
+// AddForwarder passes the entries stored from now on to the forwarder as well
+func (p *Pipeline) AddForwarder(forwarder Forwarder) {
+	p.forwardersMu.Lock()
+	defer p.forwardersMu.Unlock()
+	p.forwarders = append(p.forwarders, forwarder)
+}
+
+// AddObserver passes the entries accepted from now on to the observer, including those dropped by sampling
+func (p *Pipeline) AddObserver(observer Observer) {
+	p.forwardersMu.Lock()
+	defer p.forwardersMu.Unlock()
+	p.observers = append(p.observers, observer)
+}
+
+// validate checks the entries against the schemas of their domains. Violations of a schema in reject mode refuse
+// the whole request, so clients can fix and resend it, those of a schema in tag mode are stored with the entry.
+func (p *Pipeline) validate(entries []JsonLog) error {
+	var violations []SchemaViolation
+	for i := range entries {
+		mode, errs := p.schemas.validate(entries[i])
+		if len(errs) == 0 {
+			continue
+		}
+		if mode == SchemaModeTag {
+			entries[i].SchemaErrors = errs
+			continue
+		}
+		violations = append(violations, SchemaViolation{Index: i, Domain: entries[i].Domain, Errors: errs})
+	}
+
+	if len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
+	return nil
+}
+
+// checkDomain makes sure entries name a domain they can be stored in
+func checkDomain(domain string) error {
+	if domain == "" {
+		return ErrMissingDomain
+	}
+	return utility.ValidateDomain(domain)
+}
+
+// checkEventTime replaces an event time outside the allowed clock skew with the receive time, keeping how far
+// it was off in the clock skew of the entry. It returns false when the event time was replaced.
+func (p *Pipeline) checkEventTime(entry *JsonLog) bool {
+	skew := entry.EventTime - entry.ReceivedAt
+	if -skew <= p.maxPastSkew.Milliseconds() && skew <= p.maxFutureSkew.Milliseconds() {
+		return true
+	}
+	entry.EventTime = entry.ReceivedAt
+	entry.ClockSkew = skew
+	return false
+}
+
+// prepareEntry assigns the id and the receive time of an entry and parses its stack trace. The event time given by
+// the client, either as event_time or as timestamp, is kept and falls back to the receive time.
+func prepareEntry(entry *JsonLog, received int64) {
+	entry.ID = primitive.NewObjectID()
+	if entry.EventTime == 0 {
+		entry.EventTime = entry.Timestamp
+	}
+	if entry.EventTime == 0 {
+		entry.EventTime = received
+	}
+	entry.Timestamp = received
+	entry.ReceivedAt = received
+	entry.ClockSkew = 0
+	if entry.Exception == nil {
+		entry.Exception = parseException(entry.Log)
+	} else {
+		entry.Exception.Fingerprint = entry.Exception.fingerprint()
+	}
+}
+
+// respondIngestError maps the errors of the ingest pipeline to a response
+func respondIngestError(c *gin.Context, err error) {
+	var rateLimitErr *RateLimitError
+	var schemaErr *SchemaError
+	switch {
+	case errors.As(err, &schemaErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "violations": schemaErr.Violations})
+	case errors.As(err, &rateLimitErr):
+		ratelimit.RespondRateLimited(c, rateLimitErr.Decision)
+	case errors.Is(err, ErrQueueFull):
+		c.Header("Retry-After", "1")
+		utility.RespondWithError(c, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, ErrMissingDomain), errors.Is(err, utility.ErrInvalidDomain):
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, tenancy.ErrUnknownKey):
+		utility.RespondWithError(c, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, tenancy.ErrDomainNotAllowed):
+		utility.RespondWithError(c, http.StatusForbidden, err.Error())
+	default:
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+	}
+}
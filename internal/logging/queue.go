@@ -0,0 +1,420 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/metrics"
+	"gofeather/internal/utility"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	spoolExtension = ".ndjson"
+	// corruptExtension is added to spool files that can't be decoded
+	corruptExtension = ".corrupt"
+)
+
+// ErrQueueFull is returned when the ingest queue can't hold the entries, the client should retry later
+var ErrQueueFull = errors.New("ingest queue is full")
+
+// ErrQueueClosed is returned once the queue stopped taking entries during shutdown, like a full queue it tells the
+// client to retry later
+var ErrQueueClosed = fmt.Errorf("%w: the server is shutting down", ErrQueueFull)
+
+var errCorruptSpoolFile = errors.New("corrupt spool file")
+
+// IngestConfig configures the ingest queue, its workers and the spool used while the store is down
+type IngestConfig struct {
+	QueueSize          int    `mapstructure:"queue_size"`
+	Workers            int    `mapstructure:"workers"`
+	BatchSize          int    `mapstructure:"batch_size"`
+	FlushInterval      string `mapstructure:"flush_interval"`
+	SpoolPath          string `mapstructure:"spool_path"`
+	SpoolRetryInterval string `mapstructure:"spool_retry_interval"`
+	MaxBodySize        int64  `mapstructure:"max_body_size"`
+	// MaxImportSize limits the decompressed body of an import, TrustedKeys are the ingest keys allowed to import
+	MaxImportSize int64    `mapstructure:"max_import_size"`
+	TrustedKeys   []string `mapstructure:"trusted_keys"`
+	// MaxPastSkew and MaxFutureSkew are how far the event time of an entry may lie before or after its receive time,
+	// event times outside this window are replaced by the receive time
+	MaxPastSkew   string `mapstructure:"max_past_skew"`
+	MaxFutureSkew string `mapstructure:"max_future_skew"`
+}
+
+// IngestQueue buffers ingested entries in memory and writes them to the repository in batches.
+// Batches that fail to insert are written to a spool directory on disk and replayed once the store is back.
+type IngestQueue struct {
+	mu                 sync.Mutex
+	spoolMu            sync.Mutex
+	logRepo            LogRepository
+	queue              chan JsonLog
+	batchSize          int
+	flushInterval      time.Duration
+	spoolPath          string
+	spoolRetryInterval time.Duration
+	depth              *metrics.Gauge
+	flushDuration      *metrics.Histogram
+	spooled            *metrics.Counter
+	// reserved is the room in the queue promised to requests that are being ingested
+	reserved int
+	// stored is called with the entries of every batch once it is inserted
+	stored atomic.Pointer[func([]JsonLog)]
+	// closed refuses new entries, stop tells the workers to insert what is left and return
+	closed  bool
+	stop    chan struct{}
+	workers sync.WaitGroup
+}
+
+// NewIngestQueue creates the queue and starts its workers and the spool replayer
+func NewIngestQueue(logRepo LogRepository, ingestConfig IngestConfig) *IngestQueue {
+	ingestQueue := &IngestQueue{
+		logRepo:            logRepo,
+		queue:              make(chan JsonLog, utility.ValueOrDefault(ingestConfig.QueueSize, 10000)),
+		batchSize:          utility.ValueOrDefault(ingestConfig.BatchSize, 500),
+		flushInterval:      utility.DurationOrDefault(ingestConfig.FlushInterval, time.Second),
+		spoolPath:          ingestConfig.SpoolPath,
+		spoolRetryInterval: utility.DurationOrDefault(ingestConfig.SpoolRetryInterval, 10*time.Second),
+		stop:               make(chan struct{}),
+	}
+
+	var err error
+	if ingestQueue.depth, err = metrics.DefaultRegistry.NewGauge("gofeather_ingest_queue_depth",
+		"Log entries waiting in the ingest queue"); err != nil {
+		log.Printf("Unable to register ingest metric: %v", err)
+	}
+	if ingestQueue.flushDuration, err = metrics.DefaultRegistry.NewHistogram("gofeather_ingest_flush_duration_seconds",
+		"Time taken to insert a batch of log entries", nil); err != nil {
+		log.Printf("Unable to register ingest metric: %v", err)
+	}
+	if ingestQueue.spooled, err = metrics.DefaultRegistry.NewCounter("gofeather_ingest_spooled_entries_total",
+		"Log entries written to the spool because they could not be stored"); err != nil {
+		log.Printf("Unable to register ingest metric: %v", err)
+	}
+
+	if ingestQueue.spoolPath != "" {
+		if err := os.MkdirAll(ingestQueue.spoolPath, 0o755); err != nil {
+			log.Printf("Unable to create spool directory, failed batches will be lost: %v", err)
+			ingestQueue.spoolPath = ""
+		}
+	}
+
+	for i := 0; i < utility.ValueOrDefault(ingestConfig.Workers, 4); i++ {
+		ingestQueue.workers.Add(1)
+		go ingestQueue.work()
+	}
+	if ingestQueue.spoolPath != "" {
+		go ingestQueue.replaySpool()
+	}
+
+	return ingestQueue
+}
+
+// loadIngestConfig reads the ingest queue settings from the configuration file
+func loadIngestConfig() IngestConfig {
+	var ingestConfig IngestConfig
+	if config.Exists(constants.Ingest) {
+		if err := config.BindStruct(constants.Ingest, &ingestConfig); err != nil {
+			log.Printf("Unable to read ingest settings from config: %v", err)
+		}
+	}
+	return ingestConfig
+}
+
+// Enqueue adds all entries to the queue, or none of them with ErrQueueFull when they don't fit
+func (q *IngestQueue) Enqueue(entries ...JsonLog) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if len(q.queue)+q.reserved+len(entries) > cap(q.queue) {
+		return ErrQueueFull
+	}
+	q.push(entries)
+	return nil
+}
+
+// reserve holds room for a number of entries, so they can be queued once they're processed.
+// The room is given back by enqueueReserved or release.
+func (q *IngestQueue) reserve(count int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if len(q.queue)+q.reserved+count > cap(q.queue) {
+		return ErrQueueFull
+	}
+	q.reserved += count
+	return nil
+}
+
+func (q *IngestQueue) release(count int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reserved -= count
+}
+
+// enqueueReserved adds the entries into the reserved room, entries beyond it are only added while there is room
+// left. It returns the amount of entries that didn't fit.
+func (q *IngestQueue) enqueueReserved(reserved int, entries []JsonLog) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.reserved -= reserved
+	fit := min(len(entries), cap(q.queue)-len(q.queue)-q.reserved)
+	q.push(entries[:fit])
+	return len(entries) - fit
+}
+
+func (q *IngestQueue) push(entries []JsonLog) {
+	for _, entry := range entries {
+		q.queue <- entry
+	}
+	q.depth.Set(float64(len(q.queue)))
+}
+
+// onStored passes the entries of every batch to handle once they are inserted, including replayed ones
+func (q *IngestQueue) onStored(handle func([]JsonLog)) {
+	q.stored.Store(&handle)
+}
+
+func (q *IngestQueue) notifyStored(entries []JsonLog) {
+	if handle := q.stored.Load(); handle != nil {
+		(*handle)(entries)
+	}
+}
+
+// Close stops taking entries and waits for the requests that reserved room to queue their entries. The workers then
+// insert what is left in the queue, spooling the batches that fail, and return. Entries still queued when the context
+// ends are spooled without trying to insert them.
+func (q *IngestQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for q.hasReservations() && ctx.Err() == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+	close(q.stop)
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	left := q.drain(len(q.queue))
+	if len(left) > 0 && q.spoolPath != "" {
+		if err := q.spool(left); err != nil {
+			return fmt.Errorf("%d queued log entries are lost: %w", len(left), err)
+		}
+		q.spooled.Add(float64(len(left)))
+		left = nil
+	}
+	if len(left) > 0 {
+		return fmt.Errorf("%d queued log entries are lost: %w", len(left), ctx.Err())
+	}
+	return ctx.Err()
+}
+
+func (q *IngestQueue) hasReservations() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.reserved > 0
+}
+
+// drain takes up to limit entries from the queue without waiting for more
+func (q *IngestQueue) drain(limit int) []JsonLog {
+	entries := make([]JsonLog, 0, limit)
+	for len(entries) < limit {
+		select {
+		case entry := <-q.queue:
+			entries = append(entries, entry)
+		default:
+			return entries
+		}
+	}
+	return entries
+}
+
+// work collects entries from the queue into batches, flushing them when full or when the flush interval passed.
+// Once the queue is closed it flushes the entries left in the queue and returns.
+func (q *IngestQueue) work() {
+	defer q.workers.Done()
+	ticker := time.NewTicker(q.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]JsonLog, 0, q.batchSize)
+	for {
+		select {
+		case entry := <-q.queue:
+			batch = append(batch, entry)
+			if len(batch) >= q.batchSize {
+				q.flush(batch)
+				batch = make([]JsonLog, 0, q.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				q.flush(batch)
+				batch = make([]JsonLog, 0, q.batchSize)
+			}
+		case <-q.stop:
+			batch = append(batch, q.drain(q.batchSize-len(batch))...)
+			for len(batch) > 0 {
+				q.flush(batch)
+				batch = q.drain(q.batchSize)
+			}
+			return
+		}
+	}
+}
+
+func (q *IngestQueue) flush(batch []JsonLog) {
+	q.depth.Set(float64(len(q.queue)))
+
+	start := time.Now()
+	err := q.logRepo.InsertLogs(batch)
+	q.flushDuration.Observe(time.Since(start).Seconds())
+	if err == nil {
+		q.notifyStored(batch)
+		return
+	}
+
+	log.Printf("Failed to insert a batch of %d log entries: %v", len(batch), err)
+	if q.spoolPath == "" {
+		return
+	}
+	if spoolErr := q.spool(batch); spoolErr != nil {
+		log.Printf("Failed to spool %d log entries, they are lost: %v", len(batch), spoolErr)
+		return
+	}
+	q.spooled.Add(float64(len(batch)))
+}
+
+// spool writes a batch to a new file in the spool directory, one JSON entry per line. The file is written under
+// a temporary name first, so the replay never picks up a partially written file.
+func (q *IngestQueue) spool(batch []JsonLog) error {
+	q.spoolMu.Lock()
+	defer q.spoolMu.Unlock()
+
+	name := filepath.Join(q.spoolPath, fmt.Sprintf("%d%s", time.Now().UnixNano(), spoolExtension))
+	temporary := name + ".tmp"
+	file, err := os.OpenFile(temporary, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := writeSpoolFile(file, batch); err != nil {
+		_ = file.Close()
+		_ = os.Remove(temporary)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(temporary)
+		return err
+	}
+	return os.Rename(temporary, name)
+}
+
+func writeSpoolFile(file *os.File, batch []JsonLog) error {
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range batch {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// replaySpool periodically inserts the spooled batches oldest first, stopping at the first failure to insert.
+// Files that can't be read are moved aside, so they don't hold up the files after them.
+func (q *IngestQueue) replaySpool() {
+	ticker := time.NewTicker(q.spoolRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.stop:
+			return
+		}
+
+		files, err := filepath.Glob(filepath.Join(q.spoolPath, "*"+spoolExtension))
+		if err != nil {
+			log.Printf("Unable to list spool directory: %v", err)
+			continue
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			err := q.replayFile(file)
+			if errors.Is(err, errCorruptSpoolFile) {
+				log.Printf("Moving unreadable spool file %s aside: %v", file, err)
+				if renameErr := os.Rename(file, file+corruptExtension); renameErr != nil {
+					log.Printf("Unable to move spool file %s aside: %v", file, renameErr)
+				}
+				continue
+			}
+			if err != nil {
+				log.Printf("Unable to replay spooled log entries from %s: %v", file, err)
+				break
+			}
+		}
+	}
+}
+
+func (q *IngestQueue) replayFile(name string) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]JsonLog, 0)
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var entry JsonLog
+		if err := decoder.Decode(&entry); err != nil {
+			_ = file.Close()
+			return fmt.Errorf("%w: %v", errCorruptSpoolFile, err)
+		}
+		batch = append(batch, entry)
+	}
+	_ = file.Close()
+
+	//Entries of a partially replayed file already exist, those duplicates are fine to skip
+	if err := q.logRepo.InsertLogs(batch); err != nil && !utility.OnlyDuplicateKeys(err) {
+		return err
+	}
+
+	log.Printf("Replayed %d spooled log entries", len(batch))
+	q.notifyStored(batch)
+	return os.Remove(name)
+}
@@ -0,0 +1,276 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gofeather/internal/utility"
+	"sync"
+)
+
+type LogRepository interface {
+	GetLogs(domain string, filter LogFilter, limit int64) ([]JsonLog, error)
+	GetErrorGroups(domain string) ([]ErrorGroup, error)
+	GetLog(domain string, id primitive.ObjectID) (*JsonLog, error)
+	GetSurrounding(anchor JsonLog, scope bson.M, before int64, after int64) ([]JsonLog, []JsonLog, error)
+	GetMessages(domain string, filter LogFilter, limit int64) ([]JsonLog, error)
+	ListDomains() ([]string, error)
+	InsertLogs(entries []JsonLog) error
+	ImportLogs(domain string, entries []JsonLog) (int64, error)
+	IncrementRepeatCount(domain string, id interface{}, count int64) error
+	IndexRepository
+}
+
+type MongoLogRepository struct {
+	database *mongo.Database
+	// indexed holds the domains of which the default indexes were created, indexing the time the build of the others
+	// was last queued or failed, indexQueue the domains waiting for a build
+	indexed    sync.Map
+	indexing   sync.Map
+	indexQueue chan string
+}
+
+func NewMongoLogRepository(database *mongo.Database) *MongoLogRepository {
+	repo := &MongoLogRepository{database: database, indexQueue: make(chan string, indexQueueSize)}
+	go repo.buildIndexes()
+	return repo
+}
+
+// GetLogs returns the entries of a domain matching the filter newest first by the time field of the filter,
+// a limit of zero returns all of them
+func (r *MongoLogRepository) GetLogs(domain string, filter LogFilter, limit int64) ([]JsonLog, error) {
+	var results []JsonLog
+	coll := r.database.Collection(domain)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		pipeline := TimeSortPipeline(filter.TimeField, filter.toBSON(), -1, limit)
+		cur, aggregateErr := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+		if aggregateErr != nil {
+			return aggregateErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+// GetMessages returns the entries of a domain matching the filter with only their message, times and repeat count, newest first
+// by the time field of the filter. A limit of zero returns all of them.
+func (r *MongoLogRepository) GetMessages(domain string, filter LogFilter, limit int64) ([]JsonLog, error) {
+	var results []JsonLog
+	coll := r.database.Collection(domain)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		projection := bson.D{{Key: "$project", Value: bson.M{"log": 1, "timestamp": 1, TimeFieldEvent: 1, "repeat_count": 1}}}
+		pipeline := TimeSortPipeline(filter.TimeField, filter.toBSON(), -1, limit, projection)
+		cur, aggregateErr := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+		if aggregateErr != nil {
+			return aggregateErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+// GetErrorGroups groups the exceptions logged in a domain by their fingerprint, most frequent first
+func (r *MongoLogRepository) GetErrorGroups(domain string) ([]ErrorGroup, error) {
+	groups := make([]ErrorGroup, 0)
+	coll := r.database.Collection(domain)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"exception.fingerprint": bson.M{"$exists": true}}}},
+		{{Key: "$sort", Value: bson.M{"timestamp": -1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        "$exception.fingerprint",
+			"type":       bson.M{"$first": "$exception.type"},
+			"message":    bson.M{"$first": "$exception.message"},
+			"frames":     bson.M{"$first": "$exception.frames"},
+			"count":      bson.M{"$sum": 1},
+			"first_seen": bson.M{"$min": "$timestamp"},
+			"last_seen":  bson.M{"$max": "$timestamp"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "last_seen", Value: -1}}}},
+	}
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		cur, aggregateErr := coll.Aggregate(ctx, pipeline)
+		if aggregateErr != nil {
+			return aggregateErr
+		}
+		return cur.All(ctx, &groups)
+	})
+
+	return groups, err
+}
+
+func (r *MongoLogRepository) GetLog(domain string, id primitive.ObjectID) (*JsonLog, error) {
+	var entry JsonLog
+	coll := r.database.Collection(domain)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		return coll.FindOne(ctx, bson.M{"_id": id}).Decode(&entry)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// GetSurrounding returns up to before entries logged just before the anchor and up to after entries logged
+// just after it, both in ascending timestamp order. Entries sharing the anchor's timestamp are ordered by id.
+// The scope filter is applied on top, so the context can be narrowed down to a group or tag.
+func (r *MongoLogRepository) GetSurrounding(anchor JsonLog, scope bson.M, before int64, after int64) ([]JsonLog, []JsonLog, error) {
+	coll := r.database.Collection(anchor.Domain)
+	previous := make([]JsonLog, 0)
+	next := make([]JsonLog, 0)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		if before > 0 {
+			filter := scopedFilter(scope, bson.M{"$or": bson.A{
+				bson.M{"timestamp": bson.M{"$lt": anchor.Timestamp}},
+				bson.M{"timestamp": anchor.Timestamp, "_id": bson.M{"$lt": anchor.ID}},
+			}})
+			opts := options.Find().
+				SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+				SetLimit(before)
+			cur, findErr := coll.Find(ctx, filter, opts)
+			if findErr != nil {
+				return findErr
+			}
+			if findErr = cur.All(ctx, &previous); findErr != nil {
+				return findErr
+			}
+		}
+
+		if after > 0 {
+			filter := scopedFilter(scope, bson.M{"$or": bson.A{
+				bson.M{"timestamp": bson.M{"$gt": anchor.Timestamp}},
+				bson.M{"timestamp": anchor.Timestamp, "_id": bson.M{"$gt": anchor.ID}},
+			}})
+			opts := options.Find().
+				SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}}).
+				SetLimit(after)
+			cur, findErr := coll.Find(ctx, filter, opts)
+			if findErr != nil {
+				return findErr
+			}
+			if findErr = cur.All(ctx, &next); findErr != nil {
+				return findErr
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	//The entries before the anchor were fetched newest first
+	for i, j := 0, len(previous)-1; i < j; i, j = i+1, j-1 {
+		previous[i], previous[j] = previous[j], previous[i]
+	}
+
+	return previous, next, nil
+}
+
+// ListDomains returns the collections holding logs, collections starting with an underscore are internal to GoFeather
+func (r *MongoLogRepository) ListDomains() ([]string, error) {
+	var collections []string
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		var listErr error
+		filter := bson.M{"name": bson.M{"$not": primitive.Regex{Pattern: "^_"}}}
+		collections, listErr = r.database.ListCollectionNames(ctx, filter)
+		return listErr
+	})
+
+	return collections, err
+}
+
+// InsertLogs inserts entries that may belong to different domains, grouped per domain collection
+func (r *MongoLogRepository) InsertLogs(entries []JsonLog) error {
+	perDomain := make(map[string][]interface{})
+	for _, entry := range entries {
+		perDomain[entry.Domain] = append(perDomain[entry.Domain], entry)
+	}
+	for domain := range perDomain {
+		r.ensureIndexes(domain)
+	}
+
+	//Every domain is inserted even when another one fails, the errors of all domains are returned together
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		var failed []error
+		for domain, documents := range perDomain {
+			opts := options.InsertMany().SetOrdered(false)
+			if _, err := r.database.Collection(domain).InsertMany(ctx, documents, opts); err != nil {
+				failed = append(failed, fmt.Errorf("%s: %w", domain, err))
+			}
+		}
+		return errors.Join(failed...)
+	})
+}
+
+// ImportLogs inserts entries into a domain with the ids they already have, entries whose id is present are skipped.
+// It returns the amount of entries inserted.
+func (r *MongoLogRepository) ImportLogs(domain string, entries []JsonLog) (int64, error) {
+	r.ensureIndexes(domain)
+	coll := r.database.Collection(domain)
+	documents := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		documents = append(documents, entry)
+	}
+
+	var inserted int64
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		//The inserted ids of the result hold the ids of every document, so the failed writes are counted from the error
+		_, insertErr := coll.InsertMany(ctx, documents, options.InsertMany().SetOrdered(false))
+		if insertErr == nil {
+			inserted = int64(len(documents))
+			return nil
+		}
+
+		var bulkErr mongo.BulkWriteException
+		if errors.As(insertErr, &bulkErr) {
+			inserted = int64(len(documents) - len(bulkErr.WriteErrors))
+		}
+		if utility.OnlyDuplicateKeys(insertErr) {
+			return nil
+		}
+		return insertErr
+	})
+	return inserted, err
+}
+
+// IncrementRepeatCount adds the amount of collapsed duplicates to the repeat count of an entry, it returns
+// mongo.ErrNoDocuments while the entry isn't inserted yet
+func (r *MongoLogRepository) IncrementRepeatCount(domain string, id interface{}, count int64) error {
+	coll := r.database.Collection(domain)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		result, err := coll.UpdateByID(ctx, id, bson.M{"$inc": bson.M{"repeat_count": count}})
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return nil
+	})
+}
+
+// scopedFilter combines the scope filter with an additional condition
+func scopedFilter(scope bson.M, condition bson.M) bson.M {
+	filter := bson.M{}
+	for key, value := range scope {
+		filter[key] = value
+	}
+	for key, value := range condition {
+		filter[key] = value
+	}
+	return filter
+}
@@ -3,90 +3,68 @@ package logging
 import (
 	"context"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-	"gofeather/internal/utility"
-	"log"
-	"net/http"
-	"time"
+	"gofeather/internal/ratelimit"
+	"gofeather/internal/tenancy"
 )
 
-type JsonLog struct {
-	Domain    string `json:"domain"`
-	Group     string `json:"group"`
-	Tag       string `json:"tag"`
-	Log       string `json:"log"`
-	Timestamp int64  `json:"timestamp"`
-}
+// Components holds the shared parts of the logging feature, used by the REST routes and the other listeners
+type Components struct {
+	LogRepo  LogRepository
+	Pipeline *Pipeline
+	Hub      *TailHub
+	Tenants  *tenancy.Registry
 
-type Domain struct {
-	Domain string `json:"domain"`
+	queue   *IngestQueue
+	sampler *Sampler
 }
 
-func CreateRoutes(engine *gin.Engine, database *mongo.Database) {
-	//Gets all logs for a domain
-	engine.GET("/log/:domain", func(c *gin.Context) {
-		var results []JsonLog
-		domain := c.Param("domain")
-		coll := database.Collection(domain)
-
-		err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
-			opts := options.Find().SetSort(bson.D{{"timestamp", -1}})
-			cur, findErr := coll.Find(ctx, bson.D{{}}, opts)
-			if findErr != nil {
-				return findErr
-			}
-			return cur.All(ctx, &results)
-		})
-
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, err.Error())
-			return
-		}
+// CreateRoutes registers the logging routes, with a tenant registry the queries are scoped to the caller's tenant
+// and the admin routes are reserved for super admins. Without one every caller sees every domain.
+func CreateRoutes(engine *gin.Engine, database *mongo.Database, tenants *tenancy.Registry) *Components {
+	logRepo := NewMongoLogRepository(database)
+	go logRepo.indexExistingDomains()
+	limiter := ratelimit.NewLimiter(ratelimit.LoadConfig())
+	sampler := NewSampler(logRepo, loadSamplingConfig())
+	ingestConfig := loadIngestConfig()
+	queue := NewIngestQueue(logRepo, ingestConfig)
+	hub := NewTailHub()
+	schemas := NewSchemaRegistry(NewMongoSchemaRepository(database))
+	pipeline := NewPipeline(limiter, loadLogMetrics(), sampler, queue, hub, schemas, tenants, NewExtractor(loadExtractionConfig()),
+		NewEnricher(loadEnrichmentConfig()), NewClientInfo(loadClientInfoConfig()), ingestConfig)
+	logHandler := NewLogHandler(logRepo, pipeline, sampler, hub, schemas, tenants, ingestConfig)
 
-		c.JSON(http.StatusOK, results)
-	})
+	admin := engine.Group("", tenants.Authenticate(), tenants.RequireSuperAdmin())
+	ratelimit.CreateRoutes(admin, limiter)
+	admin.GET("/admin/sampling", logHandler.GetSamplingStats)
+	admin.GET("/admin/indexes/:domain", logHandler.GetIndexes)
+	admin.POST("/admin/indexes/:domain", logHandler.CreateIndex)
+	admin.DELETE("/admin/indexes/:domain/:name", logHandler.DropIndex)
 
-	//Gets a list of domains
-	engine.GET("/domain/list", func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		collections, err := database.ListCollectionNames(ctx, bson.D{{}})
-		if err != nil {
-			log.Println("Error: ", err)
-			c.JSON(http.StatusInternalServerError, err.Error())
-			return
-		}
+	engine.POST("/log", logHandler.CreateLog)
+	engine.POST("/log/batch", logHandler.CreateLogs)
+	engine.POST("/log/import", logHandler.ImportLogs)
 
-		domains := make([]Domain, 0)
-		for _, collection := range collections {
-			domains = append(domains, Domain{Domain: collection})
-		}
+	scoped := engine.Group("", tenants.Authenticate())
+	scoped.GET("/log/:domain", tenants.RequireDomain(), logHandler.GetLogs)
+	scoped.GET("/log/:domain/errors", tenants.RequireDomain(), logHandler.GetErrorGroups)
+	scoped.GET("/log/:domain/diff", tenants.RequireDomain(), logHandler.GetLogDiff)
+	scoped.GET("/log/:domain/tail", tenants.RequireDomain(), logHandler.TailLogs)
+	scoped.GET("/log/:domain/:id/context", tenants.RequireDomain(), logHandler.GetLogContext)
+	scoped.GET("/domain/list", logHandler.ListDomains)
+	scoped.GET("/schema", logHandler.ListSchemas)
+	scoped.GET("/schema/:domain", tenants.RequireDomain(), logHandler.GetSchema)
+	scoped.GET("/schema/:domain/fields", tenants.RequireDomain(), logHandler.GetFieldsSchema)
+	scoped.PUT("/schema/:domain", tenants.RequireDomain(), logHandler.RegisterSchema)
+	scoped.DELETE("/schema/:domain", tenants.RequireDomain(), logHandler.RemoveSchema)
 
-		c.JSON(http.StatusOK, domains)
-	})
-
-	//Posts a new log to a domain
-	engine.POST("/log", func(c *gin.Context) {
-		var logEntry JsonLog
-		if err := c.BindJSON(&logEntry); err != nil {
-			c.JSON(http.StatusInternalServerError, err.Error())
-			return
-		}
-		logEntry.Timestamp = time.Now().UTC().UnixMilli()
-
-		coll := database.Collection(logEntry.Domain)
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		result, err := coll.InsertOne(ctx, logEntry)
-		if err != nil {
-			log.Println("Failed to insert logEntry:", err)
-			c.JSON(http.StatusInternalServerError, err.Error())
-			return
-		}
+	return &Components{LogRepo: logRepo, Pipeline: pipeline, Hub: hub, Tenants: tenants, queue: queue, sampler: sampler}
+}
 
-		log.Printf("Log inserted %s\n", logEntry.Log)
-		c.JSON(http.StatusOK, result)
-	})
+// Shutdown stores the entries that were accepted but not inserted yet and then the repeat counts of the entries
+// collapsing duplicates, it's called once the REST and gRPC servers stopped taking requests
+func (c *Components) Shutdown(ctx context.Context) error {
+	err := c.queue.Close(ctx)
+	c.sampler.Close()
+	return err
 }
@@ -0,0 +1,392 @@
+package logging
+
+import (
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"gofeather/internal/metrics"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dropReasonSampled    = "sampled"
+	dropReasonDuplicate  = "duplicate"
+	samplingFlushPeriod  = time.Second
+	defaultTailBufferLen = 100
+	// repeatCountRetention is how long the repeat count of a closed dedup window is retried, the entry it belongs to
+	// may still be queued or spooled when the window closes
+	repeatCountRetention = time.Hour
+)
+
+// errorLevels are never sampled out
+var errorLevels = map[string]struct{}{"error": {}, "fatal": {}, "critical": {}, "panic": {}}
+
+// SamplingRule keeps a part of the entries matching its domain, group, tag and level, empty values match anything.
+// Either one in KeepOneIn entries is kept, or KeepPercent percent of them picked at random, a rule with
+// neither drops all matching entries. Entries at an error level or with an exception are always kept.
+// With a TailWindow the dropped entries are held back for that duration, and kept after all
+// when an error is logged in the same domain and group within that window.
+type SamplingRule struct {
+	Domain      string  `json:"domain" mapstructure:"domain"`
+	Group       string  `json:"group" mapstructure:"group"`
+	Tag         string  `json:"tag" mapstructure:"tag"`
+	Level       string  `json:"level" mapstructure:"level"`
+	KeepOneIn   uint64  `json:"keep_one_in" mapstructure:"keep_one_in"`
+	KeepPercent float64 `json:"keep_percent" mapstructure:"keep_percent"`
+	TailWindow  string  `json:"tail_window" mapstructure:"tail_window"`
+	TailBuffer  int     `json:"tail_buffer" mapstructure:"tail_buffer"`
+}
+
+// SamplingConfig holds the sampling rules and the window in which identical messages are collapsed
+type SamplingConfig struct {
+	DedupWindow string         `mapstructure:"dedup_window"`
+	Rules       []SamplingRule `mapstructure:"rules"`
+}
+
+// SamplingStats counts what happened to the entries of a domain since startup
+type SamplingStats struct {
+	Domain       string `json:"domain"`
+	Received     int64  `json:"received"`
+	Kept         int64  `json:"kept"`
+	Sampled      int64  `json:"sampled"`
+	Deduplicated int64  `json:"deduplicated"`
+	Rescued      int64  `json:"rescued"`
+}
+
+type samplingRule struct {
+	SamplingRule
+	tailWindow time.Duration
+	seen       uint64
+}
+
+type dedupEntry struct {
+	domain      string
+	id          interface{}
+	windowEnd   time.Time
+	repeatCount int64
+}
+
+// retryUntil is the time after which the repeat count of a closed window is given up
+func (e *dedupEntry) retryUntil() time.Time {
+	return e.windowEnd.Add(repeatCountRetention)
+}
+
+type tailEntry struct {
+	entry   JsonLog
+	expires time.Time
+}
+
+// Sampler decides which ingested entries are stored
+type Sampler struct {
+	mu          sync.Mutex
+	logRepo     LogRepository
+	rules       []*samplingRule
+	dedupWindow time.Duration
+	dedup       map[string]*dedupEntry
+	tail        map[string][]tailEntry
+	stats       map[string]*SamplingStats
+	dropped     *metrics.Counter
+	now         func() time.Time
+	stop        chan struct{}
+	// pending holds the repeat counts of closed windows that couldn't be written yet
+	pending []*dedupEntry
+}
+
+// NewSampler creates a sampler and starts flushing the repeat counts of collapsed messages to the repository
+func NewSampler(logRepo LogRepository, samplingConfig SamplingConfig) *Sampler {
+	sampler := &Sampler{
+		logRepo: logRepo,
+		rules:   make([]*samplingRule, 0, len(samplingConfig.Rules)),
+		dedup:   make(map[string]*dedupEntry),
+		tail:    make(map[string][]tailEntry),
+		stats:   make(map[string]*SamplingStats),
+		now:     time.Now,
+		stop:    make(chan struct{}),
+	}
+
+	if samplingConfig.DedupWindow != "" {
+		window, err := time.ParseDuration(samplingConfig.DedupWindow)
+		if err != nil {
+			log.Printf("Invalid sampling dedup window %s: %v", samplingConfig.DedupWindow, err)
+		}
+		sampler.dedupWindow = window
+	}
+
+	for _, rule := range samplingConfig.Rules {
+		compiled := &samplingRule{SamplingRule: rule}
+		if rule.TailWindow != "" {
+			window, err := time.ParseDuration(rule.TailWindow)
+			if err != nil {
+				log.Printf("Invalid sampling tail window %s: %v", rule.TailWindow, err)
+			}
+			compiled.tailWindow = window
+		}
+		if compiled.TailBuffer <= 0 {
+			compiled.TailBuffer = defaultTailBufferLen
+		}
+		sampler.rules = append(sampler.rules, compiled)
+	}
+
+	dropped, err := metrics.DefaultRegistry.NewCounter("gofeather_log_entries_dropped_total",
+		"Log entries dropped by sampling or collapsed as duplicates", "domain", "reason")
+	if err != nil {
+		log.Printf("Unable to register the sampling metric: %v", err)
+	}
+	sampler.dropped = dropped
+
+	go sampler.run()
+	return sampler
+}
+
+// loadSamplingConfig reads the sampling rules from the configuration file
+func loadSamplingConfig() SamplingConfig {
+	var samplingConfig SamplingConfig
+	if config.Exists(constants.Sampling) {
+		if err := config.BindStruct(constants.Sampling, &samplingConfig); err != nil {
+			log.Printf("Unable to read sampling rules from config: %v", err)
+		}
+	}
+	return samplingConfig
+}
+
+// sample decides whether an entry is stored. Along with the decision it returns entries that were held
+// back by a tail window and should be stored after all, because the entry is an error in their group.
+func (s *Sampler) sample(entry *JsonLog) (bool, []JsonLog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	stats := s.statsFor(entry.Domain)
+	stats.Received++
+
+	if s.dedupWindow > 0 {
+		key := dedupKey(*entry)
+		if existing, exists := s.dedup[key]; exists && now.Before(existing.windowEnd) {
+			existing.repeatCount++
+			stats.Deduplicated++
+			s.countDropped(entry.Domain, dropReasonDuplicate)
+			return false, nil
+		}
+	}
+
+	if isError(*entry) {
+		s.remember(entry, now)
+		stats.Kept++
+		rescued := s.tail[tailKey(*entry)]
+		delete(s.tail, tailKey(*entry))
+		kept := make([]JsonLog, 0, len(rescued))
+		for _, held := range rescued {
+			if now.Before(held.expires) {
+				kept = append(kept, held.entry)
+			} else {
+				s.dropHeld(held.entry)
+			}
+		}
+		stats.Rescued += int64(len(kept))
+		stats.Kept += int64(len(kept))
+		return true, kept
+	}
+
+	rule := s.ruleFor(*entry)
+	if rule == nil || rule.keep() {
+		s.remember(entry, now)
+		stats.Kept++
+		return true, nil
+	}
+
+	//Held entries are counted once their fate is known, as sampled when they expire or as kept when rescued
+	if rule.tailWindow > 0 {
+		key := tailKey(*entry)
+		held := append(s.tail[key], tailEntry{entry: *entry, expires: now.Add(rule.tailWindow)})
+		if len(held) > rule.TailBuffer {
+			s.dropHeld(held[0].entry)
+			held = held[1:]
+		}
+		s.tail[key] = held
+		return false, nil
+	}
+
+	stats.Sampled++
+	s.countDropped(entry.Domain, dropReasonSampled)
+	return false, nil
+}
+
+// Stats returns the sampling statistics per domain, sorted by domain
+func (s *Sampler) Stats() []SamplingStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]SamplingStats, 0, len(s.stats))
+	for _, domainStats := range s.stats {
+		stats = append(stats, *domainStats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Domain < stats[j].Domain
+	})
+	return stats
+}
+
+// run periodically writes the repeat counts of closed dedup windows and forgets expired tail entries
+func (s *Sampler) run() {
+	ticker := time.NewTicker(samplingFlushPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(false)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the periodic flush and writes the repeat counts of every open dedup window, it's called on shutdown
+// once the ingest queue stored its entries
+func (s *Sampler) Close() {
+	close(s.stop)
+	s.flush(true)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) > 0 {
+		log.Printf("Lost the repeat counts of %d log entries that are not stored", len(s.pending))
+	}
+}
+
+// flush writes the repeat counts of the dedup windows that closed, or of all of them when forced. Counts that
+// couldn't be written, like those of entries still waiting in the ingest queue, are retried on the next flush.
+func (s *Sampler) flush(force bool) {
+	s.mu.Lock()
+	now := s.now()
+	closed := s.pending
+	s.pending = nil
+	for key, entry := range s.dedup {
+		if force || !now.Before(entry.windowEnd) {
+			delete(s.dedup, key)
+			if entry.repeatCount > 0 {
+				closed = append(closed, entry)
+			}
+		}
+	}
+	for key, held := range s.tail {
+		remaining := held[:0]
+		for _, tail := range held {
+			if now.Before(tail.expires) {
+				remaining = append(remaining, tail)
+			} else {
+				s.dropHeld(tail.entry)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(s.tail, key)
+		} else {
+			s.tail[key] = remaining
+		}
+	}
+	s.mu.Unlock()
+
+	failed := make([]*dedupEntry, 0)
+	for _, entry := range closed {
+		err := s.logRepo.IncrementRepeatCount(entry.domain, entry.id, entry.repeatCount)
+		if err == nil {
+			continue
+		}
+		if now.Before(entry.retryUntil()) {
+			failed = append(failed, entry)
+			continue
+		}
+		log.Printf("Failed to update repeat count of log entry: %v", err)
+	}
+
+	if len(failed) > 0 {
+		s.mu.Lock()
+		s.pending = append(s.pending, failed...)
+		s.mu.Unlock()
+	}
+}
+
+// remember opens a dedup window for a stored entry, so identical messages are collapsed into it
+func (s *Sampler) remember(entry *JsonLog, now time.Time) {
+	if s.dedupWindow <= 0 {
+		return
+	}
+	entry.RepeatCount = 1
+	s.dedup[dedupKey(*entry)] = &dedupEntry{domain: entry.Domain, id: entry.ID, windowEnd: now.Add(s.dedupWindow)}
+}
+
+func (s *Sampler) ruleFor(entry JsonLog) *samplingRule {
+	for _, rule := range s.rules {
+		if rule.matches(entry) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func (s *Sampler) statsFor(domain string) *SamplingStats {
+	stats, exists := s.stats[domain]
+	if !exists {
+		stats = &SamplingStats{Domain: domain}
+		s.stats[domain] = stats
+	}
+	return stats
+}
+
+func (s *Sampler) countDropped(domain string, reason string) {
+	s.dropped.Inc(domain, reason)
+}
+
+// dropHeld counts an entry held back by a tail window as sampled, it must be called with the lock held
+func (s *Sampler) dropHeld(entry JsonLog) {
+	s.statsFor(entry.Domain).Sampled++
+	s.countDropped(entry.Domain, dropReasonSampled)
+}
+
+// dropRescued counts rescued entries that couldn't be queued after all as sampled instead of kept
+func (s *Sampler) dropRescued(entries []JsonLog) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range entries {
+		stats := s.statsFor(entry.Domain)
+		stats.Kept--
+		stats.Rescued--
+		s.dropHeld(entry)
+	}
+}
+
+func (r *samplingRule) matches(entry JsonLog) bool {
+	return (r.Domain == "" || r.Domain == entry.Domain) &&
+		(r.Group == "" || r.Group == entry.Group) &&
+		(r.Tag == "" || r.Tag == entry.Tag) &&
+		(r.Level == "" || strings.EqualFold(r.Level, entry.Level))
+}
+
+func (r *samplingRule) keep() bool {
+	if r.KeepOneIn > 0 {
+		r.seen++
+		return (r.seen-1)%r.KeepOneIn == 0
+	}
+	return rand.Float64()*100 < r.KeepPercent
+}
+
+// isError checks if an entry is logged at an error level or carries an exception
+func isError(entry JsonLog) bool {
+	if entry.Exception != nil {
+		return true
+	}
+	_, exists := errorLevels[strings.ToLower(entry.Level)]
+	return exists
+}
+
+func dedupKey(entry JsonLog) string {
+	return strings.Join([]string{entry.Domain, entry.Group, entry.Tag, strings.ToLower(entry.Level), entry.Log}, "\xff")
+}
+
+func tailKey(entry JsonLog) string {
+	return entry.Domain + "\xff" + entry.Group
+}
@@ -0,0 +1,348 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gofeather/internal/utility"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SchemaCollection holds the registered domain schemas, the underscore keeps it out of the domain list
+	SchemaCollection = "_schemas"
+
+	// SchemaModeReject refuses requests holding entries that don't match the schema of their domain
+	SchemaModeReject = "reject"
+	// SchemaModeTag stores entries that don't match, listing the violations in their schema_errors
+	SchemaModeTag = "tag"
+
+	// schemaReloadInterval is how often the schemas are reloaded, picking up changes made through other instances
+	schemaReloadInterval = time.Minute
+	// schemaResourcePrefix is the URL of the compiled schemas, references outside of it aren't loaded
+	schemaResourcePrefix = "gofeather:///schemas/"
+)
+
+// ErrInvalidSchema is returned when a registered schema can't be used
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// DomainSchema constrains the entries posted to a domain. Fields is a JSON Schema for the fields payload,
+// Groups and Tags list the allowed values when not empty.
+type DomainSchema struct {
+	Domain       string          `json:"domain" bson:"_id"`
+	Mode         string          `json:"mode" bson:"mode"`
+	Fields       json.RawMessage `json:"fields,omitempty" bson:"-" swaggertype:"object"`
+	Groups       []string        `json:"groups,omitempty" bson:"groups,omitempty"`
+	Tags         []string        `json:"tags,omitempty" bson:"tags,omitempty"`
+	RequireGroup bool            `json:"require_group" bson:"require_group"`
+	RequireTag   bool            `json:"require_tag" bson:"require_tag"`
+	UpdatedAt    int64           `json:"updated_at" bson:"updated_at"`
+	// FieldsSource stores the fields schema as text, MongoDB doesn't allow all JSON Schema keywords as keys
+	FieldsSource string `json:"-" bson:"fields,omitempty"`
+}
+
+// SchemaViolation lists why an entry of a request doesn't match the schema of its domain
+type SchemaViolation struct {
+	Index  int      `json:"index"`
+	Domain string   `json:"domain"`
+	Errors []string `json:"errors"`
+}
+
+// SchemaError is returned when entries are rejected by the schema of their domain
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	return "log entries don't match the schema of their domain"
+}
+
+type SchemaRepository interface {
+	GetSchemas() ([]DomainSchema, error)
+	SaveSchema(schema DomainSchema) error
+	DeleteSchema(domain string) error
+}
+
+type MongoSchemaRepository struct {
+	database *mongo.Database
+}
+
+func NewMongoSchemaRepository(database *mongo.Database) *MongoSchemaRepository {
+	return &MongoSchemaRepository{database: database}
+}
+
+func (r *MongoSchemaRepository) GetSchemas() ([]DomainSchema, error) {
+	var results []DomainSchema
+	coll := r.database.Collection(SchemaCollection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		cur, findErr := coll.Find(ctx, bson.M{})
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	for i := range results {
+		if results[i].FieldsSource != "" {
+			results[i].Fields = json.RawMessage(results[i].FieldsSource)
+		}
+	}
+	return results, err
+}
+
+func (r *MongoSchemaRepository) SaveSchema(schema DomainSchema) error {
+	coll := r.database.Collection(SchemaCollection)
+	schema.FieldsSource = string(schema.Fields)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.ReplaceOne(ctx, bson.M{"_id": schema.Domain}, schema, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (r *MongoSchemaRepository) DeleteSchema(domain string) error {
+	coll := r.database.Collection(SchemaCollection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		result, err := coll.DeleteOne(ctx, bson.M{"_id": domain})
+		if err == nil && result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return err
+	})
+}
+
+// compiledSchema is a registered schema ready to validate with
+type compiledSchema struct {
+	definition DomainSchema
+	fields     *jsonschema.Schema
+}
+
+// SchemaRegistry keeps the compiled schemas of all domains in memory for validation during ingest
+type SchemaRegistry struct {
+	repo    SchemaRepository
+	mu      sync.RWMutex
+	schemas map[string]*compiledSchema
+}
+
+// NewSchemaRegistry loads the registered schemas and reloads them periodically in the background
+func NewSchemaRegistry(repo SchemaRepository) *SchemaRegistry {
+	registry := &SchemaRegistry{repo: repo, schemas: make(map[string]*compiledSchema)}
+	if err := registry.reload(); err != nil {
+		log.Printf("Unable to load domain schemas: %v", err)
+	}
+
+	go func() {
+		for range time.Tick(schemaReloadInterval) {
+			if err := registry.reload(); err != nil {
+				log.Printf("Unable to reload domain schemas: %v", err)
+			}
+		}
+	}()
+	return registry
+}
+
+func (r *SchemaRegistry) reload() error {
+	definitions, err := r.repo.GetSchemas()
+	if err != nil {
+		return err
+	}
+
+	schemas := make(map[string]*compiledSchema, len(definitions))
+	for _, definition := range definitions {
+		compiled, err := compileSchema(definition)
+		if err != nil {
+			log.Printf("Skipping the schema of %s: %v", definition.Domain, err)
+			continue
+		}
+		schemas[definition.Domain] = compiled
+	}
+
+	r.mu.Lock()
+	r.schemas = schemas
+	r.mu.Unlock()
+	return nil
+}
+
+// Register validates and stores the schema of a domain, replacing the previous one
+func (r *SchemaRegistry) Register(definition DomainSchema) (*DomainSchema, error) {
+	if definition.Mode == "" {
+		definition.Mode = SchemaModeReject
+	}
+	definition.UpdatedAt = time.Now().UTC().UnixMilli()
+
+	compiled, err := compileSchema(definition)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.repo.SaveSchema(definition); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.schemas[definition.Domain] = compiled
+	r.mu.Unlock()
+	return &definition, nil
+}
+
+// Remove deletes the schema of a domain, returning mongo.ErrNoDocuments when it has none
+func (r *SchemaRegistry) Remove(domain string) error {
+	if err := r.repo.DeleteSchema(domain); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	delete(r.schemas, domain)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *SchemaRegistry) Get(domain string) (*DomainSchema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	compiled, exists := r.schemas[domain]
+	if !exists {
+		return nil, false
+	}
+	definition := compiled.definition
+	return &definition, true
+}
+
+// List returns the schemas of all domains, sorted by domain
+func (r *SchemaRegistry) List() []DomainSchema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	definitions := make([]DomainSchema, 0, len(r.schemas))
+	for _, compiled := range r.schemas {
+		definitions = append(definitions, compiled.definition)
+	}
+	sort.Slice(definitions, func(i, j int) bool {
+		return definitions[i].Domain < definitions[j].Domain
+	})
+	return definitions
+}
+
+// validate checks an entry against the schema of its domain, returning the mode of the schema and the violations
+func (r *SchemaRegistry) validate(entry JsonLog) (string, []string) {
+	r.mu.RLock()
+	compiled, exists := r.schemas[entry.Domain]
+	r.mu.RUnlock()
+	if !exists {
+		return "", nil
+	}
+	return compiled.definition.Mode, compiled.violations(entry)
+}
+
+func compileSchema(definition DomainSchema) (*compiledSchema, error) {
+	if definition.Domain == "" {
+		return nil, fmt.Errorf("%w: a schema needs a domain", ErrInvalidSchema)
+	}
+	if definition.Mode != SchemaModeReject && definition.Mode != SchemaModeTag {
+		return nil, fmt.Errorf("%w: mode must be either reject or tag", ErrInvalidSchema)
+	}
+
+	compiled := &compiledSchema{definition: definition}
+	if len(definition.Fields) == 0 {
+		return compiled, nil
+	}
+
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = loadSchemaURL
+	resource := schemaResourcePrefix + definition.Domain + "/fields.json"
+	if err := compiler.AddResource(resource, bytes.NewReader(definition.Fields)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	fields, err := compiler.Compile(resource)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	compiled.fields = fields
+	return compiled, nil
+}
+
+// loadSchemaURL refuses to load referenced schemas, so a schema can't make the server read files or fetch URLs.
+// Only references to the schemas added to the compiler resolve.
+func loadSchemaURL(url string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(url, schemaResourcePrefix) {
+		return nil, fmt.Errorf("%s can't be referenced, only the schema itself", url)
+	}
+	return nil, fmt.Errorf("%s doesn't exist", url)
+}
+
+func (s *compiledSchema) violations(entry JsonLog) []string {
+	var violations []string
+	definition := s.definition
+
+	if entry.Group == "" && (definition.RequireGroup || len(definition.Groups) > 0) {
+		violations = append(violations, "group is required")
+	} else if len(definition.Groups) > 0 && !slices.Contains(definition.Groups, entry.Group) {
+		violations = append(violations, fmt.Sprintf("group %q is not one of %v", entry.Group, definition.Groups))
+	}
+	if entry.Tag == "" && (definition.RequireTag || len(definition.Tags) > 0) {
+		violations = append(violations, "tag is required")
+	} else if len(definition.Tags) > 0 && !slices.Contains(definition.Tags, entry.Tag) {
+		violations = append(violations, fmt.Sprintf("tag %q is not one of %v", entry.Tag, definition.Tags))
+	}
+
+	if s.fields != nil {
+		violations = append(violations, s.fieldViolations(entry.Fields)...)
+	}
+	return violations
+}
+
+// fieldViolations validates the fields payload, which is converted to plain JSON values first
+// as entries decoded from MessagePack or protobuf hold other Go types
+func (s *compiledSchema) fieldViolations(fields map[string]interface{}) []string {
+	if fields == nil {
+		fields = map[string]interface{}{}
+	}
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return []string{"fields can't be read: " + err.Error()}
+	}
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return []string{"fields can't be read: " + err.Error()}
+	}
+
+	err = s.fields.Validate(document)
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		if err != nil {
+			return []string{err.Error()}
+		}
+		return nil
+	}
+
+	var violations []string
+	for _, unit := range validationErr.BasicOutput().Errors {
+		//The basic output also holds the failing parent schemas, only the leaves explain what is wrong
+		if unit.Error == "" || len(unit.KeywordLocation) == 0 || isSummary(unit.Error) {
+			continue
+		}
+		violations = append(violations, fmt.Sprintf("fields%s: %s", unit.InstanceLocation, unit.Error))
+	}
+	if len(violations) == 0 {
+		violations = append(violations, validationErr.Error())
+	}
+	return violations
+}
+
+func isSummary(message string) bool {
+	return strings.HasPrefix(message, "doesn't validate with")
+}
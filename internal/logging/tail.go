@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"gofeather/internal/metrics"
+	"log"
+	"sync"
+)
+
+const tailBufferSize = 256
+
+// TailHub passes ingested entries on to the clients that are tailing a domain
+type TailHub struct {
+	mu            sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+	dropped       *metrics.Counter
+}
+
+// Subscription receives the entries of a domain matching its filter, slow subscribers miss entries
+// instead of slowing down ingest
+type Subscription struct {
+	Entries chan JsonLog
+	domain  string
+	filter  LogFilter
+}
+
+func NewTailHub() *TailHub {
+	dropped, err := metrics.DefaultRegistry.NewCounter("gofeather_tail_entries_dropped_total",
+		"Log entries not delivered to a tailing client because it was too slow")
+	if err != nil {
+		log.Printf("Unable to register tail metric: %v", err)
+	}
+	return &TailHub{subscriptions: make(map[*Subscription]struct{}), dropped: dropped}
+}
+
+// Subscribe starts receiving the entries of a domain, Unsubscribe must be called when the client is done
+func (h *TailHub) Subscribe(domain string, filter LogFilter) *Subscription {
+	subscription := &Subscription{Entries: make(chan JsonLog, tailBufferSize), domain: domain, filter: filter}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscriptions[subscription] = struct{}{}
+	return subscription
+}
+
+func (h *TailHub) Unsubscribe(subscription *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.subscriptions[subscription]; exists {
+		delete(h.subscriptions, subscription)
+		close(subscription.Entries)
+	}
+}
+
+// Publish hands the entries to the matching subscriptions without blocking
+func (h *TailHub) Publish(entries []JsonLog) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for subscription := range h.subscriptions {
+		for _, entry := range entries {
+			if entry.Domain != subscription.domain || !subscription.filter.Matches(entry) {
+				continue
+			}
+			select {
+			case subscription.Entries <- entry:
+			default:
+				h.dropped.Inc()
+			}
+		}
+	}
+}
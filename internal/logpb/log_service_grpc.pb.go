@@ -0,0 +1,254 @@
+// gRPC service for shipping, querying and tailing logs, served next to the REST API when grpc is enabled.
+// The ingest key of the client is read from the x-api-key metadata.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: featherlog/v1/log_service.proto
+
+package logpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	LogService_Ingest_FullMethodName = "/featherlog.v1.LogService/Ingest"
+	LogService_Query_FullMethodName  = "/featherlog.v1.LogService/Query"
+	LogService_Tail_FullMethodName   = "/featherlog.v1.LogService/Tail"
+)
+
+// LogServiceClient is the client API for LogService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LogServiceClient interface {
+	// Ingest receives a stream of entries and responds once the client closes the stream
+	Ingest(ctx context.Context, opts ...grpc.CallOption) (LogService_IngestClient, error)
+	// Query returns the stored entries of a domain, newest first by the time field of the filter
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	// Tail streams the entries of a domain as they are ingested
+	Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (LogService_TailClient, error)
+}
+
+type logServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLogServiceClient(cc grpc.ClientConnInterface) LogServiceClient {
+	return &logServiceClient{cc}
+}
+
+func (c *logServiceClient) Ingest(ctx context.Context, opts ...grpc.CallOption) (LogService_IngestClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[0], LogService_Ingest_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logServiceIngestClient{stream}
+	return x, nil
+}
+
+type LogService_IngestClient interface {
+	Send(*LogEntry) error
+	CloseAndRecv() (*IngestResponse, error)
+	grpc.ClientStream
+}
+
+type logServiceIngestClient struct {
+	grpc.ClientStream
+}
+
+func (x *logServiceIngestClient) Send(m *LogEntry) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *logServiceIngestClient) CloseAndRecv() (*IngestResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(IngestResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *logServiceClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, LogService_Query_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logServiceClient) Tail(ctx context.Context, in *TailRequest, opts ...grpc.CallOption) (LogService_TailClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LogService_ServiceDesc.Streams[1], LogService_Tail_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logServiceTailClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LogService_TailClient interface {
+	Recv() (*LogEntry, error)
+	grpc.ClientStream
+}
+
+type logServiceTailClient struct {
+	grpc.ClientStream
+}
+
+func (x *logServiceTailClient) Recv() (*LogEntry, error) {
+	m := new(LogEntry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LogServiceServer is the server API for LogService service.
+// All implementations must embed UnimplementedLogServiceServer
+// for forward compatibility
+type LogServiceServer interface {
+	// Ingest receives a stream of entries and responds once the client closes the stream
+	Ingest(LogService_IngestServer) error
+	// Query returns the stored entries of a domain, newest first by the time field of the filter
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	// Tail streams the entries of a domain as they are ingested
+	Tail(*TailRequest, LogService_TailServer) error
+	mustEmbedUnimplementedLogServiceServer()
+}
+
+// UnimplementedLogServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedLogServiceServer struct {
+}
+
+func (UnimplementedLogServiceServer) Ingest(LogService_IngestServer) error {
+	return status.Errorf(codes.Unimplemented, "method Ingest not implemented")
+}
+func (UnimplementedLogServiceServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedLogServiceServer) Tail(*TailRequest, LogService_TailServer) error {
+	return status.Errorf(codes.Unimplemented, "method Tail not implemented")
+}
+func (UnimplementedLogServiceServer) mustEmbedUnimplementedLogServiceServer() {}
+
+// UnsafeLogServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LogServiceServer will
+// result in compilation errors.
+type UnsafeLogServiceServer interface {
+	mustEmbedUnimplementedLogServiceServer()
+}
+
+func RegisterLogServiceServer(s grpc.ServiceRegistrar, srv LogServiceServer) {
+	s.RegisterService(&LogService_ServiceDesc, srv)
+}
+
+func _LogService_Ingest_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LogServiceServer).Ingest(&logServiceIngestServer{stream})
+}
+
+type LogService_IngestServer interface {
+	SendAndClose(*IngestResponse) error
+	Recv() (*LogEntry, error)
+	grpc.ServerStream
+}
+
+type logServiceIngestServer struct {
+	grpc.ServerStream
+}
+
+func (x *logServiceIngestServer) SendAndClose(m *IngestResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *logServiceIngestServer) Recv() (*LogEntry, error) {
+	m := new(LogEntry)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _LogService_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServiceServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LogService_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServiceServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LogService_Tail_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TailRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogServiceServer).Tail(m, &logServiceTailServer{stream})
+}
+
+type LogService_TailServer interface {
+	Send(*LogEntry) error
+	grpc.ServerStream
+}
+
+type logServiceTailServer struct {
+	grpc.ServerStream
+}
+
+func (x *logServiceTailServer) Send(m *LogEntry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// LogService_ServiceDesc is the grpc.ServiceDesc for LogService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LogService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "featherlog.v1.LogService",
+	HandlerType: (*LogServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _LogService_Query_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Ingest",
+			Handler:       _LogService_Ingest_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Tail",
+			Handler:       _LogService_Tail_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "featherlog/v1/log_service.proto",
+}
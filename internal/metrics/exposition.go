@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Handler exposes the metrics of the default registry in the Prometheus text exposition format
+func Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, contentType, []byte(DefaultRegistry.Expose()))
+	}
+}
+
+// Expose renders all registered metrics in the Prometheus text exposition format, sorted by name
+func (r *Registry) Expose() string {
+	r.mu.RLock()
+	collectors := make([]collector, 0, len(r.collectors))
+	for _, c := range r.collectors {
+		collectors = append(collectors, c)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].metricName() < collectors[j].metricName()
+	})
+
+	var builder strings.Builder
+	for _, c := range collectors {
+		c.write(&builder)
+	}
+	return builder.String()
+}
+
+func (c *Counter) write(builder *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(builder, counterType)
+	for _, key := range sortedKeys(c.values) {
+		c.writeSample(builder, c.name, key, "", "", c.values[key])
+	}
+}
+
+func (g *Gauge) write(builder *strings.Builder) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(builder, gaugeType)
+	for _, key := range sortedKeys(g.values) {
+		g.writeSample(builder, g.name, key, "", "", g.values[key])
+	}
+}
+
+func (h *Histogram) write(builder *strings.Builder) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(builder, histogramType)
+	for _, key := range sortedKeys(h.values) {
+		value := h.values[key]
+		for i, upperBound := range h.buckets {
+			h.writeSample(builder, h.name+"_bucket", key, "le", formatFloat(upperBound), float64(value.bucketCounts[i]))
+		}
+		h.writeSample(builder, h.name+"_bucket", key, "le", "+Inf", float64(value.count))
+		h.writeSample(builder, h.name+"_sum", key, "", "", value.sum)
+		h.writeSample(builder, h.name+"_count", key, "", "", float64(value.count))
+	}
+}
+
+func (f *family) writeHeader(builder *strings.Builder, metricType string) {
+	if f.help != "" {
+		builder.WriteString("# HELP " + f.name + " " + helpEscaper.Replace(f.help) + "\n")
+	}
+	builder.WriteString("# TYPE " + f.name + " " + metricType + "\n")
+}
+
+// writeSample writes a single sample line, the extra label is used for histogram buckets
+func (f *family) writeSample(builder *strings.Builder, name string, key string, extraLabel string, extraValue string, value float64) {
+	builder.WriteString(name)
+
+	labels := make([]string, 0, len(f.labelNames)+1)
+	for i, labelValue := range f.labelValues[key] {
+		labels = append(labels, f.labelNames[i]+`="`+labelEscaper.Replace(labelValue)+`"`)
+	}
+	if extraLabel != "" {
+		labels = append(labels, extraLabel+`="`+extraValue+`"`)
+	}
+	if len(labels) > 0 {
+		builder.WriteString("{" + strings.Join(labels, ",") + "}")
+	}
+
+	builder.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
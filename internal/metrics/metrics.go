@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+var (
+	metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	// DefaultBuckets are used for histograms that are created without buckets
+	DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// DefaultRegistry is the registry exposed by the metrics endpoint
+var DefaultRegistry = NewRegistry()
+
+type collector interface {
+	metricName() string
+	write(builder *strings.Builder)
+}
+
+// Registry holds the metrics of the application by name
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// family holds the shared state of a metric with all of its label combinations
+type family struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	// labelValues holds the label values of every key, keys are only used to look the values up
+	labelValues map[string][]string
+}
+
+func (f *family) metricName() string {
+	return f.name
+}
+
+// labelKey quotes the label values into a map key, which can't be mistaken for that of other values.
+// The amount of values must match the label names.
+func (f *family) labelKey(labelValues []string) string {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	quoted := make([]string, len(labelValues))
+	for i, labelValue := range labelValues {
+		quoted[i] = strconv.Quote(labelValue)
+	}
+	return strings.Join(quoted, ",")
+}
+
+// remember keeps the label values of a key, it must be called with the lock held
+func (f *family) remember(key string, labelValues []string) {
+	if _, exists := f.labelValues[key]; exists {
+		return
+	}
+	if f.labelValues == nil {
+		f.labelValues = make(map[string][]string)
+	}
+	f.labelValues[key] = append([]string(nil), labelValues...)
+}
+
+type Counter struct {
+	family
+	values map[string]float64
+}
+
+type Gauge struct {
+	family
+	values map[string]float64
+}
+
+type Histogram struct {
+	family
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	bucketCounts []uint64
+	count        uint64
+	sum          float64
+}
+
+func (r *Registry) NewCounter(name string, help string, labelNames ...string) (*Counter, error) {
+	counter := &Counter{family: family{name: name, help: help, labelNames: labelNames}, values: make(map[string]float64)}
+	if err := r.register(counter, labelNames); err != nil {
+		return nil, err
+	}
+	return counter, nil
+}
+
+func (r *Registry) NewGauge(name string, help string, labelNames ...string) (*Gauge, error) {
+	gauge := &Gauge{family: family{name: name, help: help, labelNames: labelNames}, values: make(map[string]float64)}
+	if err := r.register(gauge, labelNames); err != nil {
+		return nil, err
+	}
+	return gauge, nil
+}
+
+// NewHistogram creates a histogram with the given upper bounds, DefaultBuckets are used when none are given
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labelNames ...string) (*Histogram, error) {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	if math.IsInf(sorted[len(sorted)-1], 1) {
+		sorted = sorted[:len(sorted)-1]
+	}
+
+	histogram := &Histogram{
+		family:  family{name: name, help: help, labelNames: labelNames},
+		buckets: sorted,
+		values:  make(map[string]*histogramValue),
+	}
+	if err := r.register(histogram, labelNames); err != nil {
+		return nil, err
+	}
+	return histogram, nil
+}
+
+// Unregister removes a metric from the registry, unknown names are ignored
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.collectors, name)
+}
+
+func (r *Registry) register(c collector, labelNames []string) error {
+	if !metricNameRegex.MatchString(c.metricName()) {
+		return fmt.Errorf("invalid metric name: %s", c.metricName())
+	}
+	for _, labelName := range labelNames {
+		if !labelNameRegex.MatchString(labelName) || strings.HasPrefix(labelName, "__") {
+			return fmt.Errorf("invalid label name for metric %s: %s", c.metricName(), labelName)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.collectors[c.metricName()]; exists {
+		return fmt.Errorf("metric already registered: %s", c.metricName())
+	}
+	r.collectors[c.metricName()] = c
+	return nil
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increases the counter, negative values are ignored as counters can only go up.
+// Like the other metric operations it does nothing on a nil metric, which is left when registering failed.
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if c == nil || value < 0 {
+		return
+	}
+	key := c.labelKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remember(key, labelValues)
+	c.values[key] += value
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	key := g.labelKey(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.remember(key, labelValues)
+	g.values[key] = value
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+	key := g.labelKey(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.remember(key, labelValues)
+	g.values[key] += value
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	key := h.labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	histValue, exists := h.values[key]
+	if !exists {
+		h.remember(key, labelValues)
+		histValue = &histogramValue{bucketCounts: make([]uint64, len(h.buckets))}
+		h.values[key] = histValue
+	}
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			histValue.bucketCounts[i]++
+		}
+	}
+	histValue.count++
+	histValue.sum += value
+}
@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"github.com/gin-gonic/gin"
+	"gofeather/internal/utility"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+type RateLimitHandler struct {
+	limiter *Limiter
+}
+
+func NewRateLimitHandler(limiter *Limiter) *RateLimitHandler {
+	return &RateLimitHandler{limiter: limiter}
+}
+
+// GetAllUsage godoc
+//
+//	@Summary		Get the ingest usage
+//	@Description	Retrieves the limits and the usage of today for every domain and ingest key
+//	@Tags			Rate limits
+//	@Produce		json
+//	@Success		200	{object}	map[string][]Usage
+//	@Router			/admin/ratelimits [get]
+func (h *RateLimitHandler) GetAllUsage(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"domains": h.limiter.AllUsage(ScopeDomain),
+		"keys":    h.limiter.AllUsage(ScopeKey),
+	})
+}
+
+// GetUsage godoc
+//
+//	@Summary		Get the ingest usage of a domain or key
+//	@Description	Retrieves the limit and the usage of today for a single domain or ingest key
+//	@Tags			Rate limits
+//	@Produce		json
+//	@Param			scope	path		string	true	"domains or keys"
+//	@Param			name	path		string	true	"Domain name or ingest key"
+//	@Success		200		{object}	Usage
+//	@Failure		400		{object}	error
+//	@Router			/admin/ratelimits/{scope}/{name} [get]
+func (h *RateLimitHandler) GetUsage(c *gin.Context) {
+	scope, ok := scopeFromPath(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, h.limiter.Usage(scope, c.Param("name")))
+}
+
+// SetOverride godoc
+//
+//	@Summary		Override the limit of a domain or key
+//	@Description	Replaces the limit from config.yml for a domain or ingest key until the override is removed
+//	@Tags			Rate limits
+//	@Accept			json
+//	@Produce		json
+//	@Param			scope	path		string	true	"domains or keys"
+//	@Param			name	path		string	true	"Domain name or ingest key"
+//	@Param			limit	body		Limit	true	"New limit"
+//	@Success		200		{object}	Usage
+//	@Failure		400		{object}	error
+//	@Router			/admin/ratelimits/{scope}/{name} [put]
+func (h *RateLimitHandler) SetOverride(c *gin.Context) {
+	scope, ok := scopeFromPath(c)
+	if !ok {
+		return
+	}
+
+	var limit Limit
+	if err := c.BindJSON(&limit); err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if limit.Rate < 0 || limit.Burst < 0 || limit.DailyQuota < 0 {
+		utility.RespondWithError(c, http.StatusBadRequest, "limits can not be negative")
+		return
+	}
+
+	h.limiter.SetOverride(scope, c.Param("name"), limit)
+	c.JSON(http.StatusOK, h.limiter.Usage(scope, c.Param("name")))
+}
+
+// RemoveOverride godoc
+//
+//	@Summary		Remove the limit override of a domain or key
+//	@Description	Falls back to the limit from config.yml for a domain or ingest key
+//	@Tags			Rate limits
+//	@Produce		json
+//	@Param			scope	path		string	true	"domains or keys"
+//	@Param			name	path		string	true	"Domain name or ingest key"
+//	@Success		200		{object}	Usage
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Router			/admin/ratelimits/{scope}/{name} [delete]
+func (h *RateLimitHandler) RemoveOverride(c *gin.Context) {
+	scope, ok := scopeFromPath(c)
+	if !ok {
+		return
+	}
+
+	if !h.limiter.RemoveOverride(scope, c.Param("name")) {
+		utility.RespondWithError(c, http.StatusNotFound, "no override found")
+		return
+	}
+	c.JSON(http.StatusOK, h.limiter.Usage(scope, c.Param("name")))
+}
+
+// RespondRateLimited aborts a request that exceeded its limits with a 429 and a Retry-After header in seconds,
+// or with a 413 when the request is larger than the burst
+func RespondRateLimited(c *gin.Context, decision Decision) {
+	if decision.TooLarge {
+		utility.RespondWithError(c, http.StatusRequestEntityTooLarge, decision.Reason)
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+	utility.RespondWithError(c, http.StatusTooManyRequests, decision.Reason)
+}
+
+// scopeFromPath maps the plural scope in the path to a limiter scope, responding with an error when it is unknown
+func scopeFromPath(c *gin.Context) (string, bool) {
+	switch c.Param("scope") {
+	case "domains":
+		return ScopeDomain, true
+	case "keys":
+		return ScopeKey, true
+	}
+	utility.RespondWithError(c, http.StatusBadRequest, "scope must be either domains or keys")
+	return "", false
+}
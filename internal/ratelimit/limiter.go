@@ -0,0 +1,298 @@
+package ratelimit
+
+import (
+	"fmt"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/constants"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	dayLayout = "2006-01-02"
+	// evictionInterval is how often idle buckets are looked for, and how long a bucket must be unused to be evicted
+	evictionInterval = time.Hour
+)
+
+// bucket tracks the token bucket and the daily usage of a single domain or ingest key
+type bucket struct {
+	tokens     float64
+	lastRefill time.Time
+	day        string
+	accepted   int64
+	rejected   int64
+	lastUsed   time.Time
+}
+
+// Limiter enforces token bucket rate limits and daily quotas per domain and per ingest key
+type Limiter struct {
+	mu        sync.Mutex
+	config    Config
+	overrides map[string]map[string]Limit
+	buckets   map[string]map[string]*bucket
+	now       func() time.Time
+	// lastEviction is when idle buckets were last evicted
+	lastEviction time.Time
+}
+
+func NewLimiter(limitConfig Config) *Limiter {
+	return &Limiter{
+		config: limitConfig,
+		overrides: map[string]map[string]Limit{
+			ScopeDomain: make(map[string]Limit),
+			ScopeKey:    make(map[string]Limit),
+		},
+		buckets: map[string]map[string]*bucket{
+			ScopeDomain: make(map[string]*bucket),
+			ScopeKey:    make(map[string]*bucket),
+		},
+		now: time.Now,
+	}
+}
+
+// LoadConfig reads the rate limits from the configuration file, no limits apply when they are missing
+func LoadConfig() Config {
+	var limitConfig Config
+	if config.Exists(constants.RateLimits) {
+		if err := config.BindStruct(constants.RateLimits, &limitConfig); err != nil {
+			log.Printf("Unable to read rate limits from config: %v", err)
+		}
+	}
+	return limitConfig
+}
+
+// Allow consumes the entries of a request from the limits of their domains and from the limit of the ingest key,
+// which is charged for all of them. Nothing is consumed when any of the limits rejects the request.
+// An empty key only checks the domains.
+func (l *Limiter) Allow(key string, perDomain map[string]int) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now().UTC()
+	l.evictIdle(now)
+
+	type check struct {
+		scope   string
+		name    string
+		entries int
+	}
+	checks := make([]check, 0, len(perDomain)+1)
+	total := 0
+	for domain, entries := range perDomain {
+		checks = append(checks, check{ScopeDomain, domain, entries})
+		total += entries
+	}
+	//Domains are checked in order, so the same request is always rejected for the same reason
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+	if key != "" {
+		checks = append(checks, check{ScopeKey, key, total})
+	}
+
+	for _, check := range checks {
+		limit, _ := l.limitFor(check.scope, check.name)
+		b := l.bucketFor(check.scope, check.name, limit, now)
+		if decision := b.check(limit, check.entries, now); !decision.Allowed {
+			decision.Reason = fmt.Sprintf("%s %s: %s", check.scope, check.name, decision.Reason)
+			b.rejected += int64(check.entries)
+			return decision
+		}
+	}
+
+	for _, check := range checks {
+		limit, _ := l.limitFor(check.scope, check.name)
+		l.bucketFor(check.scope, check.name, limit, now).consume(limit, check.entries)
+	}
+
+	return Decision{Allowed: true}
+}
+
+// evictIdle forgets the buckets that weren't used today and are full again, which is the state a new bucket starts
+// in, so the buckets of domains and keys that are gone don't pile up. It runs at most once per eviction interval.
+func (l *Limiter) evictIdle(now time.Time) {
+	if now.Sub(l.lastEviction) < evictionInterval {
+		return
+	}
+	l.lastEviction = now
+
+	today := now.Format(dayLayout)
+	for scope, buckets := range l.buckets {
+		for name, b := range buckets {
+			if b.day == today || now.Sub(b.lastUsed) < evictionInterval {
+				continue
+			}
+			limit, _ := l.limitFor(scope, name)
+			b.refill(limit, now)
+			if limit.Rate <= 0 || b.tokens >= float64(limit.burst()) {
+				delete(buckets, name)
+			}
+		}
+	}
+}
+
+// SetOverride replaces the configured limit of a domain or key until the override is removed
+func (l *Limiter) SetOverride(scope string, name string, limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.overrides[scope][name] = limit
+	//Start with a full bucket for the new limit
+	delete(l.buckets[scope], name)
+}
+
+// RemoveOverride falls back to the configured limit, it returns false when there was no override
+func (l *Limiter) RemoveOverride(scope string, name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, exists := l.overrides[scope][name]; !exists {
+		return false
+	}
+	delete(l.overrides[scope], name)
+	delete(l.buckets[scope], name)
+	return true
+}
+
+// Usage returns the usage of a single domain or key
+func (l *Limiter) Usage(scope string, name string) Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.usage(scope, name, l.now().UTC())
+}
+
+// AllUsage returns the usage of every domain and key that has been seen or has a limit, sorted by name
+func (l *Limiter) AllUsage(scope string) []Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	names := make(map[string]struct{})
+	for name := range l.buckets[scope] {
+		names[name] = struct{}{}
+	}
+	for name := range l.overrides[scope] {
+		names[name] = struct{}{}
+	}
+	for name := range l.configured(scope) {
+		names[name] = struct{}{}
+	}
+
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	now := l.now().UTC()
+	usages := make([]Usage, 0, len(sorted))
+	for _, name := range sorted {
+		usages = append(usages, l.usage(scope, name, now))
+	}
+	return usages
+}
+
+func (l *Limiter) usage(scope string, name string, now time.Time) Usage {
+	limit, overridden := l.limitFor(scope, name)
+	b := l.bucketFor(scope, name, limit, now)
+	b.refill(limit, now)
+
+	usage := Usage{
+		Scope:           scope,
+		Name:            name,
+		Limit:           limit,
+		Overridden:      overridden,
+		Day:             b.day,
+		Accepted:        b.accepted,
+		Rejected:        b.rejected,
+		QuotaRemaining:  -1,
+		TokensAvailable: -1,
+	}
+	if limit.DailyQuota > 0 {
+		usage.QuotaRemaining = max(limit.DailyQuota-b.accepted, 0)
+	}
+	if limit.Rate > 0 {
+		usage.TokensAvailable = math.Floor(b.tokens)
+	}
+	return usage
+}
+
+// limitFor returns the limit of a domain or key and whether it comes from an override
+func (l *Limiter) limitFor(scope string, name string) (Limit, bool) {
+	if limit, exists := l.overrides[scope][name]; exists {
+		return limit, true
+	}
+	if limit, exists := l.configured(scope)[name]; exists {
+		return limit, false
+	}
+	if scope == ScopeKey {
+		return l.config.KeyDefault, false
+	}
+	return l.config.DomainDefault, false
+}
+
+func (l *Limiter) configured(scope string) map[string]Limit {
+	if scope == ScopeKey {
+		return l.config.Keys
+	}
+	return l.config.Domains
+}
+
+func (l *Limiter) bucketFor(scope string, name string, limit Limit, now time.Time) *bucket {
+	b, exists := l.buckets[scope][name]
+	if !exists {
+		b = &bucket{tokens: float64(limit.burst()), lastRefill: now, day: now.Format(dayLayout)}
+		l.buckets[scope][name] = b
+	}
+	if day := now.Format(dayLayout); b.day != day {
+		b.day = day
+		b.accepted = 0
+		b.rejected = 0
+	}
+	return b
+}
+
+func (b *bucket) refill(limit Limit, now time.Time) {
+	if limit.Rate <= 0 {
+		return
+	}
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.tokens = math.Min(float64(limit.burst()), b.tokens+elapsed*limit.Rate)
+	b.lastRefill = now
+}
+
+func (b *bucket) check(limit Limit, entries int, now time.Time) Decision {
+	b.lastUsed = now
+	if limit.Rate > 0 && entries > limit.burst() {
+		return Decision{TooLarge: true, Reason: fmt.Sprintf("%d entries exceed the burst of %d, send smaller batches", entries, limit.burst())}
+	}
+	if limit.DailyQuota > 0 && b.accepted+int64(entries) > limit.DailyQuota {
+		midnight := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return Decision{RetryAfter: midnight.Sub(now), Reason: "daily quota exceeded"}
+	}
+
+	if limit.Rate > 0 {
+		b.refill(limit, now)
+		if b.tokens < float64(entries) {
+			wait := (float64(entries) - b.tokens) / limit.Rate
+			return Decision{RetryAfter: time.Duration(wait * float64(time.Second)), Reason: "rate limit exceeded"}
+		}
+	}
+
+	return Decision{Allowed: true}
+}
+
+func (b *bucket) consume(limit Limit, entries int) {
+	if limit.Rate > 0 {
+		b.tokens -= float64(entries)
+	}
+	b.accepted += int64(entries)
+}
+
+// burst defaults to the rate when it is not configured, allowing a second worth of requests at once
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return int(math.Max(1, math.Ceil(l.Rate)))
+}
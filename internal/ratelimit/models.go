@@ -0,0 +1,46 @@
+package ratelimit
+
+import "time"
+
+const (
+	ScopeDomain = "domain"
+	ScopeKey    = "key"
+)
+
+// Limit configures the token bucket and daily quota of a domain or ingest key.
+// A rate of zero disables the token bucket and a daily quota of zero disables the quota.
+type Limit struct {
+	Rate       float64 `json:"rate" mapstructure:"rate"`
+	Burst      int     `json:"burst" mapstructure:"burst"`
+	DailyQuota int64   `json:"daily_quota" mapstructure:"daily_quota"`
+}
+
+// Config holds the limits read from config.yml, the defaults apply to domains and keys without their own limit
+type Config struct {
+	DomainDefault Limit            `mapstructure:"domain_default"`
+	KeyDefault    Limit            `mapstructure:"key_default"`
+	Domains       map[string]Limit `mapstructure:"domains"`
+	Keys          map[string]Limit `mapstructure:"keys"`
+}
+
+// Decision is the outcome of a rate limit check, RetryAfter is only set when the request is not allowed.
+// TooLarge is set for requests holding more entries than the burst, which never pass and must be split.
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Reason     string
+	TooLarge   bool
+}
+
+// Usage shows the consumption of a domain or ingest key for the current UTC day
+type Usage struct {
+	Scope           string  `json:"scope"`
+	Name            string  `json:"name"`
+	Limit           Limit   `json:"limit"`
+	Overridden      bool    `json:"overridden"`
+	Day             string  `json:"day"`
+	Accepted        int64   `json:"accepted"`
+	Rejected        int64   `json:"rejected"`
+	QuotaRemaining  int64   `json:"quota_remaining"`
+	TokensAvailable float64 `json:"tokens_available"`
+}
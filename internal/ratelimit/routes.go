@@ -0,0 +1,12 @@
+package ratelimit
+
+import "github.com/gin-gonic/gin"
+
+func CreateRoutes(engine gin.IRoutes, limiter *Limiter) {
+	rateLimitHandler := NewRateLimitHandler(limiter)
+
+	engine.GET("/admin/ratelimits", rateLimitHandler.GetAllUsage)
+	engine.GET("/admin/ratelimits/:scope/:name", rateLimitHandler.GetUsage)
+	engine.PUT("/admin/ratelimits/:scope/:name", rateLimitHandler.SetOverride)
+	engine.DELETE("/admin/ratelimits/:scope/:name", rateLimitHandler.RemoveOverride)
+}
@@ -0,0 +1,48 @@
+package templates
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+const maxTemplateLength = 200
+
+// placeholders replace the variable parts of a message, in order, so messages that only differ in
+// ids, numbers or quoted values share a template
+var placeholders = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+	{regexp.MustCompile(`[\w.+-]+@[\w-]+\.[\w.-]+`), "<email>"},
+	{regexp.MustCompile(`https?://\S+`), "<url>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<str>"},
+	{regexp.MustCompile(`\b\d+(\.\d+)?[a-zA-Z%]*\b`), "<num>"},
+	{regexp.MustCompile(`\b(0x)?[0-9a-fA-F]*\d[0-9a-fA-F]*\b`), "<num>"},
+	{regexp.MustCompile(`\b[a-zA-Z_]*\d[\w-]*\b`), "<id>"},
+	{regexp.MustCompile(`\s+`), " "},
+}
+
+// Of reduces a message to its template, only the first line is used so stack traces don't create templates
+func Of(message string) string {
+	if newline := strings.IndexByte(message, '\n'); newline >= 0 {
+		message = message[:newline]
+	}
+	for _, placeholder := range placeholders {
+		message = placeholder.pattern.ReplaceAllString(message, placeholder.replacement)
+	}
+	message = strings.TrimSpace(message)
+	if len(message) > maxTemplateLength {
+		message = message[:maxTemplateLength]
+	}
+	return message
+}
+
+// ID identifies a template within its domain
+func ID(domain string, template string) string {
+	hash := sha1.Sum([]byte(domain + "\x00" + template))
+	return hex.EncodeToString(hash[:8])
+}
@@ -0,0 +1,307 @@
+package tenancy
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gofeather/internal/utility"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// validTenantID keeps tenant ids usable in paths and config files
+var validTenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type TenantHandler struct {
+	registry *Registry
+	repo     TenantRepository
+}
+
+func NewTenantHandler(registry *Registry, repo TenantRepository) *TenantHandler {
+	return &TenantHandler{registry: registry, repo: repo}
+}
+
+// GetCurrentTenant godoc
+//
+//	@Summary		Get the tenant of the caller
+//	@Description	Retrieves the caller and the tenant and domains they belong to, super admins may have no tenant
+//	@Tags			Tenants
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		401	{object}	error
+//	@Router			/tenant [get]
+func (h *TenantHandler) GetCurrentTenant(c *gin.Context) {
+	caller := CallerFrom(c)
+	response := gin.H{"caller": caller}
+	if caller.Tenant != "" {
+		if tenant, exists := h.tenant(caller.Tenant); exists {
+			response["tenant"] = tenant
+			response["domains"] = h.registry.Domains(tenant.ID)
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetTenants godoc
+//
+//	@Summary		List the tenants
+//	@Description	Retrieves every tenant, only for super admins
+//	@Tags			Tenants
+//	@Produce		json
+//	@Success		200	{array}		Tenant
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/admin/tenants [get]
+func (h *TenantHandler) GetTenants(c *gin.Context) {
+	tenants, err := h.repo.GetTenants()
+	if err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, tenants)
+}
+
+// GetTenant godoc
+//
+//	@Summary		Get a tenant
+//	@Description	Retrieves a tenant and the domains it owns, only for super admins
+//	@Tags			Tenants
+//	@Produce		json
+//	@Param			id	path		string	true	"Tenant id"
+//	@Success		200	{object}	map[string]interface{}
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Router			/admin/tenants/{id} [get]
+func (h *TenantHandler) GetTenant(c *gin.Context) {
+	tenant, exists := h.tenant(c.Param("id"))
+	if !exists {
+		utility.RespondWithError(c, http.StatusNotFound, "tenant not found")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tenant": tenant, "domains": h.registry.Domains(tenant.ID)})
+}
+
+// CreateTenant godoc
+//
+//	@Summary		Create a tenant
+//	@Description	Adds a tenant with its ingest keys and members, only for super admins
+//	@Tags			Tenants
+//	@Accept			json
+//	@Produce		json
+//	@Param			tenant	body		Tenant	true	"Tenant"
+//	@Success		201		{object}	Tenant
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/admin/tenants [post]
+func (h *TenantHandler) CreateTenant(c *gin.Context) {
+	var tenant Tenant
+	if err := c.BindJSON(&tenant); err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !validTenantID.MatchString(tenant.ID) {
+		utility.RespondWithError(c, http.StatusBadRequest, "id must be lowercase letters, digits, - or _")
+		return
+	}
+	if !h.validate(c, &tenant) {
+		return
+	}
+	tenant.Created = time.Now().UnixMilli()
+
+	if err := h.repo.AddTenant(tenant); err != nil {
+		if errors.Is(err, ErrTenantExists) {
+			utility.RespondWithError(c, http.StatusConflict, err.Error())
+		} else {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	h.reload()
+
+	c.JSON(http.StatusCreated, tenant)
+}
+
+// UpdateTenant godoc
+//
+//	@Summary		Update a tenant
+//	@Description	Replaces the name, ingest keys, members and retention of a tenant, only for super admins
+//	@Tags			Tenants
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		string	true	"Tenant id"
+//	@Param			tenant	body		Tenant	true	"Tenant"
+//	@Success		200		{object}	Tenant
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/admin/tenants/{id} [put]
+func (h *TenantHandler) UpdateTenant(c *gin.Context) {
+	existing, exists := h.tenant(c.Param("id"))
+	if !exists {
+		utility.RespondWithError(c, http.StatusNotFound, "tenant not found")
+		return
+	}
+
+	var tenant Tenant
+	if err := c.BindJSON(&tenant); err != nil {
+		utility.RespondWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	tenant.ID = existing.ID
+	tenant.Created = existing.Created
+	if !h.validate(c, &tenant) {
+		return
+	}
+
+	if err := h.repo.SaveTenant(tenant); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utility.RespondWithError(c, http.StatusNotFound, "tenant not found")
+		} else {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	h.reload()
+
+	c.JSON(http.StatusOK, tenant)
+}
+
+// DeleteTenant godoc
+//
+//	@Summary		Delete a tenant
+//	@Description	Removes a tenant and releases its domains, the logs are kept. Only for super admins.
+//	@Tags			Tenants
+//	@Param			id	path	string	true	"Tenant id"
+//	@Success		204
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/admin/tenants/{id} [delete]
+func (h *TenantHandler) DeleteTenant(c *gin.Context) {
+	if err := h.repo.DeleteTenant(c.Param("id")); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			utility.RespondWithError(c, http.StatusNotFound, "tenant not found")
+		} else {
+			utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	h.reload()
+
+	c.Status(http.StatusNoContent)
+}
+
+// AssignDomain godoc
+//
+//	@Summary		Assign a domain to a tenant
+//	@Description	Makes the tenant the owner of a domain, taking it from its previous owner. Only for super admins.
+//	@Tags			Tenants
+//	@Param			id		path	string	true	"Tenant id"
+//	@Param			domain	path	string	true	"Domain name"
+//	@Success		204
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/admin/tenants/{id}/domains/{domain} [put]
+func (h *TenantHandler) AssignDomain(c *gin.Context) {
+	if _, exists := h.tenant(c.Param("id")); !exists {
+		utility.RespondWithError(c, http.StatusNotFound, "tenant not found")
+		return
+	}
+
+	if err := h.repo.AssignDomain(c.Param("domain"), c.Param("id")); err != nil {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.reload()
+
+	c.Status(http.StatusNoContent)
+}
+
+// ReleaseDomain godoc
+//
+//	@Summary		Release a domain of a tenant
+//	@Description	Removes the owner of a domain, the next tenant ingesting into it claims it. Only for super admins.
+//	@Tags			Tenants
+//	@Param			id		path	string	true	"Tenant id"
+//	@Param			domain	path	string	true	"Domain name"
+//	@Success		204
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/admin/tenants/{id}/domains/{domain} [delete]
+func (h *TenantHandler) ReleaseDomain(c *gin.Context) {
+	if !slices.Contains(h.registry.Domains(c.Param("id")), c.Param("domain")) {
+		utility.RespondWithError(c, http.StatusNotFound, "domain does not belong to the tenant")
+		return
+	}
+
+	if err := h.repo.ReleaseDomain(c.Param("domain")); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		utility.RespondWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.reload()
+
+	c.Status(http.StatusNoContent)
+}
+
+// validate checks the fields of a tenant, responding with an error when they are invalid
+func (h *TenantHandler) validate(c *gin.Context, tenant *Tenant) bool {
+	if tenant.Name == "" {
+		tenant.Name = tenant.ID
+	}
+	if tenant.IngestKeys == nil {
+		tenant.IngestKeys = make([]string, 0)
+	}
+	if tenant.Members == nil {
+		tenant.Members = make([]string, 0)
+	}
+	if tenant.Retention != "" && tenant.Retention != "off" {
+		if age, err := time.ParseDuration(tenant.Retention); err != nil || age <= 0 {
+			utility.RespondWithError(c, http.StatusBadRequest, "retention must be a duration like 720h or off")
+			return false
+		}
+	}
+
+	h.registry.mu.RLock()
+	defer h.registry.mu.RUnlock()
+	for _, key := range tenant.IngestKeys {
+		if key == "" {
+			utility.RespondWithError(c, http.StatusBadRequest, "ingest keys can not be empty")
+			return false
+		}
+		if owner, exists := h.registry.keys[key]; exists && owner != tenant.ID {
+			utility.RespondWithError(c, http.StatusConflict, ErrKeyInUse.Error())
+			return false
+		}
+	}
+	return true
+}
+
+func (h *TenantHandler) tenant(id string) (Tenant, bool) {
+	h.registry.mu.RLock()
+	defer h.registry.mu.RUnlock()
+	tenant, exists := h.registry.tenants[id]
+	return tenant, exists
+}
+
+// reload applies a change right away instead of at the next periodic reload
+func (h *TenantHandler) reload() {
+	if err := h.registry.reload(); err != nil {
+		log.Printf("Unable to reload tenants: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package tenancy
+
+import "errors"
+
+const (
+	// TenantCollection and DomainCollection start with an underscore to stay out of the domain list
+	TenantCollection = "_tenants"
+	DomainCollection = "_tenant_domains"
+)
+
+var (
+	// ErrUnknownKey is returned when logs are ingested without an ingest key of a tenant
+	ErrUnknownKey = errors.New("ingest key does not belong to a tenant")
+	// ErrDomainNotAllowed is returned when logs are ingested into a domain owned by another tenant,
+	// or into a domain holding logs that has no owner
+	ErrDomainNotAllowed = errors.New("domain belongs to another tenant")
+	// ErrTenantExists is returned when a tenant is created with an id that is taken
+	ErrTenantExists = errors.New("tenant already exists")
+	// ErrKeyInUse is returned when an ingest key is given to a tenant while another tenant uses it
+	ErrKeyInUse = errors.New("ingest key is used by another tenant")
+)
+
+// Config configures tenancy, super admins see and manage all tenants and are listed by user id or username
+type Config struct {
+	SuperAdmins    []string `mapstructure:"super_admins"`
+	ReloadInterval string   `mapstructure:"reload_interval"`
+}
+
+// Tenant is an organization owning domains, the ingest keys writing to them and the users reading them
+type Tenant struct {
+	ID         string   `json:"id" bson:"_id"`
+	Name       string   `json:"name" bson:"name"`
+	IngestKeys []string `json:"ingest_keys" bson:"ingest_keys"`
+	// Members are the users of the tenant, by user id or username
+	Members []string `json:"members" bson:"members"`
+	// Retention is the age after which the entries of the tenant's domains are archived, like "720h" or "off".
+	// When empty the archive settings in config.yml apply, an age set there for a single domain always wins.
+	Retention string `json:"retention,omitempty" bson:"retention,omitempty"`
+	Created   int64  `json:"created" bson:"created"`
+}
+
+// DomainOwner records the tenant a domain belongs to, a new domain is claimed by the first tenant ingesting into it
+type DomainOwner struct {
+	Domain string `json:"domain" bson:"_id"`
+	Tenant string `json:"tenant" bson:"tenant"`
+	Since  int64  `json:"since" bson:"since"`
+}
+
+// Caller is who a request was made by, a super admin may not belong to a tenant
+type Caller struct {
+	Tenant     string `json:"tenant,omitempty"`
+	User       string `json:"user,omitempty"`
+	SuperAdmin bool   `json:"super_admin"`
+}
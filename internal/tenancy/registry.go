@@ -0,0 +1,344 @@
+package tenancy
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gookit/config/v2"
+	"gofeather/internal/auth"
+	"gofeather/internal/constants"
+	"gofeather/internal/utility"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReloadInterval = time.Minute
+
+	callerContextKey = "tenancy_caller"
+)
+
+// Registry keeps the tenants, their ingest keys and members and the owners of the domains in memory.
+// It is reloaded periodically, so changes made through other instances are picked up.
+// A nil registry means tenancy is off: every caller may access every domain.
+type Registry struct {
+	repo        TenantRepository
+	superAdmins []string
+
+	mu      sync.RWMutex
+	tenants map[string]Tenant
+	keys    map[string]string
+	owners  map[string]string
+}
+
+// NewRegistry loads the tenants and domain owners and reloads them periodically in the background
+func NewRegistry(repo TenantRepository, cfg Config) *Registry {
+	registry := &Registry{repo: repo, superAdmins: cfg.SuperAdmins}
+	if err := registry.reload(); err != nil {
+		log.Printf("Unable to load tenants: %v", err)
+	}
+
+	go func() {
+		for range time.Tick(utility.DurationOrDefault(cfg.ReloadInterval, defaultReloadInterval)) {
+			if err := registry.reload(); err != nil {
+				log.Printf("Unable to reload tenants: %v", err)
+			}
+		}
+	}()
+	return registry
+}
+
+func LoadConfig() Config {
+	var tenancyConfig Config
+	if config.Exists(constants.Tenancy) {
+		if err := config.BindStruct(constants.Tenancy, &tenancyConfig); err != nil {
+			log.Printf("Unable to read tenancy settings from config: %v", err)
+		}
+	}
+	return tenancyConfig
+}
+
+func (r *Registry) reload() error {
+	tenantList, err := r.repo.GetTenants()
+	if err != nil {
+		return err
+	}
+	owners, err := r.repo.GetDomainOwners()
+	if err != nil {
+		return err
+	}
+
+	tenants := make(map[string]Tenant, len(tenantList))
+	keys := make(map[string]string)
+	for _, tenant := range tenantList {
+		tenants[tenant.ID] = tenant
+		for _, key := range tenant.IngestKeys {
+			keys[key] = tenant.ID
+		}
+	}
+	domains := make(map[string]string, len(owners))
+	for _, owner := range owners {
+		domains[owner.Domain] = owner.Tenant
+	}
+
+	r.mu.Lock()
+	r.tenants, r.keys, r.owners = tenants, keys, domains
+	r.mu.Unlock()
+	return nil
+}
+
+// Caller identifies who makes a request by the access token or, without one, by the ingest key.
+// It returns false when neither belongs to a tenant or a super admin.
+func (r *Registry) Caller(bearerToken string, key string) (Caller, bool) {
+	if bearerToken != "" {
+		user, err := auth.ParseAccessToken(bearerToken)
+		if err != nil {
+			return Caller{}, false
+		}
+		return r.callerForUser(user)
+	}
+	if key == "" {
+		return Caller{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tenant, exists := r.keys[key]
+	return Caller{Tenant: tenant}, exists
+}
+
+// callerForUser finds the tenant of a user, a user that is a member of several tenants acts for the first of them
+// by id, so the same user always gets the same tenant
+func (r *Registry) callerForUser(user auth.User) (Caller, bool) {
+	caller := Caller{User: user.Id, SuperAdmin: matchesUser(r.superAdmins, user)}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, tenant := range r.tenants {
+		if matchesUser(tenant.Members, user) && (caller.Tenant == "" || tenant.ID < caller.Tenant) {
+			caller.Tenant = tenant.ID
+		}
+	}
+	return caller, caller.Tenant != "" || caller.SuperAdmin
+}
+
+// CanAccess tells whether the caller may read and manage a domain, the domains of other tenants and unclaimed
+// domains are hidden from everyone but super admins
+func (r *Registry) CanAccess(caller Caller, domain string) bool {
+	if r == nil || caller.SuperAdmin {
+		return true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	owner, exists := r.owners[domain]
+	return exists && caller.Tenant != "" && owner == caller.Tenant
+}
+
+// Visible returns the domains the caller may access, keeping their order
+func (r *Registry) Visible(caller Caller, domains []string) []string {
+	if r == nil || caller.SuperAdmin {
+		return domains
+	}
+	visible := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if r.CanAccess(caller, domain) {
+			visible = append(visible, domain)
+		}
+	}
+	return visible
+}
+
+// Domains returns the domains owned by a tenant
+func (r *Registry) Domains(tenant string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	domains := make([]string, 0)
+	for domain, owner := range r.owners {
+		if owner == tenant {
+			domains = append(domains, domain)
+		}
+	}
+	slices.Sort(domains)
+	return domains
+}
+
+// AuthorizeIngest checks that the ingest key belongs to a tenant that may write to the domains. Domains without
+// an owner are allowed as long as they hold no logs, they are claimed by ClaimDomains once the entries are accepted.
+// Domains holding logs from before they had an owner have to be assigned to the tenant by a super admin.
+func (r *Registry) AuthorizeIngest(key string, domains []string) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	tenant, known := r.keys[key]
+	r.mu.RUnlock()
+	if key == "" || !known {
+		return ErrUnknownKey
+	}
+
+	for _, domain := range domains {
+		r.mu.RLock()
+		owner, claimed := r.owners[domain]
+		r.mu.RUnlock()
+
+		if claimed {
+			if owner != tenant {
+				return ErrDomainNotAllowed
+			}
+			continue
+		}
+		exists, err := r.repo.DomainExists(domain)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrDomainNotAllowed
+		}
+	}
+	return nil
+}
+
+// ClaimDomains makes the tenant of the ingest key the owner of the domains that don't have one yet,
+// it must be called after AuthorizeIngest allowed the domains
+func (r *Registry) ClaimDomains(key string, domains []string) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	tenant, known := r.keys[key]
+	r.mu.RUnlock()
+	if key == "" || !known {
+		return ErrUnknownKey
+	}
+
+	for _, domain := range domains {
+		r.mu.RLock()
+		owner, claimed := r.owners[domain]
+		r.mu.RUnlock()
+
+		if !claimed {
+			var err error
+			if owner, err = r.repo.ClaimDomain(domain, tenant); err != nil {
+				return err
+			}
+			r.mu.Lock()
+			r.owners[domain] = owner
+			r.mu.Unlock()
+			if owner == tenant {
+				log.Printf("Domain %s was claimed by tenant %s", domain, tenant)
+			}
+		}
+		//Another instance may have claimed the domain since it was authorized
+		if owner != tenant {
+			return ErrDomainNotAllowed
+		}
+	}
+	return nil
+}
+
+// Retention returns the archive age of the tenant owning a domain, false when the domain has no owner
+// or its tenant has no retention set
+func (r *Registry) Retention(domain string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tenant, exists := r.tenants[r.owners[domain]]
+	if !exists || tenant.Retention == "" {
+		return "", false
+	}
+	return tenant.Retention, true
+}
+
+// Authenticate aborts requests that are not made by a tenant member or super admin with a 401, the caller is
+// available to the next handlers through CallerFrom. The access token is read from the Authorization header.
+// Ingest keys only write logs, so they are refused here.
+func (r *Registry) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if r == nil {
+			c.Next()
+			return
+		}
+
+		bearerToken, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		caller, ok := r.Caller(bearerToken, "")
+		if !ok {
+			utility.RespondWithError(c, http.StatusUnauthorized, "a tenant member's access token is required")
+			c.Abort()
+			return
+		}
+
+		c.Set(callerContextKey, caller)
+		c.Next()
+	}
+}
+
+// RequireDomain responds with a 404 when the domain in the path is invalid or the caller may not access it, so the
+// domains of other tenants and internal collections can't be discovered. It must be used after Authenticate.
+func (r *Registry) RequireDomain() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := utility.ValidateDomain(c.Param("domain")); err != nil {
+			utility.RespondWithError(c, http.StatusNotFound, "domain not found")
+			c.Abort()
+			return
+		}
+		if r == nil {
+			c.Next()
+			return
+		}
+
+		if !r.CanAccess(CallerFrom(c), c.Param("domain")) {
+			utility.RespondWithError(c, http.StatusNotFound, "domain not found")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSuperAdmin responds with a 403 when the caller is not a super admin. It must be used after Authenticate.
+// Without tenancy there are no super admins, so any user with a valid access token is let through instead.
+func (r *Registry) RequireSuperAdmin() gin.HandlerFunc {
+	requireUser := auth.RequireUser()
+	return func(c *gin.Context) {
+		if r == nil {
+			requireUser(c)
+			return
+		}
+
+		if !CallerFrom(c).SuperAdmin {
+			utility.RespondWithError(c, http.StatusForbidden, "only super admins can do this")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// CallerFrom returns the caller set by Authenticate, an empty caller when tenancy is off
+func CallerFrom(c *gin.Context) Caller {
+	value, exists := c.Get(callerContextKey)
+	if !exists {
+		return Caller{}
+	}
+	caller, _ := value.(Caller)
+	return caller
+}
+
+// matchesUser tells whether the user is in a list of user ids and usernames
+func matchesUser(users []string, user auth.User) bool {
+	for _, entry := range users {
+		if entry == user.Id || (user.Username != "" && entry == user.Username) {
+			return true
+		}
+	}
+	return false
+}
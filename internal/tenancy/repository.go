@@ -0,0 +1,165 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gofeather/internal/utility"
+	"time"
+)
+
+type TenantRepository interface {
+	GetTenants() ([]Tenant, error)
+	AddTenant(tenant Tenant) error
+	SaveTenant(tenant Tenant) error
+	DeleteTenant(id string) error
+	GetDomainOwners() ([]DomainOwner, error)
+	ClaimDomain(domain string, tenant string) (string, error)
+	AssignDomain(domain string, tenant string) error
+	ReleaseDomain(domain string) error
+	DomainExists(domain string) (bool, error)
+}
+
+type MongoTenantRepository struct {
+	database *mongo.Database
+}
+
+func NewMongoTenantRepository(database *mongo.Database) *MongoTenantRepository {
+	return &MongoTenantRepository{database: database}
+}
+
+func (r *MongoTenantRepository) GetTenants() ([]Tenant, error) {
+	results := make([]Tenant, 0)
+	coll := r.database.Collection(TenantCollection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		cur, findErr := coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+func (r *MongoTenantRepository) AddTenant(tenant Tenant) error {
+	coll := r.database.Collection(TenantCollection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.InsertOne(ctx, tenant)
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrTenantExists
+		}
+		return err
+	})
+}
+
+func (r *MongoTenantRepository) SaveTenant(tenant Tenant) error {
+	coll := r.database.Collection(TenantCollection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		result, err := coll.ReplaceOne(ctx, bson.M{"_id": tenant.ID}, tenant)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return nil
+	})
+}
+
+// DeleteTenant removes a tenant and releases its domains, the logs in those domains are kept
+func (r *MongoTenantRepository) DeleteTenant(id string) error {
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		result, err := r.database.Collection(TenantCollection).DeleteOne(ctx, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		_, err = r.database.Collection(DomainCollection).DeleteMany(ctx, bson.M{"tenant": id})
+		return err
+	})
+}
+
+func (r *MongoTenantRepository) GetDomainOwners() ([]DomainOwner, error) {
+	results := make([]DomainOwner, 0)
+	coll := r.database.Collection(DomainCollection)
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		cur, findErr := coll.Find(ctx, bson.M{})
+		if findErr != nil {
+			return findErr
+		}
+		return cur.All(ctx, &results)
+	})
+
+	return results, err
+}
+
+// ClaimDomain makes the tenant the owner of a domain without one and returns the owner of the domain,
+// which is another tenant when it was claimed before
+func (r *MongoTenantRepository) ClaimDomain(domain string, tenant string) (string, error) {
+	coll := r.database.Collection(DomainCollection)
+	owner := tenant
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, insertErr := coll.InsertOne(ctx, DomainOwner{Domain: domain, Tenant: tenant, Since: time.Now().UnixMilli()})
+		if !mongo.IsDuplicateKeyError(insertErr) {
+			return insertErr
+		}
+
+		var existing DomainOwner
+		if findErr := coll.FindOne(ctx, bson.M{"_id": domain}).Decode(&existing); findErr != nil {
+			return errors.Join(insertErr, findErr)
+		}
+		owner = existing.Tenant
+		return nil
+	})
+
+	return owner, err
+}
+
+// AssignDomain makes the tenant the owner of a domain, whoever owned it before
+func (r *MongoTenantRepository) AssignDomain(domain string, tenant string) error {
+	coll := r.database.Collection(DomainCollection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		_, err := coll.ReplaceOne(ctx, bson.M{"_id": domain},
+			DomainOwner{Domain: domain, Tenant: tenant, Since: time.Now().UnixMilli()}, options.Replace().SetUpsert(true))
+		return err
+	})
+}
+
+func (r *MongoTenantRepository) ReleaseDomain(domain string) error {
+	coll := r.database.Collection(DomainCollection)
+
+	return utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		result, err := coll.DeleteOne(ctx, bson.M{"_id": domain})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return nil
+	})
+}
+
+// DomainExists tells whether a domain has a collection, which means logs were stored in it
+func (r *MongoTenantRepository) DomainExists(domain string) (bool, error) {
+	var exists bool
+
+	err := utility.ExecuteQueryWithTimeout(func(ctx context.Context) error {
+		names, err := r.database.ListCollectionNames(ctx, bson.M{"name": domain})
+		exists = len(names) > 0
+		return err
+	})
+
+	return exists, err
+}
@@ -0,0 +1,26 @@
+package tenancy
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateRoutes loads the tenants and registers the routes managing them, the returned registry scopes the other features
+func CreateRoutes(engine *gin.Engine, database *mongo.Database) *Registry {
+	tenantRepo := NewMongoTenantRepository(database)
+	registry := NewRegistry(tenantRepo, LoadConfig())
+	tenantHandler := NewTenantHandler(registry, tenantRepo)
+
+	engine.GET("/tenant", registry.Authenticate(), tenantHandler.GetCurrentTenant)
+
+	admin := engine.Group("/admin/tenants", registry.Authenticate(), registry.RequireSuperAdmin())
+	admin.GET("", tenantHandler.GetTenants)
+	admin.POST("", tenantHandler.CreateTenant)
+	admin.GET("/:id", tenantHandler.GetTenant)
+	admin.PUT("/:id", tenantHandler.UpdateTenant)
+	admin.DELETE("/:id", tenantHandler.DeleteTenant)
+	admin.PUT("/:id/domains/:domain", tenantHandler.AssignDomain)
+	admin.DELETE("/:id/domains/:domain", tenantHandler.ReleaseDomain)
+
+	return registry
+}
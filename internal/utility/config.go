@@ -0,0 +1,27 @@
+package utility
+
+import (
+	"log"
+	"time"
+)
+
+// ValueOrDefault falls back to the default for a setting that is missing from the configuration file or not positive
+func ValueOrDefault(value int, defaultValue int) int {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// DurationOrDefault parses a duration from the configuration file, falling back to the default when empty or invalid
+func DurationOrDefault(value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid duration %s in config, using %s", value, defaultValue)
+		return defaultValue
+	}
+	return duration
+}
@@ -0,0 +1,46 @@
+package utility
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxDomainLength keeps the namespace of a domain collection well below the limit of MongoDB
+const maxDomainLength = 120
+
+// ErrInvalidDomain is returned for domain names that can't be used as the collection of a domain
+var ErrInvalidDomain = errors.New("invalid domain")
+
+// reservedDomains are collections used by GoFeather itself that don't start with an underscore
+var reservedDomains = []string{"featureflags"}
+
+// ValidateDomain checks that a domain name is safe to use as a collection and a path segment. Names are made of
+// letters, digits, dots, dashes and underscores, they can't start with an underscore or dot, which are internal
+// collections, or be a reserved collection.
+func ValidateDomain(domain string) error {
+	switch {
+	case domain == "":
+		return fmt.Errorf("%w: the name is empty", ErrInvalidDomain)
+	case len(domain) > maxDomainLength:
+		return fmt.Errorf("%w: the name is longer than %d characters", ErrInvalidDomain, maxDomainLength)
+	case domain[0] == '_' || domain[0] == '.':
+		return fmt.Errorf("%w: %s starts with an underscore or dot", ErrInvalidDomain, domain)
+	case strings.Contains(domain, ".."):
+		return fmt.Errorf("%w: %s contains ..", ErrInvalidDomain, domain)
+	case strings.HasPrefix(domain, "system."):
+		return fmt.Errorf("%w: %s is a system collection", ErrInvalidDomain, domain)
+	}
+	for _, reserved := range reservedDomains {
+		if strings.EqualFold(domain, reserved) {
+			return fmt.Errorf("%w: %s is reserved", ErrInvalidDomain, domain)
+		}
+	}
+	for _, char := range domain {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' ||
+			char == '.' || char == '-' || char == '_') {
+			return fmt.Errorf("%w: %s may only hold letters, digits, dots, dashes and underscores", ErrInvalidDomain, domain)
+		}
+	}
+	return nil
+}
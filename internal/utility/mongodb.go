@@ -2,6 +2,8 @@ package utility
 
 import (
 	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
 	"log"
 	"time"
 )
@@ -20,3 +22,27 @@ func ExecuteQueryWithTimeout(op func(ctx context.Context) error) error {
 	}
 	return nil
 }
+
+// OnlyDuplicateKeys tells whether every write of an insert failed because the document already exists, which
+// includes inserts into several collections of which the errors are joined. Any other error makes it false.
+func OnlyDuplicateKeys(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, inner := range joined.Unwrap() {
+			if !OnlyDuplicateKeys(inner) {
+				return false
+			}
+		}
+		return len(joined.Unwrap()) > 0
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return false
+	}
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return false
+		}
+	}
+	return true
+}
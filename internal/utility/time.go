@@ -0,0 +1,22 @@
+package utility
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ParseTime parses unix milliseconds or an RFC 3339 time, falling back to the default when empty
+func ParseTime(value string, defaultValue int64) (int64, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return millis, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, errors.New("must be unix milliseconds or an RFC 3339 time")
+	}
+	return parsed.UnixMilli(), nil
+}